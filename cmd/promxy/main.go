@@ -46,13 +46,23 @@ import (
 	"github.com/prometheus/prometheus/rules"
 	"github.com/prometheus/prometheus/scrape"
 	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/util/httputil"
 	"github.com/prometheus/prometheus/util/strutil"
 	"github.com/prometheus/prometheus/web"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 
 	proxyconfig "github.com/jacksontj/promxy/pkg/config"
+	"github.com/jacksontj/promxy/pkg/federate"
+	"github.com/jacksontj/promxy/pkg/limits"
+	"github.com/jacksontj/promxy/pkg/localeval"
 	"github.com/jacksontj/promxy/pkg/logging"
 	"github.com/jacksontj/promxy/pkg/proxystorage"
+	"github.com/jacksontj/promxy/pkg/querystats"
+	"github.com/jacksontj/promxy/pkg/sourcelabel"
+	"github.com/jacksontj/promxy/pkg/storeapi"
+	"github.com/jacksontj/promxy/pkg/storepb"
 )
 
 var (
@@ -86,6 +96,8 @@ type cliOpts struct {
 	WebCORSOriginRegex string        `long:"web.cors.origin" description:"Regex for CORS origin. It is fully anchored." default:".*"`
 	WebReadTimeout     time.Duration `long:"web.read-timeout" description:"Maximum duration before timing out read of the request, and closing idle connections." default:"5m"`
 
+	StoreAPIBindAddr string `long:"storeapi.bind-addr" description:"address to serve the Thanos StoreAPI (gRPC) on, so Thanos can use promxy as a store (disabled if empty). It uses the TLS of --web.config.file (plaintext without it)"`
+
 	MetricsPath string `long:"metrics-path" description:"URL path for the prometheus metrics endpoint." default:"/metrics"`
 
 	ExternalURL     string `long:"web.external-url" description:"The URL under which Prometheus is externally reachable (for example, if Prometheus is served via a reverse proxy). Used for generating relative and absolute links back to Prometheus itself. If the URL has a path portion, it will be used to prefix all HTTP endpoints served by Prometheus. If omitted, relevant URL components will be derived automatically."`
@@ -95,12 +107,16 @@ type cliOpts struct {
 	QueryMaxSamples     int           `long:"query.max-samples" description:"Maximum number of samples a single query can load into memory. Note that queries will fail if they would load more samples than this into memory, so this also limits the number of samples a query can return." default:"50000000"`
 	QueryLookbackDelta  time.Duration `long:"query.lookback-delta" description:"The maximum lookback duration for retrieving metrics during expression evaluations." default:"5m"`
 	QueryMaxConcurrency int           `long:"query.max-concurrency" default:"-1" description:"Maximum number of queries executed concurrently."`
-	LocalStoragePath    string        `long:"storage.tsdb.path" description:"Base path for metrics storage."`
+	QueryMaxQueueLength int           `long:"query.max-queue-length" default:"100" description:"Maximum number of queries waiting for a slot when query.max-concurrency is reached."`
+	QueryQueueTimeout   time.Duration `long:"query.queue-timeout" default:"1m" description:"Maximum time a query may wait for a slot when query.max-concurrency is reached."`
+	LocalStoragePath    string        `long:"storage.tsdb.path" description:"Base path for metrics storage. If set, active queries are logged here so they can be reported after a crash."`
 
 	RemoteReadMaxConcurrency int `long:"remote-read.max-concurrency" description:"Maximum number of concurrent remote read calls." default:"10"`
 
 	NotificationQueueCapacity int           `long:"alertmanager.notification-queue-capacity" description:"The capacity of the queue for pending alert manager notifications." default:"10000"`
 	AccessLogDestination      string        `long:"access-log-destination" description:"where to log access logs, options (none, stderr, stdout)" default:"stdout"`
+	QueryLogFile              string        `long:"query-log.file" description:"File to log slow queries to as JSON lines (disabled if empty). The file is re-opened on SIGHUP to allow for rotation."`
+	QueryLogThreshold         time.Duration `long:"query-log.threshold" description:"Minimum duration of a query for it to be written to the query log." default:"10s"`
 	ForOutageTolerance        time.Duration `long:"rules.alert.for-outage-tolerance" description:"Max time to tolerate prometheus outage for restoring for state of alert." default:"1h"`
 	ForGracePeriod            time.Duration `long:"rules.alert.for-grace-period" description:"Minimum duration between alert and restored for state. This is maintained only for alerts with configured for time greater than grace period." default:"10m"`
 	ResendDelay               time.Duration `long:"rules.alert.resend-delay" description:"Minimum amount of time to wait before resending an alert to Alertmanager." default:"1m"`
@@ -243,14 +259,16 @@ func main() {
 		LookbackDelta:            opts.QueryLookbackDelta,
 	}
 
-	if opts.QueryMaxConcurrency != -1 {
-		if opts.LocalStoragePath == "" {
-			logrus.Fatalf("local storage path must be defined if you wish to enable max query concurrency limits")
-		}
+	// The concurrency limit is enforced in-memory (with a fair queue across client identities),
+	// the ActiveQueryTracker is only used (if we have somewhere to put it) to log in-flight queries
+	concurrencyLimiter := limits.NewConcurrencyLimiter(opts.QueryMaxConcurrency, opts.QueryMaxQueueLength, opts.QueryQueueTimeout)
+	if opts.QueryMaxConcurrency != -1 && opts.LocalStoragePath != "" {
 		engineOpts.ActiveQueryTracker = promql.NewActiveQueryTracker(opts.LocalStoragePath, opts.QueryMaxConcurrency, kitlog.With(logger, "component", "activeQueryTracker"))
 	}
 
 	engine := promql.NewEngine(engineOpts)
+	// Queries evaluated in promxy for servergroups (e.g. thanos_store) use the same limits
+	localeval.SetEngineOpts(engineOpts)
 	engine.NodeReplacer = ps.NodeReplacer
 
 	externalUrl, err := computeExternalURL(opts.ExternalURL, opts.BindAddr)
@@ -296,7 +314,7 @@ func main() {
 	ruleManager := rules.NewManager(&rules.ManagerOptions{
 		Context:         ctx,         // base context for all background tasks
 		ExternalURL:     externalUrl, // URL listed as URL for "who fired this alert"
-		QueryFunc:       rules.EngineQueryFunc(engine, proxyStorage),
+		QueryFunc:       limitQueryFunc(concurrencyLimiter, rules.EngineQueryFunc(engine, proxyStorage)),
 		NotifyFunc:      sendAlerts(notifierManager, externalUrl.String()),
 		Appendable:      proxyStorage,
 		Queryable:       proxyStorage,
@@ -389,13 +407,34 @@ func main() {
 	// Register API endpoint with correct route prefix
 	webHandler.Getv1API().Register(webHandler.GetRouter().WithPrefix(apiPrefix))
 
+	// Per-client rate limits for the query APIs
+	rateLimiter := limits.NewRateLimiter(apiPrefix)
+	reloadables = append(reloadables, &proxyconfig.PromxyApplyConfigFunc{F: func(cfg *proxyconfig.Config) error {
+		return rateLimiter.ApplyConfig(cfg.RateLimit)
+	}})
+
+	// Slow query log
+	var queryLogger *logging.QueryLogger
+	if opts.QueryLogFile != "" {
+		queryLogger, err = logging.NewQueryLogger(opts.QueryLogFile, opts.QueryLogThreshold)
+		if err != nil {
+			logrus.Fatalf("Error opening query log: %v", err)
+		}
+	}
+
 	// Create our router
 	r := httprouter.New()
 
 	r.HandlerFunc("GET", opts.MetricsPath, promhttp.Handler().ServeHTTP)
 
+	// Federate from promxy's storage (instead of prometheus' handler) so that series
+	// from all servergroups are merged and OpenMetrics can be negotiated
+	federateHandler := federate.NewHandler(ps, opts.QueryLookbackDelta)
+	reloadables = append(reloadables, proxyconfig.WrapPromReloadable(federateHandler))
+	r.Handler("GET", path.Join(webOptions.RoutePrefix, "/federate"), httputil.CompressionHandler{Handler: federateHandler})
+
 	stopping := false
-	r.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	r.NotFound = rateLimiter.Handler(concurrencyLimiter.Handler(apiPrefix, queryLogger.Handler(apiPrefix, querystats.Handler(apiPrefix, sourcelabel.Handler(apiPrefix, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Have our fallback rules
 		if strings.HasPrefix(r.URL.Path, path.Join(webOptions.RoutePrefix, "/debug")) {
 			http.DefaultServeMux.ServeHTTP(w, r)
@@ -410,7 +449,7 @@ func main() {
 			// all else we send direct to the local prometheus UI
 			webHandler.GetRouter().ServeHTTP(w, r)
 		}
-	})
+	}))))))
 
 	if err := reloadConfig(noStepSubqueryInterval, reloadables...); err != nil {
 		logrus.Fatalf("Error loading config: %s", err)
@@ -438,6 +477,30 @@ func main() {
 		logrus.Fatalf("Error creating server: %v", err)
 	}
 
+	var storeSrv *grpc.Server
+	if opts.StoreAPIBindAddr != "" {
+		lis, err := net.Listen("tcp", opts.StoreAPIBindAddr)
+		if err != nil {
+			logrus.Fatalf("Error listening for StoreAPI: %v", err)
+		}
+		grpcOpts := []grpc.ServerOption{grpc.CustomCodec(storepb.Codec{})}
+		// The StoreAPI is served with the same TLS (and client auth) as the HTTP server
+		if opts.WebConfigFile != "" {
+			tlsConfig, err := server.TLSConfig(opts.WebConfigFile)
+			if err != nil {
+				logrus.Fatalf("Error loading TLS config for StoreAPI: %v", err)
+			}
+			grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+		}
+		storeSrv = grpc.NewServer(grpcOpts...)
+		storepb.RegisterStoreServer(storeSrv, storeapi.NewServer(ps, ps.ExternalLabelSets))
+		go func() {
+			if err := storeSrv.Serve(lis); err != nil {
+				logrus.Errorf("Error serving StoreAPI: %v", err)
+			}
+		}()
+	}
+
 	// wait for signals etc.
 	for {
 		select {
@@ -452,6 +515,11 @@ func main() {
 		case sig := <-sigs:
 			switch sig {
 			case syscall.SIGHUP:
+				if queryLogger != nil {
+					if err := queryLogger.Reopen(); err != nil {
+						log.Errorf("Error reopening query log: %s", err)
+					}
+				}
 				log.Infof("Reloading config")
 				if err := reloadConfig(noStepSubqueryInterval, reloadables...); err != nil {
 					log.Errorf("Error reloading config: %s", err)
@@ -474,6 +542,9 @@ func main() {
 					ctx, cancel = context.WithTimeout(ctx, opts.ShutdownTimeout)
 					defer cancel()
 				}
+				if storeSrv != nil {
+					storeSrv.GracefulStop()
+				}
 				srv.Shutdown(ctx)
 				return
 			default:
@@ -513,6 +584,22 @@ func sendAlerts(n *notifier.Manager, externalURL string) rules.NotifyFunc {
 	}
 }
 
+// limitQueryFunc wraps the rules.QueryFunc so rule evaluations share the
+// concurrency limit with API queries
+func limitQueryFunc(c *limits.ConcurrencyLimiter, f rules.QueryFunc) rules.QueryFunc {
+	if !c.Enabled() {
+		return f
+	}
+	return func(ctx context.Context, q string, t time.Time) (promql.Vector, error) {
+		release, err := c.Acquire(ctx, "rules")
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+		return f(ctx, q, t)
+	}
+}
+
 func startsOrEndsWithQuote(s string) bool {
 	return strings.HasPrefix(s, "\"") || strings.HasPrefix(s, "'") ||
 		strings.HasSuffix(s, "\"") || strings.HasSuffix(s, "'")
@@ -20,6 +20,7 @@ require (
 	github.com/stretchr/testify v1.6.1
 	go.uber.org/atomic v1.7.0
 	golang.org/x/time v0.0.0-20201208040808-7e3f01d25324
+	google.golang.org/grpc v1.33.2
 	gopkg.in/yaml.v2 v2.4.0
 	k8s.io/klog v1.0.0
 )
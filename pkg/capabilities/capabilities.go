@@ -0,0 +1,190 @@
+// Package capabilities detects which PromQL features a downstream prometheus supports (from
+// its version) so that we only push down queries that the downstream can evaluate
+package capabilities
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Subquery is the feature name of subqueries (e.g. `rate(foo[5m])[1h:1m]`)
+const Subquery = "subquery"
+
+// minVersions is the first prometheus version supporting each feature (functions and aggregations
+// are named as in PromQL). This includes functions that are newer than our own PromQL parser, so
+// that they are handled once it supports them
+var minVersions = map[string]version{
+	Subquery:            {2, 7, 0},
+	"absent_over_time":  {2, 16, 0},
+	"group":             {2, 20, 0},
+	"last_over_time":    {2, 26, 0},
+	"clamp":             {2, 26, 0},
+	"sgn":               {2, 26, 0},
+	"present_over_time": {2, 29, 0},
+}
+
+// version is a prometheus version (major, minor, patch)
+type version [3]int
+
+func (v version) less(o version) bool {
+	for i := range v {
+		if v[i] != o[i] {
+			return v[i] < o[i]
+		}
+	}
+	return false
+}
+
+// parseVersion parses a version such as `2.20.1` (ignoring any `v` prefix and
+// pre-release or build suffix)
+func parseVersion(s string) (version, error) {
+	var v version
+	trimmed := strings.TrimPrefix(s, "v")
+	if i := strings.IndexAny(trimmed, "-+"); i >= 0 {
+		trimmed = trimmed[:i]
+	}
+	parts := strings.Split(trimmed, ".")
+	if len(parts) != len(v) {
+		return v, fmt.Errorf("invalid version %q", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil {
+			return v, fmt.Errorf("invalid version %q: %v", s, err)
+		}
+		v[i] = n
+	}
+	return v, nil
+}
+
+// Set is the set of features (of the ones that not all prometheus versions support) that a
+// downstream supports. A nil Set is an unknown downstream, which we assume supports everything
+type Set map[string]struct{}
+
+// ForVersion returns the Set supported by the prometheus version v
+func ForVersion(v string) (Set, error) {
+	parsed, err := parseVersion(v)
+	if err != nil {
+		return nil, err
+	}
+	s := make(Set, len(minVersions))
+	for feature, min := range minVersions {
+		if !parsed.less(min) {
+			s[feature] = struct{}{}
+		}
+	}
+	return s, nil
+}
+
+// Intersect returns the features supported by all of sets (ignoring unknown, nil, sets)
+func Intersect(sets ...Set) Set {
+	var ret Set
+	for _, s := range sets {
+		if s == nil {
+			continue
+		}
+		if ret == nil {
+			ret = make(Set, len(s))
+			for feature := range s {
+				ret[feature] = struct{}{}
+			}
+			continue
+		}
+		for feature := range ret {
+			if _, ok := s[feature]; !ok {
+				delete(ret, feature)
+			}
+		}
+	}
+	return ret
+}
+
+// supports returns whether feature is supported (features that all versions support always are)
+func (s Set) supports(feature string) bool {
+	if s == nil {
+		return true
+	}
+	if _, ok := minVersions[feature]; !ok {
+		return true
+	}
+	_, ok := s[feature]
+	return ok
+}
+
+// Supports returns whether all the features used by expr are supported
+func (s Set) Supports(expr parser.Expr) bool {
+	if s == nil {
+		return true
+	}
+	supported := true
+	parser.Inspect(context.Background(), &parser.EvalStmt{Expr: expr}, func(node parser.Node, _ []parser.Node) error {
+		var feature string
+		switch n := node.(type) {
+		case *parser.Call:
+			feature = n.Func.Name
+		case *parser.AggregateExpr:
+			feature = n.Op.String()
+		case *parser.SubqueryExpr:
+			feature = Subquery
+		default:
+			return nil
+		}
+		if !s.supports(feature) {
+			supported = false
+			return fmt.Errorf("%s is not supported", feature)
+		}
+		return nil
+	}, nil)
+	return supported
+}
+
+// buildInfoResponse is the response of prometheus' buildinfo API
+type buildInfoResponse struct {
+	Status string `json:"status"`
+	Data   struct {
+		Version string `json:"version"`
+	} `json:"data"`
+}
+
+// Probe returns the Set of the prometheus host at u from its buildinfo API. Other implementations
+// of the prometheus API (e.g. Thanos, Cortex or VictoriaMetrics) have their own version numbers
+// (or no buildinfo API at all), so unless the host is clearly prometheus 2.x the Set is nil
+func Probe(ctx context.Context, client *http.Client, u url.URL) (Set, error) {
+	u.Path = path.Join(u.Path, "api/v1/status/buildinfo")
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	// The buildinfo API was added in 2.14, but hosts without it aren't necessarily prometheus
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, u.String())
+	}
+
+	var info buildInfoResponse
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	if info.Status != "success" {
+		return nil, fmt.Errorf("unexpected status %q from %s", info.Status, u.String())
+	}
+	if v, err := parseVersion(info.Data.Version); err != nil || v[0] != 2 {
+		return nil, nil
+	}
+	return ForVersion(info.Data.Version)
+}
@@ -0,0 +1,97 @@
+package capabilities
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+func TestSupports(t *testing.T) {
+	tests := []struct {
+		version   string
+		query     string
+		supported bool
+	}{
+		{version: "2.19.3", query: `sum(rate(foo[5m]))`, supported: true},
+		{version: "2.19.3", query: `sum(group(foo))`, supported: false},
+		{version: "v2.20.0-rc.1", query: `sum(group(foo))`, supported: true},
+		{version: "2.15.0", query: `absent_over_time(foo[5m])`, supported: false},
+		{version: "2.6.1", query: `max_over_time(rate(foo[5m])[1h:1m])`, supported: false},
+		{version: "2.7.0", query: `max_over_time(rate(foo[5m])[1h:1m])`, supported: true},
+	}
+
+	for _, test := range tests {
+		set, err := ForVersion(test.version)
+		if err != nil {
+			t.Fatalf("Error parsing version %s: %v", test.version, err)
+		}
+		expr, err := parser.ParseExpr(test.query)
+		if err != nil {
+			t.Fatalf("Error parsing query %s: %v", test.query, err)
+		}
+		if supported := set.Supports(expr); supported != test.supported {
+			t.Fatalf("%s %s: expected supported=%v, got %v", test.version, test.query, test.supported, supported)
+		}
+	}
+
+	if _, err := ForVersion("latest"); err == nil {
+		t.Fatalf("Expected error for invalid version")
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	older, _ := ForVersion("2.19.0")
+	newer, _ := ForVersion("2.30.0")
+	expr, _ := parser.ParseExpr(`group(foo)`)
+
+	if !newer.Supports(expr) || Intersect(older, newer).Supports(expr) {
+		t.Fatalf("Expected group() to only be supported by the newer version")
+	}
+	// Unknown hosts are ignored
+	if !Intersect(nil, newer).Supports(expr) || Intersect(nil, nil) != nil {
+		t.Fatalf("Expected unknown sets to be ignored")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/new/api/v1/status/buildinfo":
+			w.Write([]byte(`{"status":"success","data":{"version":"2.22.0","revision":"abc"}}`))
+		case "/thanos/api/v1/status/buildinfo":
+			w.Write([]byte(`{"status":"success","data":{"version":"0.20.0","revision":"abc"}}`))
+		case "/custom/api/v1/status/buildinfo":
+			w.Write([]byte(`{"status":"success","data":{"version":"v1.60.0-cluster","revision":"abc"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+	expr, _ := parser.ParseExpr(`group(foo)`)
+
+	u.Path = "/new"
+	set, err := Probe(context.Background(), srv.Client(), *u)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !set.Supports(expr) {
+		t.Fatalf("Expected group() to be supported by 2.22.0")
+	}
+
+	// Other implementations of the prometheus API (even if they have no buildinfo API) are unknown
+	for _, p := range []string{"/thanos", "/custom", "/old"} {
+		u.Path = p
+		set, err = Probe(context.Background(), srv.Client(), *u)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if set != nil || !set.Supports(expr) {
+			t.Fatalf("Expected %s to be unknown (supporting everything), got %v", p, set)
+		}
+	}
+}
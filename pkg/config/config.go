@@ -3,11 +3,14 @@ package proxyconfig
 import (
 	"fmt"
 	"io/ioutil"
+	"reflect"
+	"strconv"
 
 	"github.com/prometheus/exporter-toolkit/web"
 
 	"github.com/prometheus/prometheus/config"
 
+	"github.com/jacksontj/promxy/pkg/limits"
 	"github.com/jacksontj/promxy/pkg/servergroup"
 
 	yaml "gopkg.in/yaml.v2"
@@ -32,6 +35,9 @@ func ConfigFromFile(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error unmarshaling config: %v", err)
 	}
+	if err := cfg.PromxyConfig.validate(); err != nil {
+		return nil, fmt.Errorf("error validating config: %v", err)
+	}
 
 	return cfg, nil
 }
@@ -54,4 +60,68 @@ type Config struct {
 type PromxyConfig struct {
 	// Config for each of the server groups promxy is configured to aggregate
 	ServerGroups []*servergroup.Config `yaml:"server_groups"`
+
+	// RateLimit defines per-client rate limits for the query APIs
+	RateLimit *limits.RateLimitConfig `yaml:"rate_limit"`
+
+	// ServerGroupMinSuccess is the number of servergroups (counting each replica_group and tier_group once)
+	// that must respond successfully for a query to succeed. If unset all of them are required.
+	// If it is set and some servergroups fail but the quorum is met the query succeeds with a warning
+	ServerGroupMinSuccess int `yaml:"server_group_min_success"`
+}
+
+// GetServerGroupMinSuccess returns the number of servergroups that must respond successfully
+// out of total
+func (c *PromxyConfig) GetServerGroupMinSuccess(total int) int {
+	if c.ServerGroupMinSuccess == 0 {
+		return total
+	}
+	return c.ServerGroupMinSuccess
+}
+
+// validate checks the promxy config and fills in defaults that depend on the whole
+// config (such as the servergroup names)
+func (c *PromxyConfig) validate() error {
+	names := make(map[string]struct{}, len(c.ServerGroups))
+	for i, sg := range c.ServerGroups {
+		if sg.Name == "" {
+			sg.Name = strconv.Itoa(i)
+		}
+		if _, ok := names[sg.Name]; ok {
+			return fmt.Errorf("duplicate servergroup name %q", sg.Name)
+		}
+		names[sg.Name] = struct{}{}
+	}
+
+	// Servergroups in a replica_group (or tier_group) only count once towards the quorum
+	total := 0
+	replicaGroups := make(map[string]*servergroup.Config)
+	tierGroups := make(map[string]struct{})
+	for _, sg := range c.ServerGroups {
+		if sg.ReplicaGroup != "" {
+			if first, ok := replicaGroups[sg.ReplicaGroup]; ok {
+				// The series of the members have to be the same to be merged, and they are
+				// merged with the settings of the first member
+				if !sg.Labels.Equal(first.Labels) {
+					return fmt.Errorf("servergroups %q and %q in replica_group %q have different labels", first.Name, sg.Name, sg.ReplicaGroup)
+				}
+				if !reflect.DeepEqual(sg.Dedup, first.Dedup) || sg.AntiAffinity != first.AntiAffinity {
+					return fmt.Errorf("servergroups %q and %q in replica_group %q have different dedup settings", first.Name, sg.Name, sg.ReplicaGroup)
+				}
+				continue
+			}
+			replicaGroups[sg.ReplicaGroup] = sg
+		}
+		if sg.TierGroup != "" {
+			if _, ok := tierGroups[sg.TierGroup]; ok {
+				continue
+			}
+			tierGroups[sg.TierGroup] = struct{}{}
+		}
+		total++
+	}
+	if c.ServerGroupMinSuccess < 0 || c.ServerGroupMinSuccess > total {
+		return fmt.Errorf("server_group_min_success must be between 0 and the number of servergroups (%d), got %d", total, c.ServerGroupMinSuccess)
+	}
+	return nil
 }
@@ -4,6 +4,11 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/jacksontj/promxy/pkg/servergroup"
 )
 
 func TestConfigFromFile(t *testing.T) {
@@ -40,3 +45,370 @@ tls_server_config:
 		t.Errorf("Invalid ClientCAs. Expected 'tls-ca-chain.pem', Got '%s'", cfg.WebConfig.ClientCAs)
 	}
 }
+
+func TestServerGroupNames(t *testing.T) {
+	tests := []struct {
+		config string
+		names  []string
+		err    bool
+	}{
+		{
+			config: `
+promxy:
+  server_groups:
+    - name: primary
+    - {}
+`,
+			names: []string{"primary", "1"},
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - name: a
+    - name: a
+`,
+			err: true,
+		},
+		// Servergroups in the same replica_group count once towards the quorum
+		{
+			config: `
+promxy:
+  server_group_min_success: 2
+  server_groups:
+    - replica_group: a
+    - replica_group: a
+    - min_success: 2
+`,
+			names: []string{"0", "1", "2"},
+		},
+		{
+			config: `
+promxy:
+  server_group_min_success: 3
+  server_groups:
+    - replica_group: a
+    - replica_group: a
+    - {}
+`,
+			err: true,
+		},
+		// Members of a replica_group must have the same labels and dedup settings
+		{
+			config: `
+promxy:
+  server_groups:
+    - replica_group: a
+      labels: {sg: a}
+    - replica_group: a
+      labels: {sg: b}
+`,
+			err: true,
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - replica_group: a
+      anti_affinity: 10s
+    - replica_group: a
+      anti_affinity: 30s
+`,
+			err: true,
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - replica_group: a
+      dedup: {strategy: sticky}
+    - replica_group: a
+`,
+			err: true,
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - replica_group: a
+      labels: {sg: a}
+      dedup: {strategy: sticky}
+    - replica_group: a
+      labels: {sg: a}
+      dedup: {strategy: sticky}
+`,
+			names: []string{"0", "1"},
+		},
+		// Servergroups in the same tier_group also count once
+		{
+			config: `
+promxy:
+  server_group_min_success: 3
+  server_groups:
+    - tier_group: a
+    - tier_group: a
+    - {}
+`,
+			err: true,
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - tier_group: a
+      replica_group: b
+`,
+			err: true,
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - resolution: 5m
+`,
+			err: true,
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - tier_group: a
+    - tier_group: a
+      resolution: 5m
+`,
+			names: []string{"0", "1"},
+		},
+		{
+			config: `
+promxy:
+  server_groups:
+    - min_success: 0
+`,
+			err: true,
+		},
+	}
+
+	for i, test := range tests {
+		file, err := ioutil.TempFile(os.TempDir(), "")
+		if err != nil {
+			t.Fatalf("Could not create temp file: %v", err)
+		}
+		defer os.Remove(file.Name())
+		file.Write([]byte(test.config))
+		file.Close()
+
+		cfg, err := ConfigFromFile(file.Name())
+		if (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		for j, name := range test.names {
+			if cfg.ServerGroups[j].Name != name {
+				t.Fatalf("%d: mismatch in name expected=%s actual=%s", i, name, cfg.ServerGroups[j].Name)
+			}
+		}
+	}
+}
+
+func TestServerGroupAntiAffinity(t *testing.T) {
+	tests := []struct {
+		config       string
+		antiAffinity servergroup.AntiAffinity
+		err          bool
+	}{
+		{
+			config:       "anti_affinity: 30s",
+			antiAffinity: servergroup.AntiAffinity{Duration: 30 * time.Second},
+		},
+		{
+			config:       "anti_affinity: auto",
+			antiAffinity: servergroup.AntiAffinity{Duration: 10 * time.Second, Auto: true},
+		},
+		{
+			config:       "anti_affinity: auto:1m",
+			antiAffinity: servergroup.AntiAffinity{Duration: time.Minute, Auto: true},
+		},
+		{
+			config: "anti_affinity: sometimes",
+			err:    true,
+		},
+	}
+
+	for i, test := range tests {
+		var cfg servergroup.Config
+		err := yaml.Unmarshal([]byte(test.config), &cfg)
+		if (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+		if err == nil && cfg.AntiAffinity != test.antiAffinity {
+			t.Fatalf("%d: mismatch in anti_affinity expected=%v actual=%v", i, test.antiAffinity, cfg.AntiAffinity)
+		}
+	}
+}
+
+func TestServerGroupLocalEval(t *testing.T) {
+	tests := []struct {
+		config    string
+		localEval bool
+		err       bool
+	}{
+		{
+			config: "name: a",
+		},
+		{
+			config:    "pushdown: false",
+			localEval: true,
+		},
+		{
+			config:    "type: thanos_store",
+			localEval: true,
+		},
+		{
+			config:    "type: remote_read",
+			localEval: true,
+		},
+		{
+			config:    "{type: remote_read, remote_read_labels_lookback: 10m}",
+			localEval: true,
+		},
+		{
+			config: "{type: remote_read, remote_read_labels_lookback: -10m}",
+			err:    true,
+		},
+		{
+			config: "type: graphite",
+			err:    true,
+		},
+		{
+			config: "{type: file, file: {path: owners.csv}}",
+		},
+		{
+			config: "{type: file, file: {path: owners, format: csv, metric_name: service_owner_info}}",
+		},
+		{
+			config: "type: file",
+			err:    true,
+		},
+		{
+			config: "file: {path: owners.csv}",
+			err:    true,
+		},
+		{
+			config: "{type: file, file: {path: owners.json}}",
+			err:    true,
+		},
+		{
+			config: "{type: file, file: {path: owners.csv, metric_name: service-owner}}",
+			err:    true,
+		},
+		{
+			config: "{type: file, file: {path: owners.csv}, static_configs: [{targets: [localhost:9090]}]}",
+			err:    true,
+		},
+	}
+
+	for i, test := range tests {
+		var cfg servergroup.Config
+		err := yaml.Unmarshal([]byte(test.config), &cfg)
+		if (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+		if err == nil && cfg.LocalEval() != test.localEval {
+			t.Fatalf("%d: mismatch in local eval expected=%v actual=%v", i, test.localEval, cfg.LocalEval())
+		}
+	}
+}
+
+func TestServerGroupAutoTimeRange(t *testing.T) {
+	tests := []struct {
+		config          string
+		refreshInterval time.Duration
+		err             bool
+	}{
+		{
+			config: "name: a",
+		},
+		{
+			config:          "auto_time_range: {}",
+			refreshInterval: servergroup.DefaultAutoTimeRangeRefreshInterval,
+		},
+		{
+			config:          "auto_time_range: {refresh_interval: 1m}",
+			refreshInterval: time.Minute,
+		},
+		{
+			config: "auto_time_range: {refresh_interval: 0s}",
+			err:    true,
+		},
+		// Only prometheus hosts have TSDB metrics to discover the time range from
+		{
+			config: "{type: thanos_store, auto_time_range: {refresh_interval: 1m}}",
+			err:    true,
+		},
+	}
+
+	for i, test := range tests {
+		var cfg servergroup.Config
+		err := yaml.Unmarshal([]byte(test.config), &cfg)
+		if (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		var refreshInterval time.Duration
+		if cfg.AutoTimeRangeConfig != nil {
+			refreshInterval = cfg.AutoTimeRangeConfig.RefreshInterval
+		}
+		if refreshInterval != test.refreshInterval {
+			t.Fatalf("%d: mismatch in refresh interval expected=%v actual=%v", i, test.refreshInterval, refreshInterval)
+		}
+	}
+}
+
+func TestServerGroupDedup(t *testing.T) {
+	tests := []struct {
+		config   string
+		strategy servergroup.DedupStrategy
+		err      bool
+	}{
+		// The strategy defaults to gap_fill
+		{
+			config:   "dedup: {}",
+			strategy: servergroup.DedupGapFill,
+		},
+		{
+			config:   "dedup: {counter_reset_aware: true}",
+			strategy: servergroup.DedupGapFill,
+		},
+		{
+			config:   "dedup: {strategy: aggregate, aggregate: max}",
+			strategy: servergroup.DedupAggregate,
+		},
+		{
+			config: "dedup: {strategy: penalty, counter_reset_aware: true}",
+			err:    true,
+		},
+		{
+			config: "dedup: {strategy: aggregate}",
+			err:    true,
+		},
+		{
+			config: "dedup: {strategy: random}",
+			err:    true,
+		},
+	}
+
+	for i, test := range tests {
+		var cfg servergroup.Config
+		err := yaml.Unmarshal([]byte(test.config), &cfg)
+		if (err != nil) != test.err {
+			t.Fatalf("%d: mismatch in error expected=%v actual=%v", i, test.err, err)
+		}
+		if err == nil && cfg.Dedup.Strategy != test.strategy {
+			t.Fatalf("%d: mismatch in strategy expected=%v actual=%v", i, test.strategy, cfg.Dedup.Strategy)
+		}
+	}
+}
@@ -37,3 +37,14 @@ type ApplyConfigFunc struct {
 func (a *ApplyConfigFunc) ApplyConfig(cfg *config.Config) error {
 	return a.F(cfg)
 }
+
+// PromxyApplyConfigFunc is a struct that wraps a single function that Applys config
+// into something that implements the `Reloadable` interface
+type PromxyApplyConfigFunc struct {
+	F func(*Config) error
+}
+
+// ApplyConfig applies new configuration
+func (a *PromxyApplyConfigFunc) ApplyConfig(cfg *Config) error {
+	return a.F(cfg)
+}
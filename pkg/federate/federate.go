@@ -0,0 +1,238 @@
+// Package federate implements prometheus' /federate endpoint on top of promxy's
+// storage, so that a prometheus can scrape the latest samples of all servergroups
+package federate
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	federationErrors = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "promxy_federation_errors_total",
+		Help: "Total number of errors that occurred while sending federation responses.",
+	})
+	federationWarnings = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "promxy_federation_warnings_total",
+		Help: "Total number of warnings that occurred while sending federation responses.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(federationErrors, federationWarnings)
+}
+
+// NewHandler returns a Handler which federates the latest sample (within lookback)
+// of each series in queryable
+func NewHandler(queryable storage.Queryable, lookback time.Duration) *Handler {
+	return &Handler{queryable: queryable, lookback: lookback, now: time.Now}
+}
+
+// Handler serves the /federate endpoint. Unlike prometheus' handler it doesn't
+// require the series sets to be sorted (promxy's aren't) and it can respond in the
+// OpenMetrics format. Until the config is applied it responds with a 503
+type Handler struct {
+	queryable storage.Queryable
+	lookback  time.Duration
+	now       func() time.Time
+
+	l              sync.RWMutex
+	externalLabels labels.Labels
+	ready          bool
+}
+
+// ApplyConfig sets the external labels that are added to all federated series
+func (h *Handler) ApplyConfig(c *config.Config) error {
+	h.l.Lock()
+	defer h.l.Unlock()
+	h.externalLabels = c.GlobalConfig.ExternalLabels
+	h.ready = true
+	return nil
+}
+
+// sample is the latest sample of a series
+type sample struct {
+	labels labels.Labels
+	t      int64
+	v      float64
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.l.RLock()
+	ready := h.ready
+	h.l.RUnlock()
+	if !ready {
+		// Without the config there are no servergroups, so we'd federate nothing
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "Service Unavailable")
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, fmt.Sprintf("error parsing form values: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	var matcherSets [][]*labels.Matcher
+	for _, s := range r.Form["match[]"] {
+		matchers, err := parser.ParseMetricSelector(s)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		matcherSets = append(matcherSets, matchers)
+	}
+
+	now := h.now()
+	mint := timestamp.FromTime(now.Add(-h.lookback))
+	maxt := timestamp.FromTime(now)
+
+	q, err := h.queryable.Querier(r.Context(), mint, maxt)
+	if err != nil {
+		federationErrors.Inc()
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer q.Close()
+
+	// The same series may match multiple selectors, so we dedupe them by their labels
+	latest := make(map[uint64]sample)
+	hints := &storage.SelectHints{Start: mint, End: maxt}
+	for _, matchers := range matcherSets {
+		set := q.Select(false, hints, matchers...)
+		for set.Next() {
+			s := set.At()
+			hash := s.Labels().Hash()
+			if _, ok := latest[hash]; ok {
+				continue
+			}
+			if item, ok := latestSample(s, maxt); ok {
+				latest[hash] = item
+			}
+		}
+		if ws := set.Warnings(); len(ws) > 0 {
+			logrus.Debugf("Federation select returned warnings: %v", ws)
+			federationWarnings.Add(float64(len(ws)))
+		}
+		if err := set.Err(); err != nil {
+			federationErrors.Inc()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	samples := make([]sample, 0, len(latest))
+	for _, item := range latest {
+		samples = append(samples, item)
+	}
+	// Labels are sorted by name, so this also groups the series by metric name
+	sort.Slice(samples, func(i, j int) bool {
+		return labels.Compare(samples[i].labels, samples[j].labels) < 0
+	})
+
+	h.l.RLock()
+	externalLabels := h.externalLabels
+	h.l.RUnlock()
+
+	format := expfmt.NegotiateIncludingOpenMetrics(r.Header)
+	w.Header().Set("Content-Type", string(format))
+	enc := expfmt.NewEncoder(w, format)
+	for _, family := range metricFamilies(samples, externalLabels) {
+		if err := enc.Encode(family); err != nil {
+			federationErrors.Inc()
+			logrus.Errorf("Federation failed: %v", err)
+			return
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		if err := closer.Close(); err != nil {
+			federationErrors.Inc()
+			logrus.Errorf("Federation failed: %v", err)
+		}
+	}
+}
+
+// latestSample returns the last sample of s at or before maxt. Stale markers
+// can't be exposed, so a series whose latest sample is one is dropped
+func latestSample(s storage.Series, maxt int64) (sample, bool) {
+	var t int64
+	var v float64
+	var found bool
+	it := s.Iterator()
+	for it.Next() {
+		st, sv := it.At()
+		if st > maxt {
+			break
+		}
+		t, v, found = st, sv, true
+	}
+	if !found || value.IsStaleNaN(v) {
+		return sample{}, false
+	}
+	return sample{labels: s.Labels(), t: t, v: v}, true
+}
+
+// metricFamilies converts the samples (sorted by their labels) into untyped metric
+// families, adding the external labels (and an empty instance label, as prometheus
+// does) to the series that don't have them
+func metricFamilies(samples []sample, externalLabels labels.Labels) []*dto.MetricFamily {
+	extra := externalLabels.Copy()
+	if !externalLabels.Has(model.InstanceLabel) {
+		extra = append(extra, labels.Label{Name: model.InstanceLabel})
+		sort.Sort(extra)
+	}
+
+	var families []*dto.MetricFamily
+	var family *dto.MetricFamily
+	for _, s := range samples {
+		name := s.labels.Get(labels.MetricName)
+		if name == "" {
+			logrus.Warnf("Ignoring nameless metric during federation: %v", s.labels)
+			continue
+		}
+		if family == nil || family.GetName() != name {
+			family = &dto.MetricFamily{
+				Name: proto.String(name),
+				Type: dto.MetricType_UNTYPED.Enum(),
+			}
+			families = append(families, family)
+		}
+
+		metric := &dto.Metric{
+			TimestampMs: proto.Int64(s.t),
+			Untyped:     &dto.Untyped{Value: proto.Float64(s.v)},
+		}
+		seen := make(map[string]struct{}, len(s.labels))
+		for _, l := range s.labels {
+			if l.Name == labels.MetricName || l.Value == "" {
+				continue
+			}
+			seen[l.Name] = struct{}{}
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+		}
+		for _, l := range extra {
+			if _, ok := seen[l.Name]; ok {
+				continue
+			}
+			metric.Label = append(metric.Label, &dto.LabelPair{Name: proto.String(l.Name), Value: proto.String(l.Value)})
+		}
+		family.Metric = append(family.Metric, metric)
+	}
+	return families
+}
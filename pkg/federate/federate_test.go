@@ -0,0 +1,128 @@
+package federate
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/config"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/value"
+	"github.com/prometheus/prometheus/util/teststorage"
+)
+
+func TestHandler(t *testing.T) {
+	store := teststorage.New(t)
+	defer store.Close()
+
+	app := store.Appender(context.Background())
+	for _, s := range []struct {
+		lset labels.Labels
+		t    int64
+		v    float64
+	}{
+		{labels.FromStrings("__name__", "up", "job", "a", "sg", "1"), 10000, 1},
+		{labels.FromStrings("__name__", "up", "job", "a", "sg", "1"), 20000, 2},
+		// After now, so it isn't the latest sample
+		{labels.FromStrings("__name__", "up", "job", "a", "sg", "1"), 40000, 3},
+		{labels.FromStrings("__name__", "up", "job", "b", "sg", "2"), 20000, 0},
+		// Stale series aren't federated
+		{labels.FromStrings("__name__", "up", "job", "c", "sg", "2"), 10000, 1},
+		{labels.FromStrings("__name__", "up", "job", "c", "sg", "2"), 20000, math.Float64frombits(value.StaleNaN)},
+		{labels.FromStrings("__name__", "build_info", "region", "eu"), 20000, 1},
+	} {
+		if _, err := app.Add(s.lset, s.t, s.v); err != nil {
+			t.Fatalf("Error adding sample: %v", err)
+		}
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("Error committing samples: %v", err)
+	}
+
+	h := NewHandler(store, 5*time.Minute)
+	h.now = func() time.Time { return time.Unix(30, 0) }
+
+	// Until the config is applied we aren't ready
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/federate?match[]=up", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected %d before the config is applied, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+
+	if err := h.ApplyConfig(&config.Config{GlobalConfig: config.GlobalConfig{
+		ExternalLabels: labels.FromStrings("region", "us"),
+	}}); err != nil {
+		t.Fatalf("Error applying config: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		query       string
+		accept      string
+		contentType expfmt.Format
+		body        string
+	}{
+		{
+			name:        "text",
+			query:       `match[]=up&match[]={job="a"}`,
+			contentType: expfmt.FmtText,
+			body: `# TYPE up untyped
+up{job="a",sg="1",instance="",region="us"} 2 20000
+up{job="b",sg="2",instance="",region="us"} 0 20000
+`,
+		},
+		{
+			// The series' own labels take precedence over the external labels
+			name:        "external labels",
+			query:       `match[]=build_info`,
+			contentType: expfmt.FmtText,
+			body: `# TYPE build_info untyped
+build_info{region="eu",instance=""} 1 20000
+`,
+		},
+		{
+			name:        "openmetrics",
+			query:       `match[]={job="b"}`,
+			accept:      "application/openmetrics-text; version=0.0.1",
+			contentType: expfmt.FmtOpenMetrics,
+			body: `# TYPE up unknown
+up{job="b",sg="2",instance="",region="us"} 0.0 20.0
+# EOF
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/federate?"+test.query, nil)
+			if test.accept != "" {
+				req.Header.Set("Accept", test.accept)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Unexpected status %d: %s", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != string(test.contentType) {
+				t.Fatalf("Unexpected content type %q", ct)
+			}
+			if body := w.Body.String(); body != test.body {
+				t.Fatalf("mismatch\nexpected=%s\nactual=%s", test.body, body)
+			}
+		})
+	}
+
+	t.Run("bad selector", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", "/federate?match[]="+strings.Repeat("{", 2), nil))
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected a bad request, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,247 @@
+package limits
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/jacksontj/promxy/pkg/promhttputil"
+)
+
+var (
+	concurrencyInFlight = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "promxy_query_concurrency_in_flight",
+		Help: "Number of queries currently holding a concurrency slot",
+	})
+	concurrencyQueueDepth = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "promxy_query_concurrency_queue_depth",
+		Help: "Number of queries waiting for a concurrency slot",
+	})
+	concurrencyWait = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "promxy_query_concurrency_wait_seconds",
+		Help:    "Time queries spent waiting for a concurrency slot",
+		Buckets: []float64{.001, .01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	})
+	concurrencyRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_query_concurrency_rejected_total",
+		Help: "Count of queries rejected by the concurrency limiter by reason",
+	}, []string{"reason"})
+)
+
+func init() {
+	prometheus.MustRegister(concurrencyInFlight, concurrencyQueueDepth, concurrencyWait, concurrencyRejected)
+}
+
+var (
+	// ErrQueueFull is returned when there is no room left in the wait queue
+	ErrQueueFull = errors.New("query queue is full")
+	// ErrQueueTimeout is returned when a query waited longer than the queue timeout
+	ErrQueueTimeout = errors.New("timed out waiting in the query queue")
+)
+
+// waiter is a single query waiting on a slot
+type waiter struct {
+	identity string
+	ready    chan struct{}
+	elem     *list.Element
+}
+
+// NewConcurrencyLimiter returns a ConcurrencyLimiter that allows maxConcurrent queries to run
+// at once with up to maxQueue waiting (for at most queueTimeout). If maxConcurrent is <= 0
+// the limiter allows everything through
+func NewConcurrencyLimiter(maxConcurrent, maxQueue int, queueTimeout time.Duration) *ConcurrencyLimiter {
+	return &ConcurrencyLimiter{
+		maxConcurrent: maxConcurrent,
+		maxQueue:      maxQueue,
+		queueTimeout:  queueTimeout,
+		queues:        make(map[string]*list.List),
+	}
+}
+
+// ConcurrencyLimiter is an in-memory limit on the number of concurrently executing queries.
+// Queries over the limit wait in a queue; slots are handed out round-robin across
+// client identities so a single client with many queries can't starve everyone else.
+type ConcurrencyLimiter struct {
+	maxConcurrent int
+	maxQueue      int
+	queueTimeout  time.Duration
+
+	l       sync.Mutex
+	running int
+	queued  int
+	// queues holds the waiters (in order) for each identity
+	queues map[string]*list.List
+	// order is the round-robin ring of identities that have waiters
+	order []string
+	next  int
+}
+
+// Enabled returns whether this limiter is actually limiting anything
+func (c *ConcurrencyLimiter) Enabled() bool {
+	return c != nil && c.maxConcurrent > 0
+}
+
+// Acquire waits for a slot for the given identity. If successful the returned func
+// must be called to release the slot
+func (c *ConcurrencyLimiter) Acquire(ctx context.Context, identity string) (func(), error) {
+	if !c.Enabled() {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	c.l.Lock()
+	if c.running < c.maxConcurrent && c.queued == 0 {
+		c.running++
+		concurrencyInFlight.Set(float64(c.running))
+		c.l.Unlock()
+		concurrencyWait.Observe(0)
+		return c.release, nil
+	}
+
+	if c.queued >= c.maxQueue {
+		c.l.Unlock()
+		concurrencyRejected.WithLabelValues("queue_full").Inc()
+		return nil, ErrQueueFull
+	}
+
+	w := c.enqueue(identity)
+	c.l.Unlock()
+
+	var timeout <-chan time.Time
+	if c.queueTimeout > 0 {
+		t := time.NewTimer(c.queueTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+
+	var err error
+	select {
+	case <-w.ready:
+		concurrencyWait.Observe(time.Since(start).Seconds())
+		return c.release, nil
+	case <-ctx.Done():
+		err = ctx.Err()
+		concurrencyRejected.WithLabelValues("canceled").Inc()
+	case <-timeout:
+		err = ErrQueueTimeout
+		concurrencyRejected.WithLabelValues("timeout").Inc()
+	}
+
+	c.l.Lock()
+	defer c.l.Unlock()
+	select {
+	// If we were handed a slot while giving up, we need to pass it along
+	case <-w.ready:
+		c.running--
+		c.dispatch()
+	default:
+		c.dequeue(w)
+	}
+	return nil, err
+}
+
+// release gives back a slot and hands it to the next waiter (if any)
+func (c *ConcurrencyLimiter) release() {
+	c.l.Lock()
+	defer c.l.Unlock()
+	c.running--
+	c.dispatch()
+}
+
+// enqueue adds a waiter for identity, must be called with the lock held
+func (c *ConcurrencyLimiter) enqueue(identity string) *waiter {
+	q, ok := c.queues[identity]
+	if !ok {
+		q = list.New()
+		c.queues[identity] = q
+		c.order = append(c.order, identity)
+	}
+	w := &waiter{identity: identity, ready: make(chan struct{})}
+	w.elem = q.PushBack(w)
+	c.queued++
+	concurrencyQueueDepth.Set(float64(c.queued))
+	return w
+}
+
+// dequeue removes the waiter from its queue, must be called with the lock held
+func (c *ConcurrencyLimiter) dequeue(w *waiter) {
+	q := c.queues[w.identity]
+	q.Remove(w.elem)
+	c.queued--
+	concurrencyQueueDepth.Set(float64(c.queued))
+	if q.Len() == 0 {
+		c.removeIdentity(w.identity)
+	}
+}
+
+func (c *ConcurrencyLimiter) removeIdentity(identity string) {
+	delete(c.queues, identity)
+	for i, id := range c.order {
+		if id == identity {
+			c.order = append(c.order[:i], c.order[i+1:]...)
+			if c.next > i {
+				c.next--
+			}
+			break
+		}
+	}
+	if c.next >= len(c.order) {
+		c.next = 0
+	}
+}
+
+// dispatch hands out free slots round-robin across identities, must be called with the lock held
+func (c *ConcurrencyLimiter) dispatch() {
+	for c.running < c.maxConcurrent && len(c.order) > 0 {
+		identity := c.order[c.next]
+		w := c.queues[identity].Front().Value.(*waiter)
+		// Move on to the next identity before we (potentially) remove this one
+		c.next++
+		c.dequeue(w)
+		if c.next >= len(c.order) {
+			c.next = 0
+		}
+		c.running++
+		close(w.ready)
+	}
+	concurrencyInFlight.Set(float64(c.running))
+}
+
+// Handler wraps next with the concurrency limit. Only the query and query_range
+// endpoints (of the API served under apiPrefix) are limited; the client identity
+// is taken from the request context
+func (c *ConcurrencyLimiter) Handler(apiPrefix string, next http.Handler) http.Handler {
+	if !c.Enabled() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch EndpointForPath(apiPrefix, r.URL.Path) {
+		case EndpointQuery, EndpointQueryRange:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		release, err := c.Acquire(r.Context(), IdentityFromContext(r.Context()))
+		switch err {
+		case nil:
+		case ErrQueueFull:
+			promhttputil.WriteError(w, http.StatusTooManyRequests, promhttputil.ErrorTooManyRequests, err)
+			return
+		case ErrQueueTimeout:
+			promhttputil.WriteError(w, http.StatusServiceUnavailable, promhttputil.ErrorUnavailable, fmt.Errorf("%v after %v", err, c.queueTimeout))
+			return
+		default:
+			promhttputil.WriteError(w, http.StatusServiceUnavailable, promhttputil.ErrorCanceled, err)
+			return
+		}
+		defer release()
+		next.ServeHTTP(w, r)
+	})
+}
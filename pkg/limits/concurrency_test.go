@@ -0,0 +1,107 @@
+package limits
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiterFairness(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 100, time.Minute)
+
+	release, err := c.Acquire(context.TODO(), "holder")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var l sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	enqueue := func(identity string) {
+		c.l.Lock()
+		expectedQueued := c.queued + 1
+		c.l.Unlock()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release, err := c.Acquire(context.TODO(), identity)
+			if err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			l.Lock()
+			order = append(order, identity)
+			l.Unlock()
+			release()
+		}()
+		// Wait for the waiter to be queued so the order is deterministic
+		for {
+			c.l.Lock()
+			queued := c.queued
+			c.l.Unlock()
+			if queued == expectedQueued {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	// "a" queues up a lot of queries before "b" shows up
+	for i := 0; i < 4; i++ {
+		enqueue("a")
+	}
+	enqueue("b")
+
+	release()
+	wg.Wait()
+
+	expected := []string{"a", "b", "a", "a", "a"}
+	if len(order) != len(expected) {
+		t.Fatalf("mismatch in order expected=%v actual=%v", expected, order)
+	}
+	for i := range expected {
+		if order[i] != expected[i] {
+			t.Fatalf("mismatch in order expected=%v actual=%v", expected, order)
+		}
+	}
+}
+
+func TestConcurrencyLimiterQueue(t *testing.T) {
+	c := NewConcurrencyLimiter(1, 1, 10*time.Millisecond)
+
+	release, err := c.Acquire(context.TODO(), "a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := c.Acquire(context.TODO(), "b")
+		done <- err
+	}()
+
+	// Wait for "b" to be queued, after which the queue is full
+	for {
+		c.l.Lock()
+		queued := c.queued
+		c.l.Unlock()
+		if queued == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if _, err := c.Acquire(context.TODO(), "c"); err != ErrQueueFull {
+		t.Fatalf("expected ErrQueueFull, got %v", err)
+	}
+
+	if err := <-done; err != ErrQueueTimeout {
+		t.Fatalf("expected ErrQueueTimeout, got %v", err)
+	}
+
+	release()
+	if c.running != 0 || c.queued != 0 || len(c.order) != 0 {
+		t.Fatalf("limiter not empty after all queries finished: running=%d queued=%d", c.running, c.queued)
+	}
+}
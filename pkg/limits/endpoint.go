@@ -0,0 +1,64 @@
+package limits
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Endpoint is a class of API endpoint that limits can be applied to
+type Endpoint string
+
+const (
+	// EndpointNone is any path that isn't a limitable API endpoint
+	EndpointNone Endpoint = ""
+	// EndpointQuery is /api/v1/query
+	EndpointQuery Endpoint = "query"
+	// EndpointQueryRange is /api/v1/query_range
+	EndpointQueryRange Endpoint = "query_range"
+	// EndpointSeries is /api/v1/series
+	EndpointSeries Endpoint = "series"
+	// EndpointLabels covers both /api/v1/labels and /api/v1/label/<name>/values
+	EndpointLabels Endpoint = "labels"
+)
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (e *Endpoint) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	switch Endpoint(s) {
+	case EndpointQuery, EndpointQueryRange, EndpointSeries, EndpointLabels:
+		*e = Endpoint(s)
+		return nil
+	default:
+		return fmt.Errorf("unknown endpoint %q", s)
+	}
+}
+
+// EndpointForPath returns the Endpoint that the given request path is for. apiPrefix
+// is the path under which the v1 API is served (e.g. /api/v1)
+func EndpointForPath(apiPrefix, path string) Endpoint {
+	if !strings.HasPrefix(path, apiPrefix) {
+		return EndpointNone
+	}
+	p := strings.Trim(strings.TrimPrefix(path, apiPrefix), "/")
+
+	switch p {
+	case "query":
+		return EndpointQuery
+	case "query_range":
+		return EndpointQueryRange
+	case "series":
+		return EndpointSeries
+	case "labels":
+		return EndpointLabels
+	}
+
+	if strings.HasPrefix(p, "label/") && strings.HasSuffix(p, "/values") {
+		return EndpointLabels
+	}
+
+	return EndpointNone
+}
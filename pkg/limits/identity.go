@@ -0,0 +1,95 @@
+package limits
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// IdentitySourceIP identifies clients by the remote address of the connection
+	IdentitySourceIP = "ip"
+	// IdentitySourceHeader identifies clients by the value of a request header
+	IdentitySourceHeader = "header"
+	// IdentitySourceUser identifies clients by their basic-auth username
+	IdentitySourceUser = "user"
+
+	// UnknownIdentity is the identity used when the configured source has no value
+	UnknownIdentity = "unknown"
+)
+
+// IdentityConfig defines how promxy determines "who" a client is. This identity
+// is used to key rate limits and for fair scheduling of queries.
+type IdentityConfig struct {
+	// Source is where the identity is pulled from (ip, header, user). The default is ip
+	Source string `yaml:"source"`
+	// Header is the name of the header to use when Source is "header"
+	Header string `yaml:"header"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *IdentityConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain IdentityConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	return c.validate()
+}
+
+func (c *IdentityConfig) validate() error {
+	switch c.Source {
+	case "":
+		c.Source = IdentitySourceIP
+	case IdentitySourceIP, IdentitySourceUser:
+	case IdentitySourceHeader:
+		if c.Header == "" {
+			return fmt.Errorf("IdentityConfig: header must be set when source is %q", IdentitySourceHeader)
+		}
+	default:
+		return fmt.Errorf("IdentityConfig: unknown source %q", c.Source)
+	}
+	return nil
+}
+
+// Identify returns the identity of the client making the request
+func (c *IdentityConfig) Identify(r *http.Request) string {
+	var id string
+	switch c.Source {
+	case IdentitySourceHeader:
+		id = r.Header.Get(c.Header)
+	case IdentitySourceUser:
+		id, _, _ = r.BasicAuth()
+	default:
+		id = remoteIP(r.RemoteAddr)
+	}
+
+	if id == "" {
+		return UnknownIdentity
+	}
+	return id
+}
+
+func remoteIP(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return strings.TrimSpace(addr)
+}
+
+type identityKey struct{}
+
+// WithIdentity returns a copy of ctx carrying the given client identity
+func WithIdentity(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, identityKey{}, id)
+}
+
+// IdentityFromContext returns the client identity stored in ctx (if any)
+func IdentityFromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(identityKey{}).(string); ok {
+		return id
+	}
+	return UnknownIdentity
+}
@@ -0,0 +1,270 @@
+package limits
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+
+	"github.com/jacksontj/promxy/pkg/promhttputil"
+)
+
+var (
+	// Client identities are unbounded (and may come from a client-controlled header), so only
+	// a capped number of them get their own identity label (see RateLimitConfig.MetricsIdentities)
+	rateLimitRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_rate_limit_requests_total",
+		Help: "Count of requests checked against rate limits by client identity, endpoint and result",
+	}, []string{"identity", "endpoint", "result"})
+)
+
+// otherIdentity is the identity label of the clients beyond RateLimitConfig.MetricsIdentities
+const otherIdentity = "other"
+
+// DefaultMetricsIdentities is the default RateLimitConfig.MetricsIdentities
+const DefaultMetricsIdentities = 20
+
+func init() {
+	prometheus.MustRegister(rateLimitRequests)
+}
+
+// bucketIdleTimeout is how long a bucket has to go unused before we drop it
+const bucketIdleTimeout = 10 * time.Minute
+
+// RateLimitConfig configures per-client rate limits for the query APIs
+type RateLimitConfig struct {
+	// Identity defines how clients are identified
+	Identity IdentityConfig `yaml:"identity"`
+	// Rules are the limits to apply, every client identity gets its own
+	// token bucket for each rule that matches the request
+	Rules []*RateLimitRule `yaml:"rules"`
+	// MetricsIdentities is the max number of client identities that get their own label in the
+	// rate limit metrics, the requests of any others are counted as `other`. Identities free up
+	// their label (and their series are deleted) once they haven't been seen in a while
+	MetricsIdentities int `yaml:"metrics_identities"`
+}
+
+// GetMetricsIdentities returns MetricsIdentities (or its default if unset)
+func (c *RateLimitConfig) GetMetricsIdentities() int {
+	if c.MetricsIdentities <= 0 {
+		return DefaultMetricsIdentities
+	}
+	return c.MetricsIdentities
+}
+
+// RateLimitRule is a single token-bucket limit
+type RateLimitRule struct {
+	// Endpoints this rule applies to, if empty it applies to all of them
+	Endpoints []Endpoint `yaml:"endpoints"`
+	// Rate is the number of requests per second each client is allowed
+	Rate float64 `yaml:"rate"`
+	// Burst is the maximum number of requests a client can make at once.
+	// The default is the rate (rounded up)
+	Burst int `yaml:"burst"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (r *RateLimitRule) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain RateLimitRule
+	if err := unmarshal((*plain)(r)); err != nil {
+		return err
+	}
+
+	return r.validate()
+}
+
+func (r *RateLimitRule) validate() error {
+	if r.Rate <= 0 {
+		return fmt.Errorf("RateLimitRule: rate must be > 0")
+	}
+	if r.Burst < 0 {
+		return fmt.Errorf("RateLimitRule: burst must be >= 0")
+	}
+	if r.Burst == 0 {
+		r.Burst = int(math.Ceil(r.Rate))
+	}
+	return nil
+}
+
+// Matches returns whether this rule applies to the given endpoint
+func (r *RateLimitRule) Matches(e Endpoint) bool {
+	if len(r.Endpoints) == 0 {
+		return true
+	}
+	for _, endpoint := range r.Endpoints {
+		if endpoint == e {
+			return true
+		}
+	}
+	return false
+}
+
+type bucketKey struct {
+	rule     int
+	identity string
+}
+
+type bucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// NewRateLimiter returns a RateLimiter for the API served under apiPrefix. Until
+// a config with rules is applied the RateLimiter allows all requests
+func NewRateLimiter(apiPrefix string) *RateLimiter {
+	return &RateLimiter{
+		apiPrefix:        apiPrefix,
+		cfg:              &RateLimitConfig{Identity: IdentityConfig{Source: IdentitySourceIP}},
+		buckets:          make(map[bucketKey]*bucket),
+		metricIdentities: make(map[string]time.Time),
+	}
+}
+
+// RateLimiter enforces a RateLimitConfig on incoming HTTP requests
+type RateLimiter struct {
+	apiPrefix string
+
+	l         sync.Mutex
+	cfg       *RateLimitConfig
+	buckets   map[bucketKey]*bucket
+	lastPrune time.Time
+	// metricIdentities are the identities with their own label in the metrics (and when they
+	// were last seen)
+	metricIdentities map[string]time.Time
+}
+
+// ApplyConfig applies new configuration. All existing buckets are dropped as
+// the rules they belong to may have changed
+func (l *RateLimiter) ApplyConfig(cfg *RateLimitConfig) error {
+	if cfg == nil {
+		cfg = &RateLimitConfig{Identity: IdentityConfig{Source: IdentitySourceIP}}
+	}
+
+	l.l.Lock()
+	defer l.l.Unlock()
+	l.cfg = cfg
+	l.buckets = make(map[bucketKey]*bucket)
+	return nil
+}
+
+// Identify returns the identity of the client making the request
+func (l *RateLimiter) Identify(r *http.Request) string {
+	l.l.Lock()
+	defer l.l.Unlock()
+	return l.cfg.Identity.Identify(r)
+}
+
+// Allow checks (and consumes) the tokens for the identity on the given endpoint. If the
+// request isn't allowed the duration until the client may retry is returned
+func (l *RateLimiter) Allow(identity string, e Endpoint) (bool, time.Duration) {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	reservations := make([]*rate.Reservation, 0, len(l.cfg.Rules))
+	var wait time.Duration
+	for i, rule := range l.cfg.Rules {
+		if !rule.Matches(e) {
+			continue
+		}
+		k := bucketKey{rule: i, identity: identity}
+		b, ok := l.buckets[k]
+		if !ok {
+			b = &bucket{limiter: rate.NewLimiter(rate.Limit(rule.Rate), rule.Burst)}
+			l.buckets[k] = b
+		}
+		b.lastSeen = now
+
+		r := b.limiter.ReserveN(now, 1)
+		reservations = append(reservations, r)
+		if d := r.DelayFrom(now); d > wait {
+			wait = d
+		}
+	}
+
+	// If any bucket is empty the request is denied, so we give back the tokens
+	// we took from the others
+	if wait > 0 {
+		for _, r := range reservations {
+			r.CancelAt(now)
+		}
+		return false, wait
+	}
+	return true, 0
+}
+
+// prune removes buckets that haven't been used in a while, this keeps the map
+// from growing forever as clients come and go
+func (l *RateLimiter) prune(now time.Time) {
+	if now.Sub(l.lastPrune) < time.Minute {
+		return
+	}
+	l.lastPrune = now
+	for k, b := range l.buckets {
+		if now.Sub(b.lastSeen) > bucketIdleTimeout {
+			delete(l.buckets, k)
+		}
+	}
+	for identity, lastSeen := range l.metricIdentities {
+		if now.Sub(lastSeen) > bucketIdleTimeout {
+			delete(l.metricIdentities, identity)
+			deleteIdentityMetrics(identity)
+		}
+	}
+}
+
+// metricIdentity returns the identity label for the requests of identity: the identity itself if
+// it has (or there is room for it to get) its own label, otherwise otherIdentity
+func (l *RateLimiter) metricIdentity(identity string) string {
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	now := time.Now()
+	if _, ok := l.metricIdentities[identity]; !ok && len(l.metricIdentities) >= l.cfg.GetMetricsIdentities() {
+		return otherIdentity
+	}
+	l.metricIdentities[identity] = now
+	return identity
+}
+
+// deleteIdentityMetrics deletes the series of identity from the rate limit metrics
+func deleteIdentityMetrics(identity string) {
+	for _, e := range []Endpoint{EndpointQuery, EndpointQueryRange, EndpointSeries, EndpointLabels} {
+		for _, result := range []string{"allowed", "limited"} {
+			rateLimitRequests.DeleteLabelValues(identity, string(e), result)
+		}
+	}
+}
+
+// Handler wraps next with the rate limits. All requests (limited or not) have the client's
+// identity added to their context (see IdentityFromContext)
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		identity := l.Identify(r)
+		r = r.WithContext(WithIdentity(r.Context(), identity))
+
+		endpoint := EndpointForPath(l.apiPrefix, r.URL.Path)
+		if endpoint == EndpointNone {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ok, wait := l.Allow(identity, endpoint)
+		metricIdentity := l.metricIdentity(identity)
+		if !ok {
+			rateLimitRequests.WithLabelValues(metricIdentity, string(endpoint), "limited").Inc()
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			promhttputil.WriteError(w, http.StatusTooManyRequests, promhttputil.ErrorTooManyRequests, fmt.Errorf("rate limit exceeded for %q on %s, retry in %v", identity, endpoint, wait))
+			return
+		}
+		rateLimitRequests.WithLabelValues(metricIdentity, string(endpoint), "allowed").Inc()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,176 @@
+package limits
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	yaml "gopkg.in/yaml.v2"
+)
+
+func TestEndpointForPath(t *testing.T) {
+	tests := []struct {
+		path string
+		e    Endpoint
+	}{
+		{"/api/v1/query", EndpointQuery},
+		{"/api/v1/query_range", EndpointQueryRange},
+		{"/api/v1/series", EndpointSeries},
+		{"/api/v1/labels", EndpointLabels},
+		{"/api/v1/label/job/values", EndpointLabels},
+		{"/api/v1/targets", EndpointNone},
+		{"/graph", EndpointNone},
+		{"/api/v1/label/job", EndpointNone},
+	}
+
+	for i, test := range tests {
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			if e := EndpointForPath("/api/v1", test.path); e != test.e {
+				t.Fatalf("mismatch in endpoint for %s expected=%q actual=%q", test.path, test.e, e)
+			}
+		})
+	}
+}
+
+func TestRateLimitConfig(t *testing.T) {
+	cfg := &RateLimitConfig{}
+	err := yaml.Unmarshal([]byte(`
+identity:
+  source: header
+  header: X-Dashboard-User
+rules:
+  - endpoints: [query, query_range]
+    rate: 2.5
+`), cfg)
+	if err != nil {
+		t.Fatalf("Error unmarshaling config: %v", err)
+	}
+	if cfg.Rules[0].Burst != 3 {
+		t.Fatalf("burst should default to the rate rounded up, got %d", cfg.Rules[0].Burst)
+	}
+
+	for _, bad := range []string{
+		"identity: {source: header}",
+		"identity: {source: cookie}",
+		"rules: [{rate: 0}]",
+		"rules: [{rate: 1, endpoints: [targets]}]",
+	} {
+		if err := yaml.Unmarshal([]byte(bad), &RateLimitConfig{}); err == nil {
+			t.Fatalf("expected error for config: %s", bad)
+		}
+	}
+}
+
+func TestRateLimiter(t *testing.T) {
+	l := NewRateLimiter("/api/v1")
+	l.ApplyConfig(&RateLimitConfig{
+		Identity: IdentityConfig{Source: IdentitySourceHeader, Header: "X-User"},
+		Rules: []*RateLimitRule{
+			{Endpoints: []Endpoint{EndpointQueryRange}, Rate: 0.001, Burst: 2},
+		},
+	})
+
+	var seenIdentity string
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenIdentity = IdentityFromContext(r.Context())
+	}))
+
+	do := func(path, user string) int {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("X-User", user)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	for i := 0; i < 2; i++ {
+		if code := do("/api/v1/query_range", "a"); code != http.StatusOK {
+			t.Fatalf("request %d within burst was limited: %d", i, code)
+		}
+	}
+	if seenIdentity != "a" {
+		t.Fatalf("identity not set in context, got %q", seenIdentity)
+	}
+	if code := do("/api/v1/query_range", "a"); code != http.StatusTooManyRequests {
+		t.Fatalf("expected request over burst to be limited, got %d", code)
+	}
+	// Other identities have their own bucket
+	if code := do("/api/v1/query_range", "b"); code != http.StatusOK {
+		t.Fatalf("other identity was limited: %d", code)
+	}
+	// Other endpoints aren't covered by the rule
+	if code := do("/api/v1/query", "a"); code != http.StatusOK {
+		t.Fatalf("endpoint without a rule was limited: %d", code)
+	}
+
+	// Reloading resets the buckets
+	l.ApplyConfig(nil)
+	if code := do("/api/v1/query_range", "a"); code != http.StatusOK {
+		t.Fatalf("request limited after rules were removed: %d", code)
+	}
+}
+
+func TestRateLimiterMetricIdentities(t *testing.T) {
+	l := NewRateLimiter("/api/v1")
+	l.ApplyConfig(&RateLimitConfig{
+		Identity:          IdentityConfig{Source: IdentitySourceHeader, Header: "X-User"},
+		MetricsIdentities: 1,
+	})
+
+	h := l.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, user := range []string{"metrics-a", "metrics-b", "metrics-a"} {
+		r := httptest.NewRequest("GET", "/api/v1/query", nil)
+		r.Header.Set("X-User", user)
+		h.ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	// Only the first identity gets its own label, the rest are counted as other
+	for identity, expected := range map[string]float64{"metrics-a": 2, "metrics-b": 0, otherIdentity: 1} {
+		m := &dto.Metric{}
+		if err := rateLimitRequests.WithLabelValues(identity, string(EndpointQuery), "allowed").Write(m); err != nil {
+			t.Fatal(err)
+		}
+		if v := m.GetCounter().GetValue(); v != expected {
+			t.Fatalf("expected %v requests for identity %q, got %v", expected, identity, v)
+		}
+	}
+
+	// Idle identities are pruned (and their series deleted), freeing up their label
+	l.l.Lock()
+	l.metricIdentities["metrics-a"] = time.Now().Add(-2 * bucketIdleTimeout)
+	l.lastPrune = time.Time{}
+	l.prune(time.Now())
+	l.l.Unlock()
+	if n := countSeries(rateLimitRequests, "metrics-a"); n != 0 {
+		t.Fatalf("expected the series of the idle identity to be deleted, got %d", n)
+	}
+	if identity := l.metricIdentity("metrics-b"); identity != "metrics-b" {
+		t.Fatalf("expected a new identity to get its own label after pruning, got %q", identity)
+	}
+}
+
+// countSeries returns the number of series of c with the given identity
+func countSeries(c prometheus.Collector, identity string) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var n int
+	for metric := range ch {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			continue
+		}
+		for _, lp := range m.GetLabel() {
+			if lp.GetName() == "identity" && lp.GetValue() == identity {
+				n++
+			}
+		}
+	}
+	return n
+}
@@ -0,0 +1,288 @@
+// Package localeval evaluates PromQL in promxy over the raw data of an API, for
+// downstreams that can return raw data but can't evaluate PromQL themselves
+package localeval
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/jacksontj/promxy/pkg/promhttputil"
+)
+
+// defaultLookbackDelta is the default of promxy's --query.lookback-delta (and prometheus')
+const defaultLookbackDelta = 5 * time.Minute
+
+var (
+	engineMtx sync.RWMutex
+	// engine is shared by all APIs. It defaults to the defaults of promxy's flags until
+	// SetEngineOpts is called
+	engine = promql.NewEngine(promql.EngineOpts{
+		MaxSamples: 50000000,
+		Timeout:    2 * time.Minute,
+		NoStepSubqueryIntervalFn: func(int64) int64 {
+			return int64(time.Minute / time.Millisecond)
+		},
+		LookbackDelta: defaultLookbackDelta,
+	})
+	lookbackDelta = defaultLookbackDelta
+)
+
+// SetEngineOpts sets the options of the engine evaluating queries in promxy, which should be
+// those of the engine serving promxy's own API (so that the limits and lookback are the same).
+// The engine isn't registered and doesn't track active queries, as that engine already does
+func SetEngineOpts(opts promql.EngineOpts) {
+	opts.Reg = nil
+	opts.ActiveQueryTracker = nil
+	e := promql.NewEngine(opts)
+
+	engineMtx.Lock()
+	defer engineMtx.Unlock()
+	engine = e
+	lookbackDelta = opts.LookbackDelta
+	if lookbackDelta <= 0 {
+		lookbackDelta = defaultLookbackDelta
+	}
+}
+
+func getEngine() *promql.Engine {
+	engineMtx.RLock()
+	defer engineMtx.RUnlock()
+	return engine
+}
+
+// LookbackDelta returns the lookback delta of the engine (set by SetEngineOpts), which is the
+// most that the samples of a series can be apart without it having gaps
+func LookbackDelta() time.Duration {
+	engineMtx.RLock()
+	defer engineMtx.RUnlock()
+	return lookbackDelta
+}
+
+// API proxies a client and evaluates Query and QueryRange in promxy with the raw
+// data from the client's GetValue and Series
+type API struct {
+	promclient.API
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (a *API) Key() model.LabelSet {
+	if apiLabels, ok := a.API.(promclient.APILabels); ok {
+		return apiLabels.Key()
+	}
+	return nil
+}
+
+// Query performs a query for the given time.
+func (a *API) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	q, err := getEngine().NewInstantQuery(a.queryable(), query, ts)
+	if err != nil {
+		return nil, nil, err
+	}
+	return execute(ctx, q)
+}
+
+// QueryRange performs a query for the given range.
+func (a *API) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	q, err := getEngine().NewRangeQuery(a.queryable(), query, r.Start, r.End, r.Step)
+	if err != nil {
+		return nil, nil, err
+	}
+	return execute(ctx, q)
+}
+
+func (a *API) queryable() storage.Queryable {
+	return storage.QueryableFunc(func(ctx context.Context, mint, maxt int64) (storage.Querier, error) {
+		return &querier{ctx: ctx, api: a.API, start: timestamp.Time(mint), end: timestamp.Time(maxt)}, nil
+	})
+}
+
+// FallbackAPI proxies a client and evaluates the queries that the client doesn't support
+// (according to Supported) in promxy instead of sending them to the client
+type FallbackAPI struct {
+	promclient.API
+	Supported func(parser.Expr) bool
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (a *FallbackAPI) Key() model.LabelSet {
+	if apiLabels, ok := a.API.(promclient.APILabels); ok {
+		return apiLabels.Key()
+	}
+	return nil
+}
+
+// api returns the API to send query to
+func (a *FallbackAPI) api(query string) promclient.API {
+	// If we can't parse the query we leave it to the client (which returns a better error)
+	if expr, err := parser.ParseExpr(query); err == nil && !a.Supported(expr) {
+		return &API{API: a.API}
+	}
+	return a.API
+}
+
+// Query performs a query for the given time.
+func (a *FallbackAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	return a.api(query).Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (a *FallbackAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	return a.api(query).QueryRange(ctx, query, r)
+}
+
+// execute runs q and converts the result for the API
+func execute(ctx context.Context, q promql.Query) (model.Value, v1.Warnings, error) {
+	defer q.Close()
+	res := q.Exec(ctx)
+
+	var warnings v1.Warnings
+	for _, w := range res.Warnings {
+		warnings = append(warnings, w.Error())
+	}
+	if res.Err != nil {
+		return nil, warnings, res.Err
+	}
+	return ValueFromPromQL(res.Value), warnings, nil
+}
+
+// ValueFromPromQL converts the value of a promql.Result to a model.Value
+func ValueFromPromQL(v parser.Value) model.Value {
+	switch vTyped := v.(type) {
+	case promql.Vector:
+		ret := make(model.Vector, len(vTyped))
+		for i, s := range vTyped {
+			ret[i] = &model.Sample{
+				Metric:    metricFromLabels(s.Metric),
+				Value:     model.SampleValue(s.V),
+				Timestamp: model.Time(s.T),
+			}
+		}
+		return ret
+	case promql.Matrix:
+		ret := make(model.Matrix, len(vTyped))
+		for i, s := range vTyped {
+			values := make([]model.SamplePair, len(s.Points))
+			for j, p := range s.Points {
+				values[j] = model.SamplePair{Timestamp: model.Time(p.T), Value: model.SampleValue(p.V)}
+			}
+			ret[i] = &model.SampleStream{Metric: metricFromLabels(s.Metric), Values: values}
+		}
+		return ret
+	case promql.Scalar:
+		return &model.Scalar{Value: model.SampleValue(vTyped.V), Timestamp: model.Time(vTyped.T)}
+	case promql.String:
+		return &model.String{Value: vTyped.V, Timestamp: model.Time(vTyped.T)}
+	}
+	return nil
+}
+
+func metricFromLabels(lbls labels.Labels) model.Metric {
+	m := make(model.Metric, len(lbls))
+	for _, l := range lbls {
+		m[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return m
+}
+
+// querier implements prometheus' Querier interface on top of an API
+type querier struct {
+	ctx        context.Context
+	api        promclient.API
+	start, end time.Time
+}
+
+// Select returns a set of series that matches the given label matchers.
+func (q *querier) Select(_ bool, hints *storage.SelectHints, matchers ...*labels.Matcher) storage.SeriesSet {
+	var result model.Value
+	var w v1.Warnings
+	var err error
+	// As in the proxyquerier, a nil hint (or the series func) is a metadata call
+	if hints == nil || hints.Func == "series" {
+		var matcherString string
+		if matcherString, err = promhttputil.MatcherToString(matchers); err != nil {
+			return &seriesSet{err: err}
+		}
+		var labelsets []model.LabelSet
+		labelsets, w, err = q.api.Series(q.ctx, []string{matcherString}, q.start, q.end)
+		vector := make(model.Vector, len(labelsets))
+		for i, labelset := range labelsets {
+			vector[i] = &model.Sample{Metric: model.Metric(labelset)}
+		}
+		result = vector
+	} else {
+		result, w, err = q.api.GetValue(q.ctx, timestamp.Time(hints.Start), timestamp.Time(hints.End), matchers)
+	}
+	warnings := promhttputil.WarningsConvert(w)
+	if err != nil {
+		return &seriesSet{warnings: warnings, err: errors.Cause(err)}
+	}
+
+	iterators := promclient.IteratorsForValue(result)
+	series := make([]storage.Series, len(iterators))
+	for i, iterator := range iterators {
+		series[i] = &seriesIterator{iterator}
+	}
+	return &seriesSet{series: series, warnings: warnings}
+}
+
+// LabelValues returns all potential values for a label name.
+func (q *querier) LabelValues(name string) ([]string, storage.Warnings, error) {
+	result, w, err := q.api.LabelValues(q.ctx, name)
+	if err != nil {
+		return nil, promhttputil.WarningsConvert(w), errors.Cause(err)
+	}
+	ret := make([]string, len(result))
+	for i, r := range result {
+		ret[i] = string(r)
+	}
+	return ret, promhttputil.WarningsConvert(w), nil
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (q *querier) LabelNames() ([]string, storage.Warnings, error) {
+	v, w, err := q.api.LabelNames(q.ctx)
+	return v, promhttputil.WarningsConvert(w), err
+}
+
+// Close closes the querier.
+func (q *querier) Close() error { return nil }
+
+// seriesIterator implements prometheus' Series interface
+type seriesIterator struct {
+	it *promclient.SeriesIterator
+}
+
+func (s *seriesIterator) Labels() labels.Labels       { return s.it.Labels() }
+func (s *seriesIterator) Iterator() chunkenc.Iterator { return s.it }
+
+// seriesSet implements prometheus' SeriesSet interface
+type seriesSet struct {
+	offset   int // 0 means we haven't seen anything
+	series   []storage.Series
+	err      error
+	warnings storage.Warnings
+}
+
+func (s *seriesSet) Next() bool {
+	if s.offset < len(s.series) {
+		s.offset++
+		return true
+	}
+	return false
+}
+
+func (s *seriesSet) At() storage.Series         { return s.series[s.offset-1] }
+func (s *seriesSet) Err() error                 { return s.err }
+func (s *seriesSet) Warnings() storage.Warnings { return s.warnings }
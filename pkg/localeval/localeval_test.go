@@ -0,0 +1,153 @@
+package localeval
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/jacksontj/promxy/pkg/promclient"
+)
+
+// rawAPI is a promclient.API that only returns raw data
+type rawAPI struct {
+	promclient.API
+	matrix model.Matrix
+}
+
+func (r *rawAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	ret := make(model.Matrix, 0, len(r.matrix))
+SERIES_LOOP:
+	for _, stream := range r.matrix {
+		for _, m := range matchers {
+			if !m.Matches(string(stream.Metric[model.LabelName(m.Name)])) {
+				continue SERIES_LOOP
+			}
+		}
+		ret = append(ret, stream)
+	}
+	return ret, v1.Warnings{"raw warning"}, nil
+}
+
+func TestAPI(t *testing.T) {
+	api := &API{API: &rawAPI{matrix: model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "requests_total", "job": "a"},
+			Values: []model.SamplePair{{Timestamp: 0, Value: 0}, {Timestamp: 60000, Value: 60}, {Timestamp: 120000, Value: 180}},
+		},
+		{
+			Metric: model.Metric{"__name__": "requests_total", "job": "b"},
+			Values: []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 60000, Value: 2}, {Timestamp: 120000, Value: 3}},
+		},
+	}}}
+	ctx := context.Background()
+
+	v, w, err := api.Query(ctx, `sum(requests_total)`, time.Unix(60, 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(w, v1.Warnings{"raw warning"}) {
+		t.Fatalf("Unexpected warnings: %v", w)
+	}
+	expectedVector := model.Vector{{Metric: model.Metric{}, Value: 62, Timestamp: 60000}}
+	if !reflect.DeepEqual(v, expectedVector) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expectedVector, v)
+	}
+
+	v, _, err = api.QueryRange(ctx, `increase(requests_total{job="a"}[1m])`, v1.Range{
+		Start: time.Unix(60, 0),
+		End:   time.Unix(120, 0),
+		Step:  time.Minute,
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expectedMatrix := model.Matrix{{
+		Metric: model.Metric{"job": "a"},
+		Values: []model.SamplePair{{Timestamp: 60000, Value: 60}, {Timestamp: 120000, Value: 120}},
+	}}
+	if !reflect.DeepEqual(v, expectedMatrix) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expectedMatrix, v)
+	}
+}
+
+// pushdownAPI is a rawAPI that also evaluates queries (badly, by returning a fixed result)
+type pushdownAPI struct {
+	rawAPI
+}
+
+func (p *pushdownAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	return model.Vector{{Metric: model.Metric{"pushed": "true"}, Timestamp: model.TimeFromUnix(ts.Unix())}}, nil, nil
+}
+
+func TestFallbackAPI(t *testing.T) {
+	api := &FallbackAPI{
+		API: &pushdownAPI{rawAPI{matrix: model.Matrix{{
+			Metric: model.Metric{"__name__": "up"},
+			Values: []model.SamplePair{{Timestamp: 0, Value: 1}},
+		}}}},
+		// Only aggregations are supported
+		Supported: func(expr parser.Expr) bool {
+			_, ok := expr.(*parser.AggregateExpr)
+			return ok
+		},
+	}
+	ctx := context.Background()
+
+	v, _, err := api.Query(ctx, `sum(up)`, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if v.(model.Vector)[0].Metric["pushed"] != "true" {
+		t.Fatalf("Expected supported query to be pushed down, got %v", v)
+	}
+
+	v, _, err = api.Query(ctx, `absent(up)`, time.Unix(0, 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(v.(model.Vector)) != 0 {
+		t.Fatalf("Expected unsupported query to be evaluated locally, got %v", v)
+	}
+}
+
+func TestSetEngineOpts(t *testing.T) {
+	defaultEngine := getEngine()
+	defer func() { engine, lookbackDelta = defaultEngine, defaultLookbackDelta }()
+
+	api := &API{API: &rawAPI{matrix: model.Matrix{{
+		Metric: model.Metric{"__name__": "requests_total", "job": "a"},
+		Values: []model.SamplePair{{Timestamp: 0, Value: 0}, {Timestamp: 60000, Value: 60}},
+	}}}}
+	ctx := context.Background()
+
+	// The default lookback finds the sample 90s earlier
+	v, _, err := api.Query(ctx, `requests_total`, time.Unix(150, 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(v.(model.Vector)) != 1 {
+		t.Fatalf("Expected a single sample, got %v", v)
+	}
+
+	SetEngineOpts(promql.EngineOpts{MaxSamples: 1, Timeout: time.Minute, LookbackDelta: time.Minute})
+	v, _, err = api.Query(ctx, `requests_total`, time.Unix(150, 0))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(v.(model.Vector)) != 0 {
+		t.Fatalf("Expected no samples within the lookback, got %v", v)
+	}
+	if d := LookbackDelta(); d != time.Minute {
+		t.Fatalf("Expected a lookback delta of 1m, got %v", d)
+	}
+	if _, _, err := api.QueryRange(ctx, `requests_total`, v1.Range{Start: time.Unix(0, 0), End: time.Unix(60, 0), Step: time.Minute}); err == nil {
+		t.Fatalf("Expected the max samples to be exceeded")
+	}
+}
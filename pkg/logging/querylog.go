@@ -0,0 +1,179 @@
+package logging
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jacksontj/promxy/pkg/limits"
+	"github.com/jacksontj/promxy/pkg/querystats"
+)
+
+// QueryLogEntry is a single line in the query log
+type QueryLogEntry struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client,omitempty"`
+	Endpoint string    `json:"endpoint"`
+	Query    string    `json:"query"`
+	Start    string    `json:"start,omitempty"`
+	End      string    `json:"end,omitempty"`
+	Step     float64   `json:"step,omitempty"`
+	Status   int       `json:"status"`
+	Duration float64   `json:"duration"`
+	Samples  int       `json:"samples"`
+
+	// ServerGroups are all the downstream calls made for this query by servergroup
+	ServerGroups map[string][]querystats.DownstreamCall `json:"servergroups"`
+}
+
+// NewQueryLogger opens (or creates) the file at path and returns a QueryLogger that
+// will write all queries taking at least threshold to it
+func NewQueryLogger(path string, threshold time.Duration) (*QueryLogger, error) {
+	q := &QueryLogger{path: path, threshold: threshold}
+	if err := q.Reopen(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// QueryLogger writes slow queries as JSON lines to a file
+type QueryLogger struct {
+	path      string
+	threshold time.Duration
+
+	l sync.Mutex
+	f *os.File
+}
+
+// Reopen closes and re-opens the log file, this is to be called after the file is rotated
+func (q *QueryLogger) Reopen() error {
+	f, err := os.OpenFile(q.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+
+	q.l.Lock()
+	defer q.l.Unlock()
+	if q.f != nil {
+		q.f.Close()
+	}
+	q.f = f
+	return nil
+}
+
+// Close closes the log file
+func (q *QueryLogger) Close() error {
+	q.l.Lock()
+	defer q.l.Unlock()
+	return q.f.Close()
+}
+
+// Log writes the entry to the log file
+func (q *QueryLogger) Log(e *QueryLogEntry) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		logrus.Errorf("Error marshaling query log entry: %v", err)
+		return
+	}
+
+	q.l.Lock()
+	defer q.l.Unlock()
+	if _, err := q.f.Write(append(b, '\n')); err != nil {
+		logrus.Errorf("Error writing to query log: %v", err)
+	}
+}
+
+// Handler wraps next so that all query and query_range calls (to the API served under
+// apiPrefix) which take longer than the threshold are written to the log
+func (q *QueryLogger) Handler(apiPrefix string, next http.Handler) http.Handler {
+	if q == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		endpoint := limits.EndpointForPath(apiPrefix, r.URL.Path)
+		switch endpoint {
+		case limits.EndpointQuery, limits.EndpointQueryRange:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Parse the form before the handler consumes the body (of POST requests), as we
+		// read the query params afterwards
+		r.ParseForm()
+
+		stats := &querystats.Stats{}
+		r = r.WithContext(querystats.NewContext(r.Context(), stats))
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+
+		start := time.Now()
+		next.ServeHTTP(sw, r)
+		took := time.Since(start)
+		if took < q.threshold {
+			return
+		}
+
+		e := &QueryLogEntry{
+			Time:         start.UTC(),
+			Client:       limits.IdentityFromContext(r.Context()),
+			Endpoint:     string(endpoint),
+			Query:        r.FormValue("query"),
+			Status:       sw.status,
+			Duration:     took.Seconds(),
+			Samples:      stats.Samples(),
+			ServerGroups: stats.ByServerGroup(),
+		}
+		if endpoint == limits.EndpointQuery {
+			e.Start = formatTimeParam(r.FormValue("time"), start)
+			e.End = e.Start
+		} else {
+			e.Start = formatTimeParam(r.FormValue("start"), start)
+			e.End = formatTimeParam(r.FormValue("end"), start)
+			e.Step = parseStepParam(r.FormValue("step"))
+		}
+		q.Log(e)
+	})
+}
+
+// formatTimeParam converts a time as given to the prometheus API (unix seconds or RFC3339)
+// to RFC3339. If the param is empty (meaning "now") def is used
+func formatTimeParam(s string, def time.Time) string {
+	if s == "" {
+		return def.UTC().Format(time.RFC3339Nano)
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, ns := math.Modf(f)
+		return time.Unix(int64(sec), int64(ns*float64(time.Second))).UTC().Format(time.RFC3339Nano)
+	}
+	// If it isn't a float we assume its already formatted
+	return s
+}
+
+// parseStepParam converts a step as given to the prometheus API to seconds
+func parseStepParam(s string) float64 {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	if d, err := model.ParseDuration(s); err == nil {
+		return time.Duration(d).Seconds()
+	}
+	return 0
+}
+
+// statusWriter records the status code written to the ResponseWriter
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
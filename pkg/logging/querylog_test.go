@@ -0,0 +1,93 @@
+package logging
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jacksontj/promxy/pkg/querystats"
+)
+
+func TestQueryLogger(t *testing.T) {
+	dir, err := ioutil.TempDir("", "querylog")
+	if err != nil {
+		t.Fatalf("Error creating tempdir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	logPath := filepath.Join(dir, "query.log")
+
+	q, err := NewQueryLogger(logPath, 10*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Error creating query logger: %v", err)
+	}
+	defer q.Close()
+
+	h := q.Handler("/api/v1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The body is consumed (as the API does), so the logger has to parse the form first
+		ioutil.ReadAll(r.Body)
+		if r.FormValue("query") == "slow" {
+			stats := querystats.FromContext(r.Context())
+			_, call := stats.StartCall(context.TODO(), "sg1", "host1:9090", "query")
+			time.Sleep(20 * time.Millisecond)
+			call.Finish(model.Vector{&model.Sample{}, &model.Sample{}}, nil)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	for _, u := range []string{
+		"/api/v1/query?query=fast",
+		"/api/v1/query_range?query=slow&start=0&end=60&step=15s",
+		"/api/v1/series?match[]=slow",
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", u, nil))
+	}
+	req := httptest.NewRequest("POST", "/api/v1/query", strings.NewReader("query=slow&time=60"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	f, err := os.Open(logPath)
+	if err != nil {
+		t.Fatalf("Error opening query log: %v", err)
+	}
+	defer f.Close()
+
+	var entries []QueryLogEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e QueryLogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("Error unmarshaling query log line: %v", err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 entries, got %d: %v", len(entries), entries)
+	}
+	if e := entries[1]; e.Query != "slow" || e.Endpoint != "query" || e.Start != "1970-01-01T00:01:00Z" {
+		t.Fatalf("mismatch in POST entry: %v", e)
+	}
+	e := entries[0]
+	if e.Query != "slow" || e.Endpoint != "query_range" || e.Step != 15 {
+		t.Fatalf("mismatch in entry: %v", e)
+	}
+	if e.Start != "1970-01-01T00:00:00Z" || e.End != "1970-01-01T00:01:00Z" {
+		t.Fatalf("mismatch in time range: start=%s end=%s", e.Start, e.End)
+	}
+	if e.Samples != 2 {
+		t.Fatalf("expected 2 samples, got %d", e.Samples)
+	}
+	if calls := e.ServerGroups["sg1"]; len(calls) != 1 || calls[0].Target != "host1:9090" {
+		t.Fatalf("mismatch in servergroup breakdown: %v", e.ServerGroups)
+	}
+}
@@ -0,0 +1,165 @@
+package lookup
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/jacksontj/promxy/pkg/localeval"
+)
+
+// SampleInterval is the (max) interval of the samples of the (constant) series returned by GetValue.
+// This is well under the lookback delta so that the series have no gaps for instant vector selectors,
+// and neither for range selectors as short as a minute (e.g. `max_over_time(service_owner_info[1m])`)
+const SampleInterval = time.Minute
+
+// NewAPI returns an API serving series
+func NewAPI(series []Series) *API {
+	return &API{series: series}
+}
+
+// API implements promclient.API for a fixed set of series which have a constant value
+// for all time. PromQL is evaluated in promxy (with localeval)
+type API struct {
+	series []Series
+}
+
+// matching returns the series that match all of matchers
+func (a *API) matching(matchers []*labels.Matcher) []Series {
+	var ret []Series
+SERIES_LOOP:
+	for _, s := range a.series {
+		for _, m := range matchers {
+			if !m.Matches(s.Labels.Get(m.Name)) {
+				continue SERIES_LOOP
+			}
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (a *API) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	names := make(map[string]struct{})
+	for _, s := range a.series {
+		for _, l := range s.Labels {
+			names[l.Name] = struct{}{}
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (a *API) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	values := make(map[string]struct{})
+	for _, s := range a.series {
+		if v := s.Labels.Get(label); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	ret := make(model.LabelValues, 0, len(values))
+	for v := range values {
+		ret = append(ret, model.LabelValue(v))
+	}
+	sort.Sort(ret)
+	return ret, nil, nil
+}
+
+// Query performs a query for the given time.
+func (a *API) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	return (&localeval.API{API: a}).Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (a *API) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	return (&localeval.API{API: a}).QueryRange(ctx, query, r)
+}
+
+// Series finds series by label matchers.
+func (a *API) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	// The same series may match multiple selectors
+	seen := make(map[uint64]struct{})
+	var ret []model.LabelSet
+	for _, match := range matches {
+		matchers, err := parser.ParseMetricSelector(match)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, s := range a.matching(matchers) {
+			hash := s.Labels.Hash()
+			if _, ok := seen[hash]; ok {
+				continue
+			}
+			seen[hash] = struct{}{}
+			ret = append(ret, labelSet(s.Labels))
+		}
+	}
+	return ret, nil, nil
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range. As the series are
+// constant we only return a sample every SampleInterval (or half the lookback delta of the engine
+// evaluating them, if that is shorter) and one at the end
+func (a *API) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	interval := SampleInterval
+	if lookback := localeval.LookbackDelta() / 2; lookback < interval {
+		interval = lookback
+	}
+	times := sampleTimes(model.TimeFromUnixNano(start.UnixNano()), model.TimeFromUnixNano(end.UnixNano()), interval)
+
+	series := a.matching(matchers)
+	matrix := make(model.Matrix, len(series))
+	for i, s := range series {
+		values := make([]model.SamplePair, len(times))
+		for j, t := range times {
+			values[j] = model.SamplePair{Timestamp: t, Value: model.SampleValue(s.Value)}
+		}
+		matrix[i] = &model.SampleStream{Metric: model.Metric(labelSet(s.Labels)), Values: values}
+	}
+	return matrix, nil, nil
+}
+
+// sampleTimes returns the multiples of interval within [start, end] followed by end (if it isn't one)
+func sampleTimes(start, end model.Time, interval time.Duration) []model.Time {
+	step := model.Time(interval / time.Millisecond)
+	if step <= 0 {
+		return []model.Time{end}
+	}
+	t := start
+	if r := t % step; r > 0 {
+		t += step - r
+	} else if r < 0 {
+		t -= r
+	}
+
+	var times []model.Time
+	if t <= end {
+		times = make([]model.Time, 0, (end-t)/step+2)
+	}
+	for ; t <= end; t += step {
+		times = append(times, t)
+	}
+	if len(times) == 0 || times[len(times)-1] != end {
+		times = append(times, end)
+	}
+	return times
+}
+
+func labelSet(lset labels.Labels) model.LabelSet {
+	ret := make(model.LabelSet, len(lset))
+	for _, l := range lset {
+		ret[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return ret
+}
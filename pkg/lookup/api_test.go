@@ -0,0 +1,118 @@
+package lookup
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestAPI(t *testing.T) {
+	api := NewAPI([]Series{
+		{Labels: labels.FromStrings("__name__", "service_owner_info", "service", "api", "team", "core"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "service_owner_info", "service", "web", "team", "frontend"), Value: 1},
+	})
+	ctx := context.Background()
+
+	t.Run("GetValue", func(t *testing.T) {
+		matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "service", "api")}
+		// There is a sample every SampleInterval (1m) and at the end
+		v, _, err := api.GetValue(ctx, time.Unix(30, 0), time.Unix(200, 0), matchers)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := model.Matrix{{
+			Metric: model.Metric{"__name__": "service_owner_info", "service": "api", "team": "core"},
+			Values: []model.SamplePair{{Timestamp: 60000, Value: 1}, {Timestamp: 120000, Value: 1}, {Timestamp: 180000, Value: 1}, {Timestamp: 200000, Value: 1}},
+		}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+
+		// Ranges within a SampleInterval only have the sample at the end
+		v, _, err = api.GetValue(ctx, time.Unix(70, 0), time.Unix(110, 0), matchers)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if m := v.(model.Matrix); len(m) != 1 || !reflect.DeepEqual(m[0].Values, []model.SamplePair{{Timestamp: 110000, Value: 1}}) {
+			t.Fatalf("Expected a single sample at the end, got %v", v)
+		}
+	})
+
+	t.Run("Series", func(t *testing.T) {
+		v, _, err := api.Series(ctx, []string{`{team="core"}`, `{service="api"}`}, time.Unix(0, 0), time.Unix(60, 0))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := []model.LabelSet{{"__name__": "service_owner_info", "service": "api", "team": "core"}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("LabelNames", func(t *testing.T) {
+		v, _, err := api.LabelNames(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := []string{"__name__", "service", "team"}; !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("LabelValues", func(t *testing.T) {
+		v, _, err := api.LabelValues(ctx, "team")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := (model.LabelValues{"core", "frontend"}); !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		v, _, err := api.Query(ctx, `count by (team) (service_owner_info)`, time.Unix(1000, 0))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		// The order of aggregated series isn't defined
+		sort.Sort(v.(model.Vector))
+		expected := model.Vector{
+			{Metric: model.Metric{"team": "core"}, Value: 1, Timestamp: 1000000},
+			{Metric: model.Metric{"team": "frontend"}, Value: 1, Timestamp: 1000000},
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("QueryRange", func(t *testing.T) {
+		r := v1.Range{Start: time.Unix(0, 0), End: time.Unix(600, 0), Step: 5 * time.Minute}
+		v, _, err := api.QueryRange(ctx, `service_owner_info{service="web"}`, r)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := model.Matrix{{
+			Metric: model.Metric{"__name__": "service_owner_info", "service": "web", "team": "frontend"},
+			Values: []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 300000, Value: 1}, {Timestamp: 600000, Value: 1}},
+		}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+
+		// Range selectors shorter than the lookback delta have a sample at every step
+		r = v1.Range{Start: time.Unix(0, 0), End: time.Unix(300, 0), Step: 30 * time.Second}
+		v, _, err = api.QueryRange(ctx, `max_over_time(service_owner_info{service="web"}[1m])`, r)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if m := v.(model.Matrix); len(m) != 1 || len(m[0].Values) != 11 {
+			t.Fatalf("Expected a single series without gaps, got %v", v)
+		}
+	})
+}
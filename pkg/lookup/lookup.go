@@ -0,0 +1,200 @@
+// Package lookup loads static (info-style) series from a file, such as the owner of
+// each service, so that they can be joined with other metrics in PromQL
+package lookup
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/textparse"
+	"gopkg.in/yaml.v2"
+)
+
+// Format is the format of a lookup file
+type Format string
+
+const (
+	// FormatYAML is a list of series, each with its `labels` and an optional `value`:
+	//
+	//   - labels: {__name__: service_owner_info, service: api, team: core}
+	//     value: 1
+	FormatYAML Format = "yaml"
+	// FormatCSV has a header row of label names followed by a row per series. The
+	// value of the series is in the (optional) `__value__` column
+	FormatCSV Format = "csv"
+	// FormatOpenMetrics is the OpenMetrics text format (timestamps are ignored)
+	FormatOpenMetrics Format = "openmetrics"
+)
+
+// valueColumn is the CSV column with the value of the series
+const valueColumn = "__value__"
+
+// DefaultValue is the value of series that don't have one, as is the convention for info metrics
+const DefaultValue = 1
+
+// FormatFromPath returns the Format of the file at path from its extension
+func FormatFromPath(path string) (Format, error) {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".csv":
+		return FormatCSV, nil
+	case ".om", ".txt", ".prom":
+		return FormatOpenMetrics, nil
+	}
+	return "", fmt.Errorf("unable to determine the format of %s from its extension", path)
+}
+
+// Validate returns an error if f isn't a known Format
+func (f Format) Validate() error {
+	switch f {
+	case FormatYAML, FormatCSV, FormatOpenMetrics:
+		return nil
+	}
+	return fmt.Errorf("unknown lookup file format %q", f)
+}
+
+// Series is a series with a constant value
+type Series struct {
+	Labels labels.Labels
+	Value  float64
+}
+
+// yamlSeries is a Series in FormatYAML
+type yamlSeries struct {
+	Labels map[string]string `yaml:"labels"`
+	Value  *float64          `yaml:"value"`
+}
+
+// Parse parses the series in b. Series without a metric name are named metricName
+func Parse(b []byte, format Format, metricName string) ([]Series, error) {
+	var series []Series
+	var err error
+	switch format {
+	case FormatYAML:
+		series, err = parseYAML(b)
+	case FormatCSV:
+		series, err = parseCSV(b)
+	case FormatOpenMetrics:
+		series, err = parseOpenMetrics(b)
+	default:
+		err = format.Validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[uint64]struct{}, len(series))
+	for i, s := range series {
+		// Empty values are unset labels
+		builder := labels.NewBuilder(s.Labels)
+		if metricName != "" && s.Labels.Get(labels.MetricName) == "" {
+			builder.Set(labels.MetricName, metricName)
+		}
+		s.Labels = builder.Labels()
+		series[i] = s
+
+		if name, ok := s.Labels.HasDuplicateLabelNames(); ok {
+			return nil, fmt.Errorf("series %v has duplicate label %q", s.Labels, name)
+		}
+		if !model.IsValidMetricName(model.LabelValue(s.Labels.Get(labels.MetricName))) {
+			return nil, fmt.Errorf("series %v doesn't have a valid metric name", s.Labels)
+		}
+		for _, l := range s.Labels {
+			if !model.LabelName(l.Name).IsValid() {
+				return nil, fmt.Errorf("series %v has an invalid label name %q", s.Labels, l.Name)
+			}
+		}
+		hash := s.Labels.Hash()
+		if _, ok := seen[hash]; ok {
+			return nil, fmt.Errorf("duplicate series %v", s.Labels)
+		}
+		seen[hash] = struct{}{}
+	}
+
+	sort.Slice(series, func(i, j int) bool {
+		return labels.Compare(series[i].Labels, series[j].Labels) < 0
+	})
+	return series, nil
+}
+
+func parseYAML(b []byte) ([]Series, error) {
+	var items []yamlSeries
+	if err := yaml.UnmarshalStrict(b, &items); err != nil {
+		return nil, err
+	}
+	series := make([]Series, 0, len(items))
+	for _, item := range items {
+		s := Series{Labels: labels.FromMap(item.Labels), Value: DefaultValue}
+		if item.Value != nil {
+			s.Value = *item.Value
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+func parseCSV(b []byte) ([]Series, error) {
+	r := csv.NewReader(bytes.NewReader(b))
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var series []Series
+	for i := 1; ; i++ {
+		row, err := r.Read()
+		if err == io.EOF {
+			return series, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s := Series{Value: DefaultValue}
+		for j, name := range header {
+			if name != valueColumn {
+				s.Labels = append(s.Labels, labels.Label{Name: name, Value: row[j]})
+				continue
+			}
+			if s.Value, err = strconv.ParseFloat(row[j], 64); err != nil {
+				return nil, fmt.Errorf("row %d: invalid value %q: %v", i, row[j], err)
+			}
+		}
+		sort.Sort(s.Labels)
+		series = append(series, s)
+	}
+}
+
+func parseOpenMetrics(b []byte) ([]Series, error) {
+	p := textparse.NewOpenMetricsParser(b)
+	var series []Series
+	for {
+		entry, err := p.Next()
+		if err == io.EOF {
+			return series, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if entry != textparse.EntrySeries {
+			continue
+		}
+
+		var lset labels.Labels
+		p.Metric(&lset)
+		_, _, v := p.Series()
+		series = append(series, Series{Labels: lset, Value: v})
+	}
+}
@@ -0,0 +1,137 @@
+package lookup
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+func TestParse(t *testing.T) {
+	expected := []Series{
+		{Labels: labels.FromStrings("__name__", "service_owner_info", "service", "api", "team", "core"), Value: 1},
+		{Labels: labels.FromStrings("__name__", "service_owner_info", "service", "web", "team", "frontend"), Value: 2},
+	}
+
+	tests := []struct {
+		name       string
+		format     Format
+		metricName string
+		in         string
+		out        []Series
+		err        bool
+	}{
+		{
+			name:   "yaml",
+			format: FormatYAML,
+			in: `
+- labels: {__name__: service_owner_info, service: web, team: frontend}
+  value: 2
+- labels: {__name__: service_owner_info, service: api, team: core}
+`,
+			out: expected,
+		},
+		{
+			name:       "csv",
+			format:     FormatCSV,
+			metricName: "service_owner_info",
+			in: `service, team, __value__
+web, frontend, 2
+api, core, 1
+`,
+			out: expected,
+		},
+		{
+			// Empty values are unset labels
+			name:       "csv empty",
+			format:     FormatCSV,
+			metricName: "service_owner_info",
+			in: `service,team
+api,
+`,
+			out: []Series{{Labels: labels.FromStrings("__name__", "service_owner_info", "service", "api"), Value: 1}},
+		},
+		{
+			name:   "openmetrics",
+			format: FormatOpenMetrics,
+			in: `# TYPE service_owner_info gauge
+service_owner_info{service="web",team="frontend"} 2 1000
+service_owner_info{service="api",team="core"} 1
+# EOF
+`,
+			out: expected,
+		},
+		{
+			name:   "missing metric name",
+			format: FormatCSV,
+			in:     "service\napi\n",
+			err:    true,
+		},
+		{
+			name:       "duplicate series",
+			format:     FormatCSV,
+			metricName: "service_owner_info",
+			in:         "service\napi\napi\n",
+			err:        true,
+		},
+		{
+			name:       "invalid label name",
+			format:     FormatCSV,
+			metricName: "service_owner_info",
+			in:         "service-name\napi\n",
+			err:        true,
+		},
+		{
+			name:       "invalid value",
+			format:     FormatCSV,
+			metricName: "service_owner_info",
+			in:         "service,__value__\napi,one\n",
+			err:        true,
+		},
+		{
+			name:   "unknown yaml field",
+			format: FormatYAML,
+			in:     "- labels: {__name__: a}\n  values: 1\n",
+			err:    true,
+		},
+		{
+			name:   "unknown format",
+			format: "json",
+			err:    true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			series, err := Parse([]byte(test.in), test.format, test.metricName)
+			if test.err {
+				if err == nil {
+					t.Fatalf("Expected an error, got %v", series)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(series, test.out) {
+				t.Fatalf("mismatch\nexpected=%v\nactual=%v", test.out, series)
+			}
+		})
+	}
+}
+
+func TestFormatFromPath(t *testing.T) {
+	for path, expected := range map[string]Format{
+		"owners.yaml": FormatYAML,
+		"owners.yml":  FormatYAML,
+		"owners.csv":  FormatCSV,
+		"owners.om":   FormatOpenMetrics,
+	} {
+		if format, err := FormatFromPath(path); err != nil || format != expected {
+			t.Fatalf("Expected %s for %s, got %s (%v)", expected, path, format, err)
+		}
+	}
+	if _, err := FormatFromPath("owners.json"); err == nil {
+		t.Fatalf("Expected an error for an unknown extension")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/pkg/labels"
 	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/storage"
 	"github.com/prometheus/prometheus/storage/remote"
 
 	"github.com/jacksontj/promxy/pkg/promhttputil"
@@ -61,13 +62,22 @@ type PromAPIRemoteRead struct {
 
 // GetValue loads the raw data for a given set of matchers in the time range
 func (p *PromAPIRemoteRead) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
-	query, err := remote.ToQuery(int64(timestamp.FromTime(start)), int64(timestamp.FromTime(end)), matchers, nil)
+	matrix, err := remoteRead(ctx, p.ReadClient, start, end, matchers, nil)
 	if err != nil {
 		return nil, nil, err
 	}
-	result, err := p.ReadClient.Read(ctx, query)
+	return matrix, nil, nil
+}
+
+// remoteRead loads the series (and their samples) matching matchers in the time range through client
+func remoteRead(ctx context.Context, client remote.ReadClient, start, end time.Time, matchers []*labels.Matcher, hints *storage.SelectHints) (model.Matrix, error) {
+	query, err := remote.ToQuery(int64(timestamp.FromTime(start)), int64(timestamp.FromTime(end)), matchers, hints)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	result, err := client.Read(ctx, query)
+	if err != nil {
+		return nil, err
 	}
 
 	// convert result (timeseries) to SampleStream
@@ -92,5 +102,5 @@ func (p *PromAPIRemoteRead) GetValue(ctx context.Context, start, end time.Time,
 		}
 	}
 
-	return matrix, nil, nil
+	return matrix, nil
 }
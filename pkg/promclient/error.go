@@ -0,0 +1,46 @@
+package promclient
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// ErrorAPI returns Err for all calls. This is used in place of an API that
+// can't be created (e.g. when there are too few targets to meet the quorum)
+type ErrorAPI struct {
+	Err error
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (e *ErrorAPI) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	return nil, nil, e.Err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (e *ErrorAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	return nil, nil, e.Err
+}
+
+// Query performs a query for the given time.
+func (e *ErrorAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	return nil, nil, e.Err
+}
+
+// QueryRange performs a query for the given range.
+func (e *ErrorAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	return nil, nil, e.Err
+}
+
+// Series finds series by label matchers.
+func (e *ErrorAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	return nil, nil, e.Err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (e *ErrorAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	return nil, nil, e.Err
+}
@@ -0,0 +1,62 @@
+package promclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// DefaultLookbackDelta is prometheus' default lookback delta, which is how far back instant
+// vector selectors look for a sample
+const DefaultLookbackDelta = 5 * time.Minute
+
+// Lookback returns how far before its evaluation time expr selects data. This is the largest range
+// (or lookbackDelta, for instant vector selectors) of its selectors, plus their offsets and the
+// ranges and offsets of the subqueries they are in
+func Lookback(expr parser.Expr, lookbackDelta time.Duration) time.Duration {
+	var lookback time.Duration
+	parser.Inspect(context.Background(), &parser.EvalStmt{Expr: expr}, func(node parser.Node, path []parser.Node) error {
+		n, ok := node.(*parser.VectorSelector)
+		if !ok {
+			return nil
+		}
+		d := n.Offset + lookbackDelta
+		if len(path) > 0 {
+			if m, ok := path[len(path)-1].(*parser.MatrixSelector); ok {
+				d = n.Offset + m.Range
+			}
+		}
+		for _, p := range path {
+			if s, ok := p.(*parser.SubqueryExpr); ok {
+				d += s.Range + s.Offset
+			}
+		}
+		if d > lookback {
+			lookback = d
+		}
+		return nil
+	}, nil)
+	return lookback
+}
+
+// QueryLookback returns the Lookback of query (or lookbackDelta if it can't be parsed)
+func QueryLookback(query string, lookbackDelta time.Duration) time.Duration {
+	expr, err := parser.ParseExpr(query)
+	if err != nil {
+		return lookbackDelta
+	}
+	return Lookback(expr, lookbackDelta)
+}
+
+// SmallestRange returns the smallest range of the range selectors in expr (or 0 if it has none)
+func SmallestRange(expr parser.Expr) time.Duration {
+	var smallest time.Duration
+	parser.Inspect(context.Background(), &parser.EvalStmt{Expr: expr}, func(node parser.Node, _ []parser.Node) error {
+		if m, ok := node.(*parser.MatrixSelector); ok && (smallest == 0 || m.Range < smallest) {
+			smallest = m.Range
+		}
+		return nil
+	}, nil)
+	return smallest
+}
@@ -0,0 +1,41 @@
+package promclient
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+func TestLookback(t *testing.T) {
+	tests := []struct {
+		query    string
+		lookback time.Duration
+		smallest time.Duration
+	}{
+		{query: `up`, lookback: 5 * time.Minute},
+		{query: `up offset 1h`, lookback: time.Hour + 5*time.Minute},
+		{query: `rate(foo[10m]) / rate(bar[1h])`, lookback: time.Hour, smallest: 10 * time.Minute},
+		{query: `max_over_time(rate(foo[1m])[1h:1m] offset 5m)`, lookback: time.Hour + 6*time.Minute, smallest: time.Minute},
+		{query: `time()`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			expr, err := parser.ParseExpr(test.query)
+			if err != nil {
+				t.Fatalf("Error parsing query: %v", err)
+			}
+			if lookback := Lookback(expr, DefaultLookbackDelta); lookback != test.lookback {
+				t.Fatalf("mismatch in lookback expected=%v actual=%v", test.lookback, lookback)
+			}
+			if smallest := SmallestRange(expr); smallest != test.smallest {
+				t.Fatalf("mismatch in smallest range expected=%v actual=%v", test.smallest, smallest)
+			}
+		})
+	}
+
+	if lookback := QueryLookback(`rate(foo[`, time.Minute); lookback != time.Minute {
+		t.Fatalf("Expected the lookback delta for an invalid query, got %v", lookback)
+	}
+}
@@ -3,6 +3,7 @@ package promclient
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"sort"
 	"strings"
 	"time"
@@ -14,6 +15,8 @@ import (
 	"github.com/prometheus/prometheus/promql"
 
 	"github.com/jacksontj/promxy/pkg/promhttputil"
+	"github.com/jacksontj/promxy/pkg/querystats"
+	"github.com/jacksontj/promxy/pkg/sourcelabel"
 )
 
 // Since these error types magically add in their own prefixes, we need to get
@@ -60,44 +63,64 @@ func NormalizePromError(err error) error {
 // the specific API calls made through this multi client
 type MultiAPIMetricFunc func(i int, api, status string, took float64)
 
-// NewMultiAPI returns a MultiAPI
-func NewMultiAPI(apis []API, antiAffinity model.Time, metricFunc MultiAPIMetricFunc, requiredCount int) *MultiAPI {
+// NewMultiAPI returns a MultiAPI which dedupes series using gap fill with the given antiAffinity
+func NewMultiAPI(apis []API, antiAffinity model.Time, metricFunc MultiAPIMetricFunc, requiredCount int) (*MultiAPI, error) {
+	return NewMultiAPIWithStrategy(apis, promhttputil.NewGapFillStrategy(antiAffinity), metricFunc, requiredCount)
+}
+
+// NewMultiAPIWithStrategy returns a MultiAPI which dedupes series using mergeStrategy. An error is
+// returned if there are fewer than requiredCount apis for any key (meaning no call could succeed)
+func NewMultiAPIWithStrategy(apis []API, mergeStrategy promhttputil.MergeStrategy, metricFunc MultiAPIMetricFunc, requiredCount int) (*MultiAPI, error) {
 	fingerprintCounts := make(map[model.Fingerprint]int)
+	fingerprintKeys := make(map[model.Fingerprint]model.LabelSet)
 	apiFingerprints := make([]model.Fingerprint, len(apis))
 	for i, api := range apis {
 		var fingerprint model.Fingerprint
 		if apiLabels, ok := api.(APILabels); ok {
 			if keys := apiLabels.Key(); keys != nil {
 				fingerprint = keys.FastFingerprint()
+				fingerprintKeys[fingerprint] = keys
 			}
 		}
 		apiFingerprints[i] = fingerprint
 		fingerprintCounts[fingerprint]++
 	}
 
-	for _, v := range fingerprintCounts {
+	for k, v := range fingerprintCounts {
 		if v < requiredCount {
-			// TODO: return an error
-			panic("not possible")
+			return nil, fmt.Errorf("%d successful responses required but only %d downstreams for %v", requiredCount, v, fingerprintKeys[k])
 		}
 	}
 
 	return &MultiAPI{
 		apis:            apis,
 		apiFingerprints: apiFingerprints,
-		antiAffinity:    antiAffinity,
+		mergeStrategy:   mergeStrategy,
 		metricFunc:      metricFunc,
 		requiredCount:   requiredCount,
-	}
+	}, nil
 }
 
 // MultiAPI implements the API interface while merging the results from the apis it wraps
 type MultiAPI struct {
 	apis            []API
 	apiFingerprints []model.Fingerprint
-	antiAffinity    model.Time
+	mergeStrategy   promhttputil.MergeStrategy
 	metricFunc      MultiAPIMetricFunc
 	requiredCount   int // number "per key" that we require to respond
+
+	// QuorumWarnings adds a warning to responses for which requiredCount was met despite
+	// some downstreams failing. It is only meant for explicitly configured quorums, as with
+	// the default (any single success) partial failures are expected and not worth a warning
+	QuorumWarnings bool
+}
+
+// addQuorumWarning adds a warning if QuorumWarnings is enabled and the required number of
+// downstreams responded despite some of them failing (with lastError)
+func (m *MultiAPI) addQuorumWarning(warnings promhttputil.WarningSet, lastError error) {
+	if m.QuorumWarnings && lastError != nil {
+		warnings.AddWarning(fmt.Sprintf("some downstream servers failed, results may be incomplete: %v", lastError))
+	}
 }
 
 func (m *MultiAPI) recordMetric(i int, api, status string, took float64) {
@@ -106,6 +129,22 @@ func (m *MultiAPI) recordMetric(i int, api, status string, took float64) {
 	}
 }
 
+// mergeValues merges the values from all apis, recording the time spent in the query's stats (if any)
+func (m *MultiAPI) mergeValues(ctx context.Context, values ...model.Value) (model.Value, error) {
+	start := time.Now()
+	var v model.Value
+	var err error
+	if sourcelabel.Enabled(ctx) {
+		v, err = mergeValuesWithSources(m.mergeStrategy, values...)
+	} else {
+		v, err = promhttputil.MergeValuesWithStrategy(m.mergeStrategy, values...)
+	}
+	if stats := querystats.FromContext(ctx); stats != nil {
+		stats.AddMergeTime(time.Since(start))
+	}
+	return v, err
+}
+
 // LabelValues performs a query for the values of the given label.
 func (m *MultiAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
 	childContext, childContextCancel := context.WithCancel(ctx)
@@ -178,6 +217,7 @@ func (m *MultiAPI) LabelValues(ctx context.Context, label string) (model.LabelVa
 			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
 		}
 	}
+	m.addQuorumWarning(warnings, lastError)
 
 	sort.Sort(model.LabelValues(result))
 
@@ -254,6 +294,7 @@ func (m *MultiAPI) LabelNames(ctx context.Context) ([]string, v1.Warnings, error
 			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
 		}
 	}
+	m.addQuorumWarning(warnings, lastError)
 
 	stringResult := make([]string, 0, len(result))
 	for k := range result {
@@ -302,7 +343,7 @@ func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model
 	}
 
 	// Wait for results as we get them
-	var result model.Value
+	results := make([]model.Value, 0, len(m.apis))
 	warnings := make(promhttputil.WarningSet)
 	var lastError error
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
@@ -322,15 +363,7 @@ func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model
 				lastError = ret.err
 			} else {
 				successMap[ret.ls]++
-				if result == nil {
-					result = ret.v
-				} else {
-					var err error
-					result, err = promhttputil.MergeValues(m.antiAffinity, result, ret.v)
-					if err != nil {
-						return nil, warnings.Warnings(), err
-					}
-				}
+				results = append(results, ret.v)
 			}
 		}
 	}
@@ -341,7 +374,12 @@ func (m *MultiAPI) Query(ctx context.Context, query string, ts time.Time) (model
 			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
 		}
 	}
+	m.addQuorumWarning(warnings, lastError)
 
+	result, err := m.mergeValues(ctx, results...)
+	if err != nil {
+		return nil, warnings.Warnings(), err
+	}
 	return result, warnings.Warnings(), nil
 }
 
@@ -382,7 +420,7 @@ func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 	}
 
 	// Wait for results as we get them
-	var result model.Value
+	results := make([]model.Value, 0, len(m.apis))
 	warnings := make(promhttputil.WarningSet)
 	var lastError error
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
@@ -402,15 +440,7 @@ func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 				lastError = ret.err
 			} else {
 				successMap[ret.ls]++
-				if result == nil {
-					result = ret.v
-				} else {
-					var err error
-					result, err = promhttputil.MergeValues(m.antiAffinity, result, ret.v)
-					if err != nil {
-						return nil, warnings.Warnings(), err
-					}
-				}
+				results = append(results, ret.v)
 			}
 		}
 	}
@@ -421,7 +451,12 @@ func (m *MultiAPI) QueryRange(ctx context.Context, query string, r v1.Range) (mo
 			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
 		}
 	}
+	m.addQuorumWarning(warnings, lastError)
 
+	result, err := m.mergeValues(ctx, results...)
+	if err != nil {
+		return nil, warnings.Warnings(), err
+	}
 	return result, warnings.Warnings(), nil
 }
 
@@ -497,6 +532,7 @@ func (m *MultiAPI) Series(ctx context.Context, matches []string, startTime time.
 			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
 		}
 	}
+	m.addQuorumWarning(warnings, lastError)
 
 	return result, warnings.Warnings(), nil
 }
@@ -539,7 +575,7 @@ func (m *MultiAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 	}
 
 	// Wait for results as we get them
-	var result model.Value
+	results := make([]model.Value, 0, len(m.apis))
 	warnings := make(promhttputil.WarningSet)
 	var lastError error
 	successMap := make(map[model.Fingerprint]int) // fingerprint -> success
@@ -559,15 +595,7 @@ func (m *MultiAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 				lastError = ret.err
 			} else {
 				successMap[ret.ls]++
-				if result == nil {
-					result = ret.v
-				} else {
-					var err error
-					result, err = promhttputil.MergeValues(m.antiAffinity, result, ret.v)
-					if err != nil {
-						return nil, warnings.Warnings(), err
-					}
-				}
+				results = append(results, ret.v)
 			}
 		}
 	}
@@ -578,6 +606,11 @@ func (m *MultiAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 			return nil, warnings.Warnings(), errors.Wrap(lastError, "Unable to fetch from downstream servers")
 		}
 	}
+	m.addQuorumWarning(warnings, lastError)
 
+	result, err := m.mergeValues(ctx, results...)
+	if err != nil {
+		return nil, warnings.Warnings(), err
+	}
 	return result, warnings.Warnings(), nil
 }
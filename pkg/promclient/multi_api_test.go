@@ -110,6 +110,15 @@ func (s *errorAPI) GetValue(ctx context.Context, start, end time.Time, matchers
 	return s.GetValue(ctx, start, end, matchers)
 }
 
+// mustNewMultiAPI returns a MultiAPI, panicing if it can't be created
+func mustNewMultiAPI(apis []API, antiAffinity model.Time, metricFunc MultiAPIMetricFunc, requiredCount int) *MultiAPI {
+	m, err := NewMultiAPI(apis, antiAffinity, metricFunc, requiredCount)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
 func TestMultiAPIMerging(t *testing.T) {
 	getSample := func(ls model.LabelSet) *model.Sample {
 		return &model.Sample{
@@ -178,7 +187,7 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// Ensure a single layer of multi merges
 		{
-			a: NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
 				&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 			}, model.Time(0), nil, 1),
@@ -195,12 +204,12 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// Ensure that a tree of multis work
 		{
-			a: NewMultiAPI([]API{
-				NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
+				mustNewMultiAPI([]API{
 					&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 					&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 				}, model.Time(0), nil, 1),
-				NewMultiAPI([]API{
+				mustNewMultiAPI([]API{
 					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 				}, model.Time(0), nil, 1),
@@ -218,23 +227,23 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// Ensure that a multi-level tree of multis work
 		{
-			a: NewMultiAPI([]API{
-				NewMultiAPI([]API{
-					NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
+				mustNewMultiAPI([]API{
+					mustNewMultiAPI([]API{
 						&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 						&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 					}, model.Time(0), nil, 1),
-					NewMultiAPI([]API{
+					mustNewMultiAPI([]API{
 						&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 						&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 					}, model.Time(0), nil, 1),
 				}, model.Time(0), nil, 2),
-				NewMultiAPI([]API{
-					NewMultiAPI([]API{
+				mustNewMultiAPI([]API{
+					mustNewMultiAPI([]API{
 						&AddLabelClient{stub, model.LabelSet{"b": "1"}},
 						&AddLabelClient{stub, model.LabelSet{"b": "1"}},
 					}, model.Time(0), nil, 1),
-					NewMultiAPI([]API{
+					mustNewMultiAPI([]API{
 						&AddLabelClient{stub, model.LabelSet{"b": "2"}},
 						&AddLabelClient{stub, model.LabelSet{"b": "2"}},
 					}, model.Time(0), nil, 1),
@@ -264,12 +273,12 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// In a tree, if a single node errors for each, we should still return no-error
 		{
-			a: NewMultiAPI([]API{
-				NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
+				mustNewMultiAPI([]API{
 					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
 					&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 				}, model.Time(0), nil, 1),
-				NewMultiAPI([]API{
+				mustNewMultiAPI([]API{
 					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "2"}}, fmt.Errorf("")},
 					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 				}, model.Time(0), nil, 1),
@@ -287,12 +296,12 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// In a tree, if any tree has all errors, we expect an error
 		{
-			a: NewMultiAPI([]API{
-				NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
+				mustNewMultiAPI([]API{
 					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
 					&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
 				}, model.Time(0), nil, 1),
-				NewMultiAPI([]API{
+				mustNewMultiAPI([]API{
 					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 					&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 				}, model.Time(0), nil, 1),
@@ -301,7 +310,7 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// if in a multi, all that "match" error, we should error
 		{
-			a: NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
 				&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
 				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
 			}, model.Time(0), nil, 1),
@@ -309,7 +318,7 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// however, in a multi if a single one succeeds for a given "group" then it should pass
 		{
-			a: NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
 				&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 				&errorAPI{&AddLabelClient{stub, model.LabelSet{"a": "1"}}, fmt.Errorf("")},
 				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
@@ -327,7 +336,7 @@ func TestMultiAPIMerging(t *testing.T) {
 		},
 		// multi with no labels
 		{
-			a: NewMultiAPI([]API{
+			a: mustNewMultiAPI([]API{
 				stub,
 				&AddLabelClient{stub, model.LabelSet{"a": "1"}},
 				&AddLabelClient{stub, model.LabelSet{"a": "2"}},
@@ -493,3 +502,39 @@ func TestMultiAPIMerging(t *testing.T) {
 		})
 	}
 }
+
+func TestMultiAPIQuorum(t *testing.T) {
+	stub := &stubAPI{
+		labelNames: func() []string {
+			return []string{"a"}
+		},
+	}
+	apis := []API{stub, stub, &errorAPI{stub, fmt.Errorf("down")}}
+
+	if _, err := NewMultiAPI(apis, model.Time(0), nil, 4); err == nil {
+		t.Fatalf("expected error requiring more successes than apis")
+	}
+
+	// 2 of 3 is met despite the error, so we expect a warning (if enabled)
+	for _, quorumWarnings := range []bool{false, true} {
+		multiAPI := mustNewMultiAPI(apis, model.Time(0), nil, 2)
+		multiAPI.QuorumWarnings = quorumWarnings
+		v, w, err := multiAPI.LabelNames(context.TODO())
+		if err != nil {
+			t.Fatalf("Unexpected Err: %v", err)
+		}
+		if len(v) != 1 || v[0] != "a" {
+			t.Fatalf("mismatch in value: %v", v)
+		}
+		if quorumWarnings && len(w) != 1 {
+			t.Fatalf("expected a single warning, got %v", w)
+		}
+		if !quorumWarnings && len(w) != 0 {
+			t.Fatalf("expected no warnings, got %v", w)
+		}
+	}
+
+	if _, _, err := mustNewMultiAPI(apis, model.Time(0), nil, 3).LabelNames(context.TODO()); err == nil {
+		t.Fatalf("missing expected err")
+	}
+}
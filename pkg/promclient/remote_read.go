@@ -0,0 +1,134 @@
+package promclient
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// DefaultRemoteReadLabelsLookback is the time range (before now) of the series that
+// RemoteReadAPI derives label names and values from if LabelsLookback isn't set
+const DefaultRemoteReadLabelsLookback = time.Hour
+
+// RemoteReadAPI implements the API using *only* the remote_read API, for stores that don't
+// implement the v1 HTTP API. Remote read only returns raw data, so Query and QueryRange return
+// ErrQueryNotSupported and Series and labels are derived from the series that remote read returns
+type RemoteReadAPI struct {
+	remote.ReadClient
+	// LabelsLookback is the time range (before now) of the series that label names and values
+	// are derived from, as remote read has no label API (defaults to DefaultRemoteReadLabelsLookback)
+	LabelsLookback time.Duration
+}
+
+// labelsRange returns the time range to load series from for LabelNames and LabelValues
+func (r *RemoteReadAPI) labelsRange() (time.Time, time.Time) {
+	lookback := r.LabelsLookback
+	if lookback <= 0 {
+		lookback = DefaultRemoteReadLabelsLookback
+	}
+	end := time.Now()
+	return end.Add(-lookback), end
+}
+
+// series returns the labelsets of all series that match matchers in the time range
+func (r *RemoteReadAPI) series(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) ([]model.LabelSet, error) {
+	// The hint lets stores that support it skip loading samples
+	hints := &storage.SelectHints{Start: timestamp.FromTime(start), End: timestamp.FromTime(end), Func: "series"}
+	matrix, err := remoteRead(ctx, r.ReadClient, start, end, matchers, hints)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]model.LabelSet, len(matrix))
+	for i, stream := range matrix {
+		ret[i] = model.LabelSet(stream.Metric)
+	}
+	return ret, nil
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (r *RemoteReadAPI) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	start, end := r.labelsRange()
+	lsets, err := r.series(ctx, start, end, []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, labels.MetricName, ".+")})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	names := make(map[string]struct{})
+	for _, lset := range lsets {
+		for name := range lset {
+			names[string(name)] = struct{}{}
+		}
+	}
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, nil, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (r *RemoteReadAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	start, end := r.labelsRange()
+	lsets, err := r.series(ctx, start, end, []*labels.Matcher{labels.MustNewMatcher(labels.MatchRegexp, label, ".+")})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := make(map[model.LabelValue]struct{})
+	for _, lset := range lsets {
+		if v, ok := lset[model.LabelName(label)]; ok {
+			values[v] = struct{}{}
+		}
+	}
+	ret := make(model.LabelValues, 0, len(values))
+	for v := range values {
+		ret = append(ret, v)
+	}
+	sort.Sort(ret)
+	return ret, nil, nil
+}
+
+// Query performs a query for the given time.
+func (r *RemoteReadAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	return nil, nil, ErrQueryNotSupported
+}
+
+// QueryRange performs a query for the given range.
+func (r *RemoteReadAPI) QueryRange(ctx context.Context, query string, rng v1.Range) (model.Value, v1.Warnings, error) {
+	return nil, nil, ErrQueryNotSupported
+}
+
+// Series finds series by label matchers.
+func (r *RemoteReadAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	var ret []model.LabelSet
+	for _, match := range matches {
+		matchers, err := parser.ParseMetricSelector(match)
+		if err != nil {
+			return nil, nil, err
+		}
+		lsets, err := r.series(ctx, startTime, endTime, matchers)
+		if err != nil {
+			return nil, nil, err
+		}
+		ret = MergeLabelSets(ret, lsets)
+	}
+	return ret, nil, nil
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (r *RemoteReadAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	matrix, err := remoteRead(ctx, r.ReadClient, start, end, matchers, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return matrix, nil, nil
+}
@@ -0,0 +1,105 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/storage/remote"
+)
+
+// fakeReadClient is a remote.ReadClient over an in-memory matrix
+type fakeReadClient struct {
+	matrix  model.Matrix
+	queries []*prompb.Query
+}
+
+func (f *fakeReadClient) Read(_ context.Context, query *prompb.Query) (*prompb.QueryResult, error) {
+	f.queries = append(f.queries, query)
+	matchers, err := remote.FromLabelMatchers(query.Matchers)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &prompb.QueryResult{}
+SERIES_LOOP:
+	for _, stream := range f.matrix {
+		for _, m := range matchers {
+			if !m.Matches(string(stream.Metric[model.LabelName(m.Name)])) {
+				continue SERIES_LOOP
+			}
+		}
+		ts := &prompb.TimeSeries{}
+		for k, v := range stream.Metric {
+			ts.Labels = append(ts.Labels, prompb.Label{Name: string(k), Value: string(v)})
+		}
+		for _, v := range stream.Values {
+			if int64(v.Timestamp) >= query.StartTimestampMs && int64(v.Timestamp) <= query.EndTimestampMs {
+				ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: int64(v.Timestamp), Value: float64(v.Value)})
+			}
+		}
+		result.Timeseries = append(result.Timeseries, ts)
+	}
+	return result, nil
+}
+
+func TestRemoteReadAPI(t *testing.T) {
+	now := model.TimeFromUnix(time.Now().Unix())
+	client := &fakeReadClient{matrix: model.Matrix{
+		{
+			Metric: model.Metric{"__name__": "up", "job": "a"},
+			Values: []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: now, Value: 1}},
+		},
+		{
+			Metric: model.Metric{"__name__": "up", "job": "b", "instance": "x"},
+			Values: []model.SamplePair{{Timestamp: now, Value: 0}},
+		},
+	}}
+	api := &RemoteReadAPI{ReadClient: client}
+	ctx := context.Background()
+
+	names, _, err := api.LabelNames(ctx)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []string{"__name__", "instance", "job"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, names)
+	}
+	// Label calls only load recent series (and hint that they don't need samples)
+	if q := client.queries[0]; q.StartTimestampMs < int64(now)-int64(2*time.Hour/time.Millisecond) || q.Hints.Func != "series" {
+		t.Fatalf("Unexpected label names query: %v", q)
+	}
+
+	values, _, err := api.LabelValues(ctx, "instance")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := (model.LabelValues{"x"}); !reflect.DeepEqual(values, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, values)
+	}
+
+	lsets, _, err := api.Series(ctx, []string{`up{job="a"}`}, time.Unix(0, 0), now.Time())
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []model.LabelSet{{"__name__": "up", "job": "a"}}; !reflect.DeepEqual(lsets, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, lsets)
+	}
+
+	v, _, err := api.GetValue(ctx, time.Unix(0, 0), time.Unix(10, 0), []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := model.Matrix{{Metric: model.Metric{"__name__": "up", "job": "a"}, Values: []model.SamplePair{{Timestamp: 0, Value: 1}}}}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+	}
+
+	if _, _, err := api.Query(ctx, "up", now.Time()); err != ErrQueryNotSupported {
+		t.Fatalf("Expected ErrQueryNotSupported, got %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package promclient
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+
+	"github.com/jacksontj/promxy/pkg/promhttputil"
+)
+
+// ReplicaLabelClient proxies a client and removes the replica labels from all results
+// (and from the matchers sent downstream) so that series from replicas that only differ
+// in these labels share a fingerprint and are merged together
+type ReplicaLabelClient struct {
+	API
+	Labels []model.LabelName
+	// Strategy merges the series which only differ in their replica labels within a single
+	// response (e.g. from a Thanos querier or a federating prometheus that returns all
+	// replicas). If nil the series are gap filled
+	Strategy promhttputil.MergeStrategy
+}
+
+func (c *ReplicaLabelClient) isReplicaLabel(name string) bool {
+	for _, l := range c.Labels {
+		if string(l) == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stripMatchers returns the matchers without any that are on a replica label
+func (c *ReplicaLabelClient) stripMatchers(matchers []*labels.Matcher) []*labels.Matcher {
+	filtered := make([]*labels.Matcher, 0, len(matchers))
+	for _, matcher := range matchers {
+		if !c.isReplicaLabel(matcher.Name) {
+			filtered = append(filtered, matcher)
+		}
+	}
+
+	// Prometheus doesn't support empty matchers (https://github.com/prometheus/prometheus/issues/2162)
+	if len(filtered) == 0 {
+		filtered = append(filtered, &labels.Matcher{
+			Type:  labels.MatchRegexp,
+			Name:  labels.MetricName,
+			Value: ".+",
+		})
+	}
+	return filtered
+}
+
+// replicaLabelVisitor implements the parser.Visitor interface to remove all matchers on replica labels
+type replicaLabelVisitor struct {
+	c *ReplicaLabelClient
+}
+
+// Visit removes the matchers on replica labels from all selectors
+func (r *replicaLabelVisitor) Visit(node parser.Node, path []parser.Node) (w parser.Visitor, err error) {
+	if vs, ok := node.(*parser.VectorSelector); ok {
+		vs.LabelMatchers = r.c.stripMatchers(vs.LabelMatchers)
+	}
+	return r, nil
+}
+
+// stripQuery removes all matchers on replica labels from the query
+func (c *ReplicaLabelClient) stripQuery(ctx context.Context, query string) (string, error) {
+	e, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", err
+	}
+	if _, err := parser.Walk(ctx, &replicaLabelVisitor{c}, &parser.EvalStmt{Expr: e}, e, nil, nil); err != nil {
+		return "", err
+	}
+	return e.String(), nil
+}
+
+// stripValue removes the replica labels from all series in v
+func (c *ReplicaLabelClient) stripValue(v model.Value) {
+	switch vTyped := v.(type) {
+	case model.Vector:
+		for _, item := range vTyped {
+			for _, l := range c.Labels {
+				delete(item.Metric, l)
+			}
+		}
+	case model.Matrix:
+		for _, item := range vTyped {
+			for _, l := range c.Labels {
+				delete(item.Metric, l)
+			}
+		}
+	}
+}
+
+// mergeReplicas removes the replica labels from all series in v and merges the series
+// that are then the same. The series of each replica (by the values of its replica labels)
+// are treated as a separate response, as if they came from different hosts
+func (c *ReplicaLabelClient) mergeReplicas(v model.Value) (model.Value, error) {
+	replicaKey := func(m model.Metric) string {
+		var key strings.Builder
+		for _, l := range c.Labels {
+			key.WriteString(string(m[l]))
+			key.WriteByte('\xff')
+		}
+		return key.String()
+	}
+
+	var replicas []model.Value
+	switch vTyped := v.(type) {
+	case model.Vector:
+		idx := make(map[string]int)
+		for _, item := range vTyped {
+			k := replicaKey(item.Metric)
+			i, ok := idx[k]
+			if !ok {
+				i = len(replicas)
+				idx[k] = i
+				replicas = append(replicas, model.Vector{})
+			}
+			replicas[i] = append(replicas[i].(model.Vector), item)
+		}
+	case model.Matrix:
+		idx := make(map[string]int)
+		for _, item := range vTyped {
+			k := replicaKey(item.Metric)
+			i, ok := idx[k]
+			if !ok {
+				i = len(replicas)
+				idx[k] = i
+				replicas = append(replicas, model.Matrix{})
+			}
+			replicas[i] = append(replicas[i].(model.Matrix), item)
+		}
+	}
+
+	c.stripValue(v)
+	if len(replicas) < 2 {
+		return v, nil
+	}
+	strategy := c.Strategy
+	if strategy == nil {
+		strategy = promhttputil.NewGapFillStrategy(0)
+	}
+	return promhttputil.MergeValuesWithStrategy(strategy, replicas...)
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (c *ReplicaLabelClient) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	v, w, err := c.API.LabelNames(ctx)
+	if err != nil {
+		return nil, w, err
+	}
+	filtered := make([]string, 0, len(v))
+	for _, name := range v {
+		if !c.isReplicaLabel(name) {
+			filtered = append(filtered, name)
+		}
+	}
+	return filtered, w, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (c *ReplicaLabelClient) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	if c.isReplicaLabel(label) {
+		return nil, nil, nil
+	}
+	return c.API.LabelValues(ctx, label)
+}
+
+// Query performs a query for the given time.
+func (c *ReplicaLabelClient) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	query, err := c.stripQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.Query(ctx, query, ts)
+	if err != nil {
+		return nil, w, err
+	}
+	v, err = c.mergeReplicas(v)
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (c *ReplicaLabelClient) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	query, err := c.stripQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	v, w, err := c.API.QueryRange(ctx, query, r)
+	if err != nil {
+		return nil, w, err
+	}
+	v, err = c.mergeReplicas(v)
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (c *ReplicaLabelClient) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	strippedMatches := make([]string, len(matches))
+	for i, match := range matches {
+		var err error
+		if strippedMatches[i], err = c.stripQuery(ctx, match); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	v, w, err := c.API.Series(ctx, strippedMatches, startTime, endTime)
+	if err != nil {
+		return nil, w, err
+	}
+	// Without the replica labels there may be duplicate series
+	ret := make([]model.LabelSet, 0, len(v))
+	for _, lset := range v {
+		for _, l := range c.Labels {
+			delete(lset, l)
+		}
+	}
+	return MergeLabelSets(ret, v), w, nil
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (c *ReplicaLabelClient) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	v, w, err := c.API.GetValue(ctx, start, end, c.stripMatchers(matchers))
+	if err != nil {
+		return nil, w, err
+	}
+	v, err = c.mergeReplicas(v)
+	return v, w, err
+}
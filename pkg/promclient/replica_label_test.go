@@ -0,0 +1,111 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/jacksontj/promxy/pkg/promhttputil"
+)
+
+func TestReplicaLabelClient(t *testing.T) {
+	newReplica := func(replica model.LabelValue, v model.SampleValue) API {
+		return &ReplicaLabelClient{
+			API: &stubAPI{
+				query: func() model.Value {
+					return model.Vector{{Metric: model.Metric{"__name__": "up", "replica": replica}, Value: v}}
+				},
+			},
+			Labels: []model.LabelName{"replica"},
+		}
+	}
+
+	m := mustNewMultiAPI([]API{newReplica("a", 1), newReplica("b", 1)}, model.TimeFromUnix(0), nil, 1)
+	v, _, err := m.Query(context.TODO(), "up", time.Now())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	vector := v.(model.Vector)
+	if len(vector) != 1 {
+		t.Fatalf("expected replicas to be merged into 1 series, got %v", vector)
+	}
+	if _, ok := vector[0].Metric["replica"]; ok {
+		t.Fatalf("replica label not removed: %v", vector)
+	}
+
+	recorder := &queryRecorderAPI{}
+	c := &ReplicaLabelClient{API: recorder, Labels: []model.LabelName{"replica"}}
+	if _, _, err := c.Query(context.TODO(), `rate(up{replica="a",job="foo"}[5m])`, time.Now()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if expected := `rate(up{job="foo"}[5m])`; recorder.queries[0] != expected {
+		t.Fatalf("mismatch in downstream query expected=%s actual=%s", expected, recorder.queries[0])
+	}
+}
+
+// A single downstream (e.g. a Thanos querier) may return the series of all replicas
+func TestReplicaLabelClientSingleResponse(t *testing.T) {
+	stream := func(replica model.LabelValue, times ...model.Time) *model.SampleStream {
+		s := &model.SampleStream{Metric: model.Metric{"__name__": "up", "replica": replica}}
+		for _, ts := range times {
+			s.Values = append(s.Values, model.SamplePair{Timestamp: ts, Value: 1})
+		}
+		return s
+	}
+	matrix := func() model.Value {
+		return model.Matrix{stream("a", 0, 10000, 40000), stream("b", 0, 10000, 20000, 30000, 40000)}
+	}
+	c := &ReplicaLabelClient{
+		API: &stubAPI{
+			query: func() model.Value {
+				return model.Vector{
+					{Metric: model.Metric{"__name__": "up", "replica": "a"}, Value: 1},
+					{Metric: model.Metric{"__name__": "up", "replica": "b"}, Value: 1},
+				}
+			},
+			queryRange: matrix,
+			getValue:   matrix,
+		},
+		Labels:   []model.LabelName{"replica"},
+		Strategy: promhttputil.NewGapFillStrategy(model.Time(2)),
+	}
+	ctx := context.TODO()
+	expected := model.Matrix{{
+		Metric: model.Metric{"__name__": "up"},
+		Values: []model.SamplePair{{Timestamp: 0, Value: 1}, {Timestamp: 10000, Value: 1}, {Timestamp: 20000, Value: 1}, {Timestamp: 30000, Value: 1}, {Timestamp: 40000, Value: 1}},
+	}}
+
+	t.Run("Query", func(t *testing.T) {
+		v, _, err := c.Query(ctx, "up", time.Now())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if vector := v.(model.Vector); len(vector) != 1 || len(vector[0].Metric) != 1 {
+			t.Fatalf("expected replicas to be merged into 1 series, got %v", vector)
+		}
+	})
+
+	t.Run("QueryRange", func(t *testing.T) {
+		v, _, err := c.QueryRange(ctx, "up", v1.Range{Start: time.Unix(0, 0), End: time.Unix(40, 0), Step: 10 * time.Second})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("GetValue", func(t *testing.T) {
+		v, _, err := c.GetValue(ctx, time.Unix(0, 0), time.Unix(40, 0), nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+}
@@ -0,0 +1,216 @@
+package promclient
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/jacksontj/promxy/pkg/promhttputil"
+	"github.com/jacksontj/promxy/pkg/sourcelabel"
+)
+
+// SourceLabelClient proxies a client and, if enabled in the request context, adds
+// the source label (with the value Source) to all series in the results
+type SourceLabelClient struct {
+	API
+	Source model.LabelValue
+}
+
+// Key returns a labelset used to determine other api clients that are the "same"
+func (c *SourceLabelClient) Key() model.LabelSet {
+	if apiLabels, ok := c.API.(APILabels); ok {
+		return apiLabels.Key()
+	}
+	return nil
+}
+
+func (c *SourceLabelClient) addLabel(v model.Value) {
+	switch vTyped := v.(type) {
+	case model.Vector:
+		for _, item := range vTyped {
+			item.Metric[sourcelabel.Label] = c.Source
+		}
+	case model.Matrix:
+		for _, item := range vTyped {
+			item.Metric[sourcelabel.Label] = c.Source
+		}
+	}
+}
+
+// Query performs a query for the given time.
+func (c *SourceLabelClient) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	v, w, err := c.API.Query(ctx, query, ts)
+	if err == nil && sourcelabel.Enabled(ctx) {
+		c.addLabel(v)
+	}
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (c *SourceLabelClient) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	v, w, err := c.API.QueryRange(ctx, query, r)
+	if err == nil && sourcelabel.Enabled(ctx) {
+		c.addLabel(v)
+	}
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (c *SourceLabelClient) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	v, w, err := c.API.Series(ctx, matches, startTime, endTime)
+	if err == nil && sourcelabel.Enabled(ctx) {
+		for _, lset := range v {
+			lset[sourcelabel.Label] = c.Source
+		}
+	}
+	return v, w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (c *SourceLabelClient) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	v, w, err := c.API.GetValue(ctx, start, end, matchers)
+	if err == nil && sourcelabel.Enabled(ctx) {
+		c.addLabel(v)
+	}
+	return v, w, err
+}
+
+// sourceKey identifies a point of a series (without the source label)
+type sourceKey struct {
+	fingerprint model.Fingerprint
+	t           model.Time
+}
+
+// sourcedValue is the value of a point and the source it came from
+type sourcedValue struct {
+	v      model.SampleValue
+	source model.LabelValue
+}
+
+// pointSources records the source of each point of the series in the values to be merged
+// so that the source label can be put back on the merged points
+type pointSources map[sourceKey][]sourcedValue
+
+// strip removes the source label from m (recording it for the point at t with value v)
+// and returns the fingerprint of m without it
+func (p pointSources) strip(m model.Metric) (model.Fingerprint, model.LabelValue, bool) {
+	source, ok := m[sourcelabel.Label]
+	delete(m, sourcelabel.Label)
+	return m.Fingerprint(), source, ok
+}
+
+func (p pointSources) add(fingerprint model.Fingerprint, t model.Time, v model.SampleValue, source model.LabelValue) {
+	k := sourceKey{fingerprint, t}
+	p[k] = append(p[k], sourcedValue{v, source})
+}
+
+// lookup returns the source of the point at t with value v. As merging may change values
+// (e.g. counter offsets) we fall back to the first source with a point at t
+func (p pointSources) lookup(fingerprint model.Fingerprint, t model.Time, v model.SampleValue) (model.LabelValue, bool) {
+	candidates := p[sourceKey{fingerprint, t}]
+	for _, c := range candidates {
+		if c.v == v {
+			return c.source, true
+		}
+	}
+	if len(candidates) > 0 {
+		return candidates[0].source, true
+	}
+	return "", false
+}
+
+// mergeValuesWithSources merges the values (which have the source label) without the
+// source label and then puts the label back on the merged points. Merged series that
+// have points from more than one source are split into a series per source
+func mergeValuesWithSources(strategy promhttputil.MergeStrategy, values ...model.Value) (model.Value, error) {
+	sources := make(pointSources)
+	for i, v := range values {
+		switch vTyped := v.(type) {
+		case model.Vector:
+			for _, item := range vTyped {
+				if fingerprint, source, ok := sources.strip(item.Metric); ok {
+					sources.add(fingerprint, item.Timestamp, item.Value, source)
+				}
+			}
+		case model.Matrix:
+			for _, item := range vTyped {
+				if fingerprint, source, ok := sources.strip(item.Metric); ok {
+					for _, p := range item.Values {
+						sources.add(fingerprint, p.Timestamp, p.Value, source)
+					}
+				}
+			}
+			values[i] = joinStreams(vTyped)
+		}
+	}
+
+	merged, err := promhttputil.MergeValuesWithStrategy(strategy, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mergedTyped := merged.(type) {
+	case model.Vector:
+		for _, item := range mergedTyped {
+			if source, ok := sources.lookup(item.Metric.Fingerprint(), item.Timestamp, item.Value); ok {
+				item.Metric = item.Metric.Clone()
+				item.Metric[sourcelabel.Label] = source
+			}
+		}
+	case model.Matrix:
+		split := make(model.Matrix, 0, len(mergedTyped))
+		for _, item := range mergedTyped {
+			split = append(split, splitBySource(sources, item)...)
+		}
+		merged = split
+	}
+	return merged, nil
+}
+
+// joinStreams joins streams of the same series within m (which were split by source
+// by a lower level) back together so they aren't merged as if they were replicas
+func joinStreams(m model.Matrix) model.Matrix {
+	streams := make(map[model.Fingerprint]*model.SampleStream, len(m))
+	ret := make(model.Matrix, 0, len(m))
+	for _, item := range m {
+		fingerprint := item.Metric.Fingerprint()
+		existing, ok := streams[fingerprint]
+		if !ok {
+			streams[fingerprint] = item
+			ret = append(ret, item)
+			continue
+		}
+		existing.Values = append(existing.Values, item.Values...)
+		sort.Slice(existing.Values, func(i, j int) bool {
+			return existing.Values[i].Timestamp < existing.Values[j].Timestamp
+		})
+	}
+	return ret
+}
+
+// splitBySource splits stream into a stream per source of its points (in the order
+// the sources first appear). Points with no known source are left without the label
+func splitBySource(sources pointSources, stream *model.SampleStream) model.Matrix {
+	fingerprint := stream.Metric.Fingerprint()
+	bySource := make(map[model.LabelValue]*model.SampleStream)
+	var ret model.Matrix
+	for _, p := range stream.Values {
+		source, _ := sources.lookup(fingerprint, p.Timestamp, p.Value)
+		s, ok := bySource[source]
+		if !ok {
+			metric := stream.Metric.Clone()
+			if source != "" {
+				metric[sourcelabel.Label] = source
+			}
+			s = &model.SampleStream{Metric: metric}
+			bySource[source] = s
+			ret = append(ret, s)
+		}
+		s.Values = append(s.Values, p)
+	}
+	return ret
+}
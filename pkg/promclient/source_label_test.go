@@ -0,0 +1,67 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+
+	"github.com/jacksontj/promxy/pkg/sourcelabel"
+)
+
+func TestSourceLabelClient(t *testing.T) {
+	newReplica := func(source model.LabelValue, v model.SampleValue, times ...model.Time) API {
+		return &SourceLabelClient{
+			API: &stubAPI{
+				queryRange: func() model.Value {
+					values := make([]model.SamplePair, len(times))
+					for i, t := range times {
+						values[i] = model.SamplePair{Timestamp: t, Value: v}
+					}
+					return model.Matrix{{Metric: model.Metric{"__name__": "up"}, Values: values}}
+				},
+			},
+			Source: source,
+		}
+	}
+	newMultiAPI := func() API {
+		return mustNewMultiAPI([]API{
+			newReplica("sg/a", 1, 10, 20, 50, 60),
+			newReplica("sg/b", 2, 10, 20, 30, 40),
+		}, model.Time(2), nil, 1)
+	}
+
+	// Without the flag the replicas are merged into a single series
+	v, _, err := newMultiAPI().QueryRange(context.TODO(), "up", v1.Range{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(v.(model.Matrix)) != 1 {
+		t.Fatalf("expected a single series, got %v", v)
+	}
+
+	// With it the merged series is split by where each point came from
+	expected := model.Matrix{
+		{Metric: model.Metric{"__name__": "up", sourcelabel.Label: "sg/a"}, Values: []model.SamplePair{{10, 1}, {20, 1}, {50, 1}, {60, 1}}},
+		{Metric: model.Metric{"__name__": "up", sourcelabel.Label: "sg/b"}, Values: []model.SamplePair{{30, 2}, {40, 2}}},
+	}
+	ctx := sourcelabel.NewContext(context.TODO())
+	v, _, err = newMultiAPI().QueryRange(ctx, "up", v1.Range{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+	}
+
+	// Another layer of merging keeps the split
+	v, _, err = mustNewMultiAPI([]API{newMultiAPI()}, model.Time(2), nil, 1).QueryRange(ctx, "up", v1.Range{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+	}
+}
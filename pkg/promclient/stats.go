@@ -0,0 +1,98 @@
+package promclient
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/jacksontj/promxy/pkg/querystats"
+)
+
+// StatsAPI records every call made through it into the querystats.Stats of the
+// call's context (if there is one)
+type StatsAPI struct {
+	API
+	ServerGroup string
+	Target      string
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (s *StatsAPI) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	stats := querystats.FromContext(ctx)
+	if stats == nil {
+		return s.API.LabelNames(ctx)
+	}
+
+	ctx, call := stats.StartCall(ctx, s.ServerGroup, s.Target, "label_names")
+	v, w, err := s.API.LabelNames(ctx)
+	call.FinishCount(0, 0, err)
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (s *StatsAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	stats := querystats.FromContext(ctx)
+	if stats == nil {
+		return s.API.LabelValues(ctx, label)
+	}
+
+	ctx, call := stats.StartCall(ctx, s.ServerGroup, s.Target, "label_values")
+	v, w, err := s.API.LabelValues(ctx, label)
+	call.FinishCount(0, 0, err)
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (s *StatsAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	stats := querystats.FromContext(ctx)
+	if stats == nil {
+		return s.API.Query(ctx, query, ts)
+	}
+
+	ctx, call := stats.StartCall(ctx, s.ServerGroup, s.Target, "query")
+	v, w, err := s.API.Query(ctx, query, ts)
+	call.Finish(v, err)
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (s *StatsAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	stats := querystats.FromContext(ctx)
+	if stats == nil {
+		return s.API.QueryRange(ctx, query, r)
+	}
+
+	ctx, call := stats.StartCall(ctx, s.ServerGroup, s.Target, "query_range")
+	v, w, err := s.API.QueryRange(ctx, query, r)
+	call.Finish(v, err)
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (s *StatsAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	stats := querystats.FromContext(ctx)
+	if stats == nil {
+		return s.API.Series(ctx, matches, startTime, endTime)
+	}
+
+	ctx, call := stats.StartCall(ctx, s.ServerGroup, s.Target, "series")
+	v, w, err := s.API.Series(ctx, matches, startTime, endTime)
+	call.FinishCount(len(v), 0, err)
+	return v, w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (s *StatsAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	stats := querystats.FromContext(ctx)
+	if stats == nil {
+		return s.API.GetValue(ctx, start, end, matchers)
+	}
+
+	ctx, call := stats.StartCall(ctx, s.ServerGroup, s.Target, "get_value")
+	v, w, err := s.API.GetValue(ctx, start, end, matchers)
+	call.Finish(v, err)
+	return v, w, err
+}
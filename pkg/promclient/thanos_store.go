@@ -0,0 +1,192 @@
+package promclient
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/jacksontj/promxy/pkg/storepb"
+)
+
+// ErrQueryNotSupported is returned by APIs which can only return raw data (and so
+// need PromQL to be evaluated in promxy)
+var ErrQueryNotSupported = fmt.Errorf("query is not supported by this API, it must be evaluated locally")
+
+// ThanosStoreAPI implements the API on top of a Thanos StoreAPI (gRPC) client. The StoreAPI
+// only returns raw data, so Query and QueryRange return ErrQueryNotSupported
+type ThanosStoreAPI struct {
+	Client storepb.StoreClient
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (t *ThanosStoreAPI) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	resp, err := t.Client.LabelNames(ctx, &storepb.LabelNamesRequest{
+		PartialResponseDisabled: true,
+		End:                     timestamp.FromTime(time.Now()),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(resp.Names)
+	return resp.Names, resp.Warnings, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (t *ThanosStoreAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	resp, err := t.Client.LabelValues(ctx, &storepb.LabelValuesRequest{
+		Label:                   label,
+		PartialResponseDisabled: true,
+		End:                     timestamp.FromTime(time.Now()),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	ret := make(model.LabelValues, len(resp.Values))
+	for i, v := range resp.Values {
+		ret[i] = model.LabelValue(v)
+	}
+	sort.Sort(ret)
+	return ret, resp.Warnings, nil
+}
+
+// Query performs a query for the given time.
+func (t *ThanosStoreAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	return nil, nil, ErrQueryNotSupported
+}
+
+// QueryRange performs a query for the given range.
+func (t *ThanosStoreAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	return nil, nil, ErrQueryNotSupported
+}
+
+// Series finds series by label matchers.
+func (t *ThanosStoreAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	var ret []model.LabelSet
+	var warnings v1.Warnings
+	for _, match := range matches {
+		matchers, err := parser.ParseMetricSelector(match)
+		if err != nil {
+			return nil, nil, err
+		}
+		series, w, err := t.series(ctx, startTime, endTime, matchers, true)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		lsets := make([]model.LabelSet, len(series))
+		for i, s := range series {
+			lsets[i] = storepb.LabelSetFromLabels(s.Labels)
+		}
+		ret = MergeLabelSets(ret, lsets)
+	}
+	return ret, warnings, nil
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (t *ThanosStoreAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	series, warnings, err := t.series(ctx, start, end, matchers, false)
+	if err != nil {
+		return nil, warnings, err
+	}
+
+	minT, maxT := timestamp.FromTime(start), timestamp.FromTime(end)
+	ret := make(model.Matrix, 0, len(series))
+	for _, s := range series {
+		values, err := decodeChunks(s.Chunks, minT, maxT)
+		if err != nil {
+			return nil, warnings, err
+		}
+		if len(values) == 0 {
+			continue
+		}
+		ret = append(ret, &model.SampleStream{
+			Metric: model.Metric(storepb.LabelSetFromLabels(s.Labels)),
+			Values: values,
+		})
+	}
+	return ret, warnings, nil
+}
+
+// series returns all the series (with their chunks unless skipChunks) that match matchers
+func (t *ThanosStoreAPI) series(ctx context.Context, start, end time.Time, matchers []*labels.Matcher, skipChunks bool) ([]*storepb.Series, v1.Warnings, error) {
+	storeMatchers, err := storepb.MatchersFromPromMatchers(matchers)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stream, err := t.Client.Series(ctx, &storepb.SeriesRequest{
+		MinTime:                 timestamp.FromTime(start),
+		MaxTime:                 timestamp.FromTime(end),
+		Matchers:                storeMatchers,
+		PartialResponseDisabled: true,
+		SkipChunks:              skipChunks,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var series []*storepb.Series
+	var warnings v1.Warnings
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return series, warnings, nil
+		}
+		if err != nil {
+			return nil, warnings, err
+		}
+		if resp.Warning != "" {
+			warnings = append(warnings, resp.Warning)
+		}
+		if resp.Series != nil {
+			series = append(series, resp.Series)
+		}
+	}
+}
+
+// decodeChunks returns the samples within [minT, maxT] of the raw chunks, in order and
+// without duplicates (which there may be if chunks overlap)
+func decodeChunks(chunks []*storepb.AggrChunk, minT, maxT int64) ([]model.SamplePair, error) {
+	var values []model.SamplePair
+	for _, c := range chunks {
+		if c.Raw == nil || c.MaxTime < minT || c.MinTime > maxT {
+			continue
+		}
+		if c.Raw.Type != storepb.Chunk_XOR {
+			return nil, fmt.Errorf("unsupported chunk encoding %v", c.Raw.Type)
+		}
+		chunk, err := chunkenc.FromData(chunkenc.EncXOR, c.Raw.Data)
+		if err != nil {
+			return nil, err
+		}
+		it := chunk.Iterator(nil)
+		for it.Next() {
+			ts, v := it.At()
+			if ts < minT || ts > maxT {
+				continue
+			}
+			values = append(values, model.SamplePair{Timestamp: model.Time(ts), Value: model.SampleValue(v)})
+		}
+		if err := it.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(values, func(i, j int) bool { return values[i].Timestamp < values[j].Timestamp })
+	deduped := values[:0]
+	for _, v := range values {
+		if len(deduped) == 0 || v.Timestamp != deduped[len(deduped)-1].Timestamp {
+			deduped = append(deduped, v)
+		}
+	}
+	return deduped, nil
+}
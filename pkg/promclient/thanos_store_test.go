@@ -0,0 +1,182 @@
+package promclient
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"google.golang.org/grpc"
+
+	"github.com/jacksontj/promxy/pkg/storepb"
+)
+
+// fakeStore is an in-memory StoreAPI
+type fakeStore struct {
+	series []fakeStoreSeries
+}
+
+type fakeStoreSeries struct {
+	labels labels.Labels
+	// chunks are the samples of each chunk of the series
+	chunks [][]model.SamplePair
+}
+
+func (f *fakeStore) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	return &storepb.InfoResponse{}, nil
+}
+
+func (f *fakeStore) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	matchers, err := storepb.PromMatchersFromMatchers(req.Matchers)
+	if err != nil {
+		return err
+	}
+
+SERIES_LOOP:
+	for _, s := range f.series {
+		for _, m := range matchers {
+			if !m.Matches(s.labels.Get(m.Name)) {
+				continue SERIES_LOOP
+			}
+		}
+
+		series := &storepb.Series{Labels: storepb.LabelsFromPromLabels(s.labels)}
+		if !req.SkipChunks {
+			for _, samples := range s.chunks {
+				c := chunkenc.NewXORChunk()
+				app, err := c.Appender()
+				if err != nil {
+					return err
+				}
+				for _, sample := range samples {
+					app.Append(int64(sample.Timestamp), float64(sample.Value))
+				}
+				series.Chunks = append(series.Chunks, &storepb.AggrChunk{
+					MinTime: int64(samples[0].Timestamp),
+					MaxTime: int64(samples[len(samples)-1].Timestamp),
+					Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+				})
+			}
+		}
+		if err := srv.Send(&storepb.SeriesResponse{Series: series}); err != nil {
+			return err
+		}
+	}
+	return srv.Send(&storepb.SeriesResponse{Warning: "fake warning"})
+}
+
+func (f *fakeStore) LabelNames(context.Context, *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	return &storepb.LabelNamesResponse{Names: []string{"job", "__name__"}}, nil
+}
+
+func (f *fakeStore) LabelValues(_ context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	values := make(map[string]struct{})
+	for _, s := range f.series {
+		if v := s.labels.Get(req.Label); v != "" {
+			values[v] = struct{}{}
+		}
+	}
+	resp := &storepb.LabelValuesResponse{}
+	for v := range values {
+		resp.Values = append(resp.Values, v)
+	}
+	return resp, nil
+}
+
+// startFakeStore serves store over gRPC and returns a ThanosStoreAPI for it
+func startFakeStore(t *testing.T, store *fakeStore) *ThanosStoreAPI {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	srv := grpc.NewServer(grpc.CustomCodec(storepb.Codec{}))
+	storepb.RegisterStoreServer(srv, store)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.ForceCodec(storepb.Codec{})))
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &ThanosStoreAPI{Client: storepb.NewStoreClient(conn)}
+}
+
+func TestThanosStoreAPI(t *testing.T) {
+	api := startFakeStore(t, &fakeStore{series: []fakeStoreSeries{
+		{
+			labels: labels.FromStrings("__name__", "up", "job", "a"),
+			// The chunks overlap at 20s
+			chunks: [][]model.SamplePair{
+				{{Timestamp: 0, Value: 1}, {Timestamp: 10000, Value: 2}, {Timestamp: 20000, Value: 3}},
+				{{Timestamp: 20000, Value: 3}, {Timestamp: 30000, Value: 4}},
+			},
+		},
+		{
+			labels: labels.FromStrings("__name__", "up", "job", "b"),
+			chunks: [][]model.SamplePair{
+				{{Timestamp: 0, Value: 5}},
+			},
+		},
+	}})
+	ctx := context.Background()
+
+	t.Run("GetValue", func(t *testing.T) {
+		matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")}
+		v, w, err := api.GetValue(ctx, time.Unix(5, 0), time.Unix(30, 0), matchers)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(w) != 1 || w[0] != "fake warning" {
+			t.Fatalf("Unexpected warnings: %v", w)
+		}
+		expected := model.Matrix{{
+			Metric: model.Metric{"__name__": "up", "job": "a"},
+			Values: []model.SamplePair{{Timestamp: 10000, Value: 2}, {Timestamp: 20000, Value: 3}, {Timestamp: 30000, Value: 4}},
+		}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("Series", func(t *testing.T) {
+		v, _, err := api.Series(ctx, []string{`up{job="b"}`}, time.Unix(0, 0), time.Unix(30, 0))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := []model.LabelSet{{"__name__": "up", "job": "b"}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("LabelNames", func(t *testing.T) {
+		v, _, err := api.LabelNames(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := []string{"__name__", "job"}; !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("LabelValues", func(t *testing.T) {
+		v, _, err := api.LabelValues(ctx, "job")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := (model.LabelValues{"a", "b"}); !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+	})
+
+	t.Run("Query", func(t *testing.T) {
+		if _, _, err := api.Query(ctx, "up", time.Unix(30, 0)); err != ErrQueryNotSupported {
+			t.Fatalf("Expected ErrQueryNotSupported, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,301 @@
+package promclient
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// Tier is an API with data for (at most) a time range
+type Tier struct {
+	API
+	// Range returns the time range that the tier has data for (a zero time is unbounded)
+	Range func() (time.Time, time.Time)
+	// Resolution is the resolution of the tier's data (zero for raw data)
+	Resolution time.Duration
+}
+
+// TieredAPI stitches together tiers of storage with overlapping time ranges (e.g. a
+// prometheus with 15d of data and a long-term store). Each part of a query's time range
+// is answered by exactly one tier: the most preferred tier with data for it (including the
+// data the query selects from before each time, see assignTiers). Range queries
+// prefer the coarsest tiers whose resolution is no larger than their step (so that long
+// ranges are answered from downsampled data) and don't use coarser tiers unless there are
+// no others (see tiersForQuery)
+type TieredAPI struct {
+	// Tiers are the tiers in order of preference
+	Tiers []Tier
+}
+
+// tiersForQuery returns the tiers (in order of preference) to use for a range query with step. Tiers
+// are only used if their resolution is no larger than the step and leaves at least two samples in
+// each of the query's range selectors (so that e.g. rate() has data). If every tier is coarser than
+// that the finest tiers are used
+func (t *TieredAPI) tiersForQuery(query string, step time.Duration) []Tier {
+	maxResolution := step
+	if expr, err := parser.ParseExpr(query); err == nil {
+		if smallest := SmallestRange(expr); smallest > 0 && smallest/2 < maxResolution {
+			maxResolution = smallest / 2
+		}
+	}
+
+	tiers := make([]Tier, 0, len(t.Tiers))
+	for _, tier := range t.Tiers {
+		if tier.Resolution <= maxResolution {
+			tiers = append(tiers, tier)
+		}
+	}
+	if len(tiers) == 0 && len(t.Tiers) > 0 {
+		finest := t.Tiers[0].Resolution
+		for _, tier := range t.Tiers {
+			if tier.Resolution < finest {
+				finest = tier.Resolution
+			}
+		}
+		for _, tier := range t.Tiers {
+			if tier.Resolution == finest {
+				tiers = append(tiers, tier)
+			}
+		}
+	}
+	sort.SliceStable(tiers, func(i, j int) bool { return tiers[i].Resolution > tiers[j].Resolution })
+	return tiers
+}
+
+// tierSegment is the part of a query's time range (from start to end inclusive) assigned to a tier
+type tierSegment struct {
+	tier       int
+	start, end time.Time
+}
+
+// assignTiers assigns the times from start to end (every step, from start) to tiers (the
+// segments' tier is the index in tiers). As queries select data from up to lookback before
+// each time, a time is only assigned to a tier whose data starts at least lookback before it
+// (so the cut-over between tiers moves later by lookback); times that no tier has all the data
+// for are assigned to the tiers with data at the time itself
+func assignTiers(tiers []Tier, start, end time.Time, step, lookback time.Duration) []tierSegment {
+	if end.Before(start) {
+		return nil
+	}
+	if step <= 0 {
+		step = time.Millisecond
+	}
+	n := int64(end.Sub(start) / step)
+
+	pads := []time.Duration{lookback}
+	if lookback > 0 {
+		pads = append(pads, 0)
+	}
+
+	// unassigned are the (inclusive) ranges of step indices not yet assigned to a tier
+	unassigned := [][2]int64{{0, n}}
+	var segments [][3]int64
+	for _, pad := range pads {
+		for i, tier := range tiers {
+			tierStart, tierEnd := tier.Range()
+			if !tierStart.IsZero() {
+				tierStart = tierStart.Add(pad)
+			}
+			lo, hi := int64(0), n
+			if !tierStart.IsZero() && tierStart.After(start) {
+				d := tierStart.Sub(start)
+				lo = int64(d / step)
+				if d%step != 0 {
+					lo++
+				}
+			}
+			if !tierEnd.IsZero() {
+				if tierEnd.Before(start) {
+					continue
+				}
+				if tierN := int64(tierEnd.Sub(start) / step); tierN < hi {
+					hi = tierN
+				}
+			}
+
+			remaining := make([][2]int64, 0, len(unassigned))
+			for _, u := range unassigned {
+				a, b := u[0], u[1]
+				if lo > a {
+					a = lo
+				}
+				if hi < b {
+					b = hi
+				}
+				if a > b {
+					remaining = append(remaining, u)
+					continue
+				}
+				segments = append(segments, [3]int64{int64(i), a, b})
+				if u[0] < a {
+					remaining = append(remaining, [2]int64{u[0], a - 1})
+				}
+				if b < u[1] {
+					remaining = append(remaining, [2]int64{b + 1, u[1]})
+				}
+			}
+			unassigned = remaining
+		}
+	}
+
+	sort.Slice(segments, func(i, j int) bool { return segments[i][1] < segments[j][1] })
+	ret := make([]tierSegment, 0, len(segments))
+	for i, s := range segments {
+		// Adjacent segments of the same tier (assigned in different passes) are joined
+		if i > 0 && segments[i-1][0] == s[0] && segments[i-1][2]+1 == s[1] {
+			ret[len(ret)-1].end = start.Add(time.Duration(s[2]) * step)
+			continue
+		}
+		ret = append(ret, tierSegment{
+			tier:  int(s[0]),
+			start: start.Add(time.Duration(s[1]) * step),
+			end:   start.Add(time.Duration(s[2]) * step),
+		})
+	}
+	return ret
+}
+
+// concatMatrices concatenates the series of matrices (which must be in time order)
+func concatMatrices(matrices []model.Matrix) model.Matrix {
+	var ret model.Matrix
+	streams := make(map[model.Fingerprint]*model.SampleStream)
+	for _, m := range matrices {
+		for _, stream := range m {
+			finger := stream.Metric.Fingerprint()
+			if existing, ok := streams[finger]; ok {
+				existing.Values = append(existing.Values, stream.Values...)
+				continue
+			}
+			s := &model.SampleStream{Metric: stream.Metric, Values: append([]model.SamplePair(nil), stream.Values...)}
+			streams[finger] = s
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (t *TieredAPI) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	names := make(map[string]struct{})
+	var warnings v1.Warnings
+	for _, tier := range t.Tiers {
+		v, w, err := tier.LabelNames(ctx)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		for _, name := range v {
+			names[name] = struct{}{}
+		}
+	}
+
+	ret := make([]string, 0, len(names))
+	for name := range names {
+		ret = append(ret, name)
+	}
+	sort.Strings(ret)
+	return ret, warnings, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (t *TieredAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	values := make(map[model.LabelValue]struct{})
+	var warnings v1.Warnings
+	for _, tier := range t.Tiers {
+		v, w, err := tier.LabelValues(ctx, label)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		for _, value := range v {
+			values[value] = struct{}{}
+		}
+	}
+
+	ret := make(model.LabelValues, 0, len(values))
+	for value := range values {
+		ret = append(ret, value)
+	}
+	sort.Sort(ret)
+	return ret, warnings, nil
+}
+
+// Query performs a query for the given time.
+func (t *TieredAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	segments := assignTiers(t.Tiers, ts, ts, time.Millisecond, QueryLookback(query, DefaultLookbackDelta))
+	if len(segments) == 0 {
+		return nil, nil, nil
+	}
+	return t.Tiers[segments[0].tier].Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (t *TieredAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	tiers := t.tiersForQuery(query, r.Step)
+	segments := assignTiers(tiers, r.Start, r.End, r.Step, QueryLookback(query, DefaultLookbackDelta))
+	if len(segments) == 1 {
+		return tiers[segments[0].tier].QueryRange(ctx, query, v1.Range{Start: segments[0].start, End: segments[0].end, Step: r.Step})
+	}
+
+	matrices := make([]model.Matrix, 0, len(segments))
+	var warnings v1.Warnings
+	for _, s := range segments {
+		v, w, err := tiers[s.tier].QueryRange(ctx, query, v1.Range{Start: s.start, End: s.end, Step: r.Step})
+		warnings = append(warnings, w...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		if m, ok := v.(model.Matrix); ok {
+			matrices = append(matrices, m)
+		}
+	}
+	if len(matrices) == 0 {
+		return nil, warnings, nil
+	}
+	return concatMatrices(matrices), warnings, nil
+}
+
+// Series finds series by label matchers.
+func (t *TieredAPI) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	var ret []model.LabelSet
+	var warnings v1.Warnings
+	for _, s := range assignTiers(t.Tiers, startTime, endTime, time.Millisecond, 0) {
+		v, w, err := t.Tiers[s.tier].Series(ctx, matches, s.start, s.end)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		ret = MergeLabelSets(ret, v)
+	}
+	return ret, warnings, nil
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (t *TieredAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	segments := assignTiers(t.Tiers, start, end, time.Millisecond, 0)
+	if len(segments) == 1 {
+		return t.Tiers[segments[0].tier].GetValue(ctx, segments[0].start, segments[0].end, matchers)
+	}
+
+	matrices := make([]model.Matrix, 0, len(segments))
+	var warnings v1.Warnings
+	for _, s := range segments {
+		v, w, err := t.Tiers[s.tier].GetValue(ctx, s.start, s.end, matchers)
+		warnings = append(warnings, w...)
+		if err != nil {
+			return nil, warnings, err
+		}
+		if m, ok := v.(model.Matrix); ok {
+			matrices = append(matrices, m)
+		}
+	}
+	if len(matrices) == 0 {
+		return nil, warnings, nil
+	}
+	return concatMatrices(matrices), warnings, nil
+}
@@ -0,0 +1,201 @@
+package promclient
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// tierAPI is an API which returns a series with a point at every step of the queried range
+// (with the tier's value) and records the ranges it was queried for
+type tierAPI struct {
+	stubAPI
+	value  model.SampleValue
+	ranges []v1.Range
+}
+
+func (a *tierAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	a.ranges = append(a.ranges, v1.Range{Start: ts, End: ts})
+	return model.Vector{{Metric: model.Metric{"__name__": "up"}, Value: a.value, Timestamp: model.TimeFromUnixNano(ts.UnixNano())}}, nil, nil
+}
+
+func (a *tierAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	a.ranges = append(a.ranges, r)
+	stream := &model.SampleStream{Metric: model.Metric{"__name__": "up"}}
+	for t := r.Start; !t.After(r.End); t = t.Add(r.Step) {
+		stream.Values = append(stream.Values, model.SamplePair{Timestamp: model.TimeFromUnixNano(t.UnixNano()), Value: a.value})
+	}
+	return model.Matrix{stream}, nil, nil
+}
+
+func TestTieredAPI(t *testing.T) {
+	cutover := time.Unix(1000, 0)
+	// The short-term tier is preferred, and has data from the lookback of `up` before the cutover
+	shortTerm := &tierAPI{value: 1}
+	longTerm := &tierAPI{value: 2}
+	api := &TieredAPI{Tiers: []Tier{
+		{API: shortTerm, Range: func() (time.Time, time.Time) { return cutover.Add(-DefaultLookbackDelta), time.Time{} }},
+		{API: longTerm, Range: func() (time.Time, time.Time) { return time.Time{}, time.Time{} }},
+	}}
+	ctx := context.Background()
+
+	// Each step comes from exactly one tier, with the cutover itself from the preferred tier
+	v, _, err := api.QueryRange(ctx, "up", v1.Range{Start: time.Unix(700, 0), End: time.Unix(1300, 0), Step: 150 * time.Second})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := model.Matrix{{
+		Metric: model.Metric{"__name__": "up"},
+		Values: []model.SamplePair{{Timestamp: 700000, Value: 2}, {Timestamp: 850000, Value: 2}, {Timestamp: 1000000, Value: 1}, {Timestamp: 1150000, Value: 1}, {Timestamp: 1300000, Value: 1}},
+	}}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+	}
+
+	// The steps of the sub-ranges are aligned with the query's
+	shortTerm.ranges, longTerm.ranges = nil, nil
+	if _, _, err := api.QueryRange(ctx, "up", v1.Range{Start: time.Unix(730, 0), End: time.Unix(1330, 0), Step: 200 * time.Second}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []v1.Range{{Start: time.Unix(730, 0), End: time.Unix(930, 0), Step: 200 * time.Second}}; !reflect.DeepEqual(longTerm.ranges, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, longTerm.ranges)
+	}
+	if expected := []v1.Range{{Start: time.Unix(1130, 0), End: time.Unix(1330, 0), Step: 200 * time.Second}}; !reflect.DeepEqual(shortTerm.ranges, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, shortTerm.ranges)
+	}
+
+	// Instant queries go to the preferred tier with data at that time
+	for ts, tier := range map[int64]*tierAPI{999: longTerm, 1000: shortTerm} {
+		tier.ranges = nil
+		if _, _, err := api.Query(ctx, "up", time.Unix(ts, 0)); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if len(tier.ranges) != 1 {
+			t.Fatalf("Expected query at %d to go to tier with value %v", ts, tier.value)
+		}
+	}
+}
+
+func TestTieredAPIAssign(t *testing.T) {
+	unbounded := func() (time.Time, time.Time) { return time.Time{}, time.Time{} }
+	api := &TieredAPI{Tiers: []Tier{
+		// The preferred tier only has data in the middle of the range
+		{API: &stubAPI{}, Range: func() (time.Time, time.Time) { return time.Unix(20, 0), time.Unix(30, 0) }},
+		{API: &stubAPI{}, Range: unbounded},
+	}}
+
+	segments := assignTiers(api.Tiers, time.Unix(0, 0), time.Unix(50, 0), 10*time.Second, 0)
+	expected := []tierSegment{
+		{tier: 1, start: time.Unix(0, 0), end: time.Unix(10, 0)},
+		{tier: 0, start: time.Unix(20, 0), end: time.Unix(30, 0)},
+		{tier: 1, start: time.Unix(40, 0), end: time.Unix(50, 0)},
+	}
+	if !reflect.DeepEqual(segments, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, segments)
+	}
+
+	// Times that no tier has data for aren't assigned
+	api.Tiers = api.Tiers[:1]
+	if segments := assignTiers(api.Tiers, time.Unix(40, 0), time.Unix(50, 0), time.Second, 0); len(segments) != 0 {
+		t.Fatalf("Expected no segments, got %v", segments)
+	}
+}
+
+func TestTieredAPILookback(t *testing.T) {
+	cutover := time.Unix(10000, 0)
+	shortTerm := &tierAPI{value: 1}
+	longTerm := &tierAPI{value: 2}
+	api := &TieredAPI{Tiers: []Tier{
+		{API: shortTerm, Range: func() (time.Time, time.Time) { return cutover, time.Time{} }},
+		{API: longTerm, Range: func() (time.Time, time.Time) { return time.Time{}, time.Time{} }},
+	}}
+	ctx := context.Background()
+
+	// The times within the query's lookback of the short-term tier's start are answered by the
+	// long-term tier (which has all the data selected for them)
+	r := v1.Range{Start: time.Unix(9000, 0), End: time.Unix(14400, 0), Step: 30 * time.Minute}
+	if _, _, err := api.QueryRange(ctx, "rate(up[1h])", r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []v1.Range{{Start: time.Unix(9000, 0), End: time.Unix(12600, 0), Step: r.Step}}; !reflect.DeepEqual(longTerm.ranges, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, longTerm.ranges)
+	}
+	if expected := []v1.Range{{Start: time.Unix(14400, 0), End: time.Unix(14400, 0), Step: r.Step}}; !reflect.DeepEqual(shortTerm.ranges, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, shortTerm.ranges)
+	}
+
+	// Without a tier with all the data the tier with data at the time itself is used
+	api.Tiers[1].Range = func() (time.Time, time.Time) { return time.Time{}, time.Unix(10800, 0) }
+	shortTerm.ranges, longTerm.ranges = nil, nil
+	if _, _, err := api.QueryRange(ctx, "rate(up[1h])", r); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := []v1.Range{{Start: time.Unix(9000, 0), End: time.Unix(10800, 0), Step: r.Step}}; !reflect.DeepEqual(longTerm.ranges, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, longTerm.ranges)
+	}
+	if expected := []v1.Range{{Start: time.Unix(12600, 0), End: time.Unix(14400, 0), Step: r.Step}}; !reflect.DeepEqual(shortTerm.ranges, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, shortTerm.ranges)
+	}
+}
+
+func TestTieredAPIResolution(t *testing.T) {
+	unbounded := func() (time.Time, time.Time) { return time.Time{}, time.Time{} }
+	raw := &tierAPI{value: 0}
+	fiveMinute := &tierAPI{value: 5}
+	// The 1h downsampled data is only available up to the last downsampling run
+	oneHour := &tierAPI{value: 60}
+	api := &TieredAPI{Tiers: []Tier{
+		{API: raw, Range: unbounded},
+		{API: fiveMinute, Range: unbounded, Resolution: 5 * time.Minute},
+		{API: oneHour, Range: func() (time.Time, time.Time) { return time.Time{}, time.Unix(7200, 0) }, Resolution: time.Hour},
+	}}
+	ctx := context.Background()
+
+	// The coarsest resolution no larger than the step is used, falling back to finer ones
+	v, _, err := api.QueryRange(ctx, "up", v1.Range{Start: time.Unix(0, 0), End: time.Unix(10800, 0), Step: time.Hour})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := model.Matrix{{
+		Metric: model.Metric{"__name__": "up"},
+		Values: []model.SamplePair{{Timestamp: 0, Value: 60}, {Timestamp: 3600000, Value: 60}, {Timestamp: 7200000, Value: 60}, {Timestamp: 10800000, Value: 5}},
+	}}
+	if !reflect.DeepEqual(v, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+	}
+
+	// Resolutions coarser than the step aren't used
+	if _, _, err := api.QueryRange(ctx, "up", v1.Range{Start: time.Unix(0, 0), End: time.Unix(600, 0), Step: time.Minute}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(raw.ranges) != 1 || len(fiveMinute.ranges) != 1 || len(oneHour.ranges) != 1 {
+		t.Fatalf("Expected the 1m step query to only use raw data")
+	}
+
+	// Nor are resolutions that leave fewer than two samples in a range selector
+	raw.ranges, fiveMinute.ranges, oneHour.ranges = nil, nil, nil
+	if _, _, err := api.QueryRange(ctx, "rate(up[15m])", v1.Range{Start: time.Unix(0, 0), End: time.Unix(10800, 0), Step: time.Hour}); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(raw.ranges) != 0 || len(fiveMinute.ranges) != 1 || len(oneHour.ranges) != 0 {
+		t.Fatalf("Expected the query with a 15m range to only use the 5m resolution")
+	}
+
+	// Without a fine enough tier the finest one is used
+	api.Tiers = api.Tiers[1:]
+	fiveMinute.ranges, oneHour.ranges = nil, nil
+	v, _, err = api.QueryRange(ctx, "up", v1.Range{Start: time.Unix(0, 0), End: time.Unix(120, 0), Step: time.Minute})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(fiveMinute.ranges) != 1 || len(oneHour.ranges) != 0 {
+		t.Fatalf("Expected the 1m step query to use the 5m resolution")
+	}
+	if m, ok := v.(model.Matrix); !ok || len(m) != 1 || len(m[0].Values) != 3 {
+		t.Fatalf("Expected data from the 5m resolution, got %v", v)
+	}
+}
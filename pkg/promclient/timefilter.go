@@ -166,3 +166,42 @@ func (tf *RelativeTimeFilter) GetValue(ctx context.Context, start, end time.Time
 
 	return tf.API.GetValue(ctx, start, end, matchers)
 }
+
+// DynamicTimeFilter will filter queries out (return nil,nil) for all queries outside the time range
+// returned by Range, which may change over time (e.g. as it is discovered from the downstream).
+// As queries select data from before their evaluation time, the end of the range is padded by the
+// lookback of Query and QueryRange's query (so that a host which stopped still answers them)
+type DynamicTimeFilter struct {
+	API
+	// Range returns the time range to allow queries in (a zero time is unbounded)
+	Range func() (time.Time, time.Time)
+}
+
+// filter returns the AbsoluteTimeFilter of the current range, with its end padded by pad
+func (tf *DynamicTimeFilter) filter(pad time.Duration) *AbsoluteTimeFilter {
+	start, end := tf.Range()
+	if !end.IsZero() {
+		end = end.Add(pad)
+	}
+	return &AbsoluteTimeFilter{API: tf.API, Start: start, End: end}
+}
+
+// Query performs a query for the given time.
+func (tf *DynamicTimeFilter) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	return tf.filter(QueryLookback(query, DefaultLookbackDelta)).Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (tf *DynamicTimeFilter) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	return tf.filter(QueryLookback(query, DefaultLookbackDelta)).QueryRange(ctx, query, r)
+}
+
+// Series finds series by label matchers.
+func (tf *DynamicTimeFilter) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	return tf.filter(0).Series(ctx, matches, startTime, endTime)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (tf *DynamicTimeFilter) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	return tf.filter(0).GetValue(ctx, start, end, matchers)
+}
@@ -145,3 +145,58 @@ func TestRelativeTimeFilter(t *testing.T) {
 	})
 
 }
+
+func TestDynamicTimeFilter(t *testing.T) {
+	now := time.Now()
+
+	start := now.Add(time.Hour * -2)
+	end := now.Add(time.Hour * -1)
+
+	api := &DynamicTimeFilter{
+		API:   &recoverAPI{nil},
+		Range: func() (time.Time, time.Time) { return start, end },
+	}
+	timefilterTest(t, api, timeFilterTestCase{
+		validTimes: []time.Time{
+			start,
+			end,
+		},
+		invalidTimes: []time.Time{
+			now,
+			start.Add(time.Minute * -1),
+		},
+		validRanges: []v1.Range{
+			{Start: start.Add(time.Hour * -1), End: end},
+		},
+		invalidRanges: []v1.Range{
+			{Start: now, End: now},
+		},
+	})
+
+	// Queries select data from before their evaluation time, so the end is padded by their lookback
+	for _, test := range []struct {
+		query string
+		ts    time.Time
+		valid bool
+	}{
+		{query: `up`, ts: end.Add(3 * time.Minute), valid: true},
+		{query: `up`, ts: end.Add(10 * time.Minute)},
+		{query: `rate(up[1h])`, ts: end.Add(30 * time.Minute), valid: true},
+	} {
+		_, _, err := api.Query(context.TODO(), test.query, test.ts)
+		if valid := err != nil; valid != test.valid {
+			t.Fatalf("mismatch in call to API for %s at end+%v expected=%v actual=%v", test.query, test.ts.Sub(end), test.valid, valid)
+		}
+		_, _, err = api.QueryRange(context.TODO(), test.query, v1.Range{Start: test.ts, End: test.ts.Add(time.Hour), Step: time.Minute})
+		if valid := err != nil; valid != test.valid {
+			t.Fatalf("mismatch in call to API for range %s at end+%v expected=%v actual=%v", test.query, test.ts.Sub(end), test.valid, valid)
+		}
+	}
+
+	// Until the range is known, nothing is filtered
+	api.Range = func() (time.Time, time.Time) { return time.Time{}, time.Time{} }
+	timefilterTest(t, api, timeFilterTestCase{
+		validTimes:  []time.Time{now, start.Add(time.Hour * -10)},
+		validRanges: []v1.Range{{Start: now, End: now}},
+	})
+}
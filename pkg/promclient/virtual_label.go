@@ -0,0 +1,118 @@
+package promclient
+
+import (
+	"context"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+// VirtualLabelClient proxies a client and filters calls on the given labels. Unlike
+// AddLabelClient the labels only exist to select this client: they are stripped from
+// the calls sent downstream and are not added to the results
+type VirtualLabelClient struct {
+	API
+	Labels model.LabelSet
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (c *VirtualLabelClient) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	l, w, err := c.API.LabelNames(ctx)
+	if err != nil {
+		return nil, w, err
+	}
+
+	for k := range c.Labels {
+		found := false
+		for _, labelName := range l {
+			if labelName == string(k) {
+				found = true
+			}
+		}
+		if !found {
+			l = append(l, string(k))
+		}
+	}
+
+	return l, w, nil
+}
+
+// LabelValues performs a query for the values of the given label.
+func (c *VirtualLabelClient) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	// The label only exists here, so there is no reason to ask downstream
+	if value, ok := c.Labels[model.LabelName(label)]; ok {
+		return model.LabelValues{value}, nil, nil
+	}
+	return c.API.LabelValues(ctx, label)
+}
+
+// filterQuery strips our labels from the query, returning whether the query matched
+func (c *VirtualLabelClient) filterQuery(ctx context.Context, query string) (string, bool, error) {
+	e, err := parser.ParseExpr(query)
+	if err != nil {
+		return "", false, err
+	}
+
+	filterVisitor := &LabelFilterVisitor{c.Labels, true}
+	if _, err := parser.Walk(ctx, filterVisitor, &parser.EvalStmt{Expr: e}, e, nil, nil); err != nil {
+		return "", false, err
+	}
+	return e.String(), filterVisitor.filterMatch, nil
+}
+
+// Query performs a query for the given time.
+func (c *VirtualLabelClient) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	query, ok, err := c.filterQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+	return c.API.Query(ctx, query, ts)
+}
+
+// QueryRange performs a query for the given range.
+func (c *VirtualLabelClient) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	query, ok, err := c.filterQuery(ctx, query)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+	return c.API.QueryRange(ctx, query, r)
+}
+
+// Series finds series by label matchers.
+func (c *VirtualLabelClient) Series(ctx context.Context, matches []string, startTime time.Time, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	filteredMatches := make([]string, 0, len(matches))
+	for _, matcher := range matches {
+		filtered, ok, err := c.filterQuery(ctx, matcher)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			filteredMatches = append(filteredMatches, filtered)
+		}
+	}
+
+	// If no matchers remain, then we don't have anything -- so skip
+	if len(filteredMatches) == 0 {
+		return nil, nil, nil
+	}
+
+	return c.API.Series(ctx, filteredMatches, startTime, endTime)
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (c *VirtualLabelClient) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	filteredMatchers, ok := FilterMatchers(c.Labels, matchers)
+	if !ok {
+		return nil, nil, nil
+	}
+	return c.API.GetValue(ctx, start, end, filteredMatchers)
+}
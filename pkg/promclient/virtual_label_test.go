@@ -0,0 +1,80 @@
+package promclient
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+)
+
+// queryRecorderAPI records the queries sent to it
+type queryRecorderAPI struct {
+	API
+	queries []string
+}
+
+func (q *queryRecorderAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	q.queries = append(q.queries, query)
+	return model.Vector{}, nil, nil
+}
+
+func TestVirtualLabelClient(t *testing.T) {
+	tests := []struct {
+		query      string
+		downstream string
+	}{
+		{
+			query:      `up{__servergroup__="a"}`,
+			downstream: `up`,
+		},
+		{
+			query:      `sum(up{__servergroup__=~"a|b",job="foo"})`,
+			downstream: `sum(up{job="foo"})`,
+		},
+		{
+			query: `up{__servergroup__="b"}`,
+		},
+		{
+			query: `up{__servergroup__!="a"}`,
+		},
+		{
+			query:      `up{job="foo"}`,
+			downstream: `up{job="foo"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.query, func(t *testing.T) {
+			recorder := &queryRecorderAPI{}
+			c := &VirtualLabelClient{API: recorder, Labels: model.LabelSet{"__servergroup__": "a"}}
+			if _, _, err := c.Query(context.TODO(), test.query, time.Now()); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+
+			if test.downstream == "" {
+				if len(recorder.queries) != 0 {
+					t.Fatalf("expected no downstream query, got %v", recorder.queries)
+				}
+				return
+			}
+			if len(recorder.queries) != 1 || recorder.queries[0] != test.downstream {
+				t.Fatalf("mismatch in downstream query expected=%s actual=%v", test.downstream, recorder.queries)
+			}
+		})
+	}
+
+	c := &VirtualLabelClient{
+		API:    &stubAPI{labelNames: func() []string { return []string{"job"} }},
+		Labels: model.LabelSet{"__servergroup__": "a"},
+	}
+	names, _, err := c.LabelNames(context.TODO())
+	if err != nil || len(names) != 2 || names[1] != "__servergroup__" {
+		t.Fatalf("mismatch in label names: %v %v", names, err)
+	}
+	values, _, err := c.LabelValues(context.TODO(), "__servergroup__")
+	if err != nil || len(values) != 1 || values[0] != "a" {
+		t.Fatalf("mismatch in label values: %v %v", values, err)
+	}
+}
@@ -0,0 +1,323 @@
+package promhttputil
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/prometheus/common/model"
+)
+
+// MergeStrategy defines how the same series returned from multiple replicas is
+// deduplicated into a single series
+type MergeStrategy interface {
+	// MergeSamples merges the samples (one per replica) of a single series of an instant vector
+	MergeSamples(samples []*model.Sample) *model.Sample
+	// MergeSampleStreams merges the streams (one per replica) of a single series of a matrix
+	MergeSampleStreams(streams []*model.SampleStream) (*model.SampleStream, error)
+}
+
+// GroupMergeStrategy is a MergeStrategy that needs to see all the series from the
+// replicas at once (instead of each series on its own)
+type GroupMergeStrategy interface {
+	MergeStrategy
+	// MergeVectors merges the vectors (one per replica)
+	MergeVectors(vectors []model.Vector) model.Vector
+	// MergeMatrices merges the matrices (one per replica)
+	MergeMatrices(matrices []model.Matrix) (model.Matrix, error)
+}
+
+// firstNonZeroSample returns the first sample, with its value filled in by the
+// other samples if it has none (which seems reasonable)
+func firstNonZeroSample(samples []*model.Sample) *model.Sample {
+	s := samples[0]
+	for _, item := range samples[1:] {
+		if s.Value != model.SampleValue(0) {
+			break
+		}
+		s.Value = item.Value
+	}
+	return s
+}
+
+// NewGapFillStrategy returns a MergeStrategy which uses the stream with the most points
+// and fills any gaps in it from the other replicas (see MergeSampleStream)
+func NewGapFillStrategy(antiAffinityBuffer model.Time) MergeStrategy {
+	return &gapFillStrategy{antiAffinityBuffer: antiAffinityBuffer}
+}
+
+// NewCounterGapFillStrategy returns a gap fill MergeStrategy which offsets the points filled
+// into counters so that the merged counter doesn't reset (see MergeCounterSampleStream)
+func NewCounterGapFillStrategy(antiAffinityBuffer model.Time) MergeStrategy {
+	return &gapFillStrategy{antiAffinityBuffer: antiAffinityBuffer, counterAware: true}
+}
+
+// NewAutoGapFillStrategy returns a gap fill MergeStrategy which derives the antiAffinityBuffer for
+// each series from the median spacing of its samples (so that it matches the series' scrape interval),
+// using floor as the minimum buffer
+func NewAutoGapFillStrategy(floor model.Time, counterAware bool) MergeStrategy {
+	return &gapFillStrategy{antiAffinityBuffer: floor, counterAware: counterAware, auto: true}
+}
+
+type gapFillStrategy struct {
+	antiAffinityBuffer model.Time
+	counterAware       bool
+	auto               bool
+}
+
+func (g *gapFillStrategy) MergeSamples(samples []*model.Sample) *model.Sample {
+	return firstNonZeroSample(samples)
+}
+
+func (g *gapFillStrategy) MergeSampleStreams(streams []*model.SampleStream) (*model.SampleStream, error) {
+	ret, _, err := g.mergeSampleStreamsCountingFills(streams)
+	return ret, err
+}
+
+func (g *gapFillStrategy) mergeSampleStreamsCountingFills(streams []*model.SampleStream) (*model.SampleStream, int, error) {
+	antiAffinityBuffer := g.antiAffinityBuffer
+	if g.auto {
+		if spacing := medianSpacing(streams); spacing > antiAffinityBuffer {
+			antiAffinityBuffer = spacing
+		}
+	}
+
+	ret := streams[0]
+	filled := 0
+	for _, stream := range streams[1:] {
+		counter := g.counterAware && IsCounter(ret.Metric)
+		merged, n, err := mergeSampleStream(antiAffinityBuffer, ret, stream, counter)
+		if err != nil {
+			return nil, 0, err
+		}
+		ret = merged
+		filled += n
+	}
+	return ret, filled, nil
+}
+
+// medianSpacing returns the median time between consecutive samples of the streams
+// (or 0 if none of them have more than one sample)
+func medianSpacing(streams []*model.SampleStream) model.Time {
+	var spacings []model.Time
+	for _, stream := range streams {
+		for i := 1; i < len(stream.Values); i++ {
+			spacings = append(spacings, stream.Values[i].Timestamp-stream.Values[i-1].Timestamp)
+		}
+	}
+	if len(spacings) == 0 {
+		return 0
+	}
+	sort.Slice(spacings, func(i, j int) bool { return spacings[i] < spacings[j] })
+	return spacings[len(spacings)/2]
+}
+
+// penaltyInitial is the penalty used before the sample interval of a replica is known. Since
+// timestamps are in ms and scrape intervals are typically multiple seconds this is 5s
+const penaltyInitial = model.Time(5000)
+
+// NewPenaltyStrategy returns a MergeStrategy which implements Thanos' penalty based deduplication.
+// Samples are taken from one replica for as long as it has data; when switching to another
+// replica, the replica that wasn't picked is penalized by twice the last sample interval so that
+// we don't increase the sample frequency or pick up samples skewed by clock drift
+func NewPenaltyStrategy() MergeStrategy {
+	return &penaltyStrategy{}
+}
+
+type penaltyStrategy struct{}
+
+func (p *penaltyStrategy) MergeSamples(samples []*model.Sample) *model.Sample {
+	return firstNonZeroSample(samples)
+}
+
+func (p *penaltyStrategy) MergeSampleStreams(streams []*model.SampleStream) (*model.SampleStream, error) {
+	ret := streams[0]
+	for _, stream := range streams[1:] {
+		if ret.Metric.Fingerprint() != stream.Metric.Fingerprint() {
+			return nil, fmt.Errorf("cannot merge mismatch fingerprints")
+		}
+		ret = &model.SampleStream{
+			Metric: ret.Metric,
+			Values: penaltyMerge(ret.Values, stream.Values),
+		}
+	}
+	return ret, nil
+}
+
+// penaltyMerge dedupes the samples of 2 replicas with penalties
+func penaltyMerge(a, b []model.SamplePair) []model.SamplePair {
+	ret := make([]model.SamplePair, 0, len(a))
+
+	var aOffset, bOffset int
+	var penA, penB model.Time
+	lastT := model.Time(math.MinInt64)
+	seek := func(values []model.SamplePair, offset int, t model.Time) int {
+		for offset < len(values) && values[offset].Timestamp < t {
+			offset++
+		}
+		return offset
+	}
+
+	for {
+		// Advance both to at least the next timestamp plus their penalty
+		if lastT != math.MinInt64 {
+			aOffset = seek(a, aOffset, lastT+1+penA)
+			bOffset = seek(b, bOffset, lastT+1+penB)
+		}
+		aok, bok := aOffset < len(a), bOffset < len(b)
+
+		switch {
+		case !aok && !bok:
+			return ret
+		case !aok:
+			penB = 0
+			lastT = b[bOffset].Timestamp
+			ret = append(ret, b[bOffset])
+		case !bok:
+			penA = 0
+			lastT = a[aOffset].Timestamp
+			ret = append(ret, a[aOffset])
+		// Both have data, pick the earlier one and penalize the other
+		case a[aOffset].Timestamp <= b[bOffset].Timestamp:
+			if lastT != math.MinInt64 {
+				penB = 2 * (a[aOffset].Timestamp - lastT)
+			} else {
+				penB = penaltyInitial
+			}
+			penA = 0
+			lastT = a[aOffset].Timestamp
+			ret = append(ret, a[aOffset])
+		default:
+			if lastT != math.MinInt64 {
+				penA = 2 * (b[bOffset].Timestamp - lastT)
+			} else {
+				penA = penaltyInitial
+			}
+			penB = 0
+			lastT = b[bOffset].Timestamp
+			ret = append(ret, b[bOffset])
+		}
+	}
+}
+
+// NewStickyStrategy returns a MergeStrategy which uses a single replica (the one with
+// the most points) for each series over the whole range. This avoids interleaving
+// samples from replicas that may disagree at the cost of not filling any gaps
+func NewStickyStrategy() MergeStrategy {
+	return &stickyStrategy{}
+}
+
+type stickyStrategy struct{}
+
+func (s *stickyStrategy) MergeSamples(samples []*model.Sample) *model.Sample {
+	return firstNonZeroSample(samples)
+}
+
+func (s *stickyStrategy) MergeSampleStreams(streams []*model.SampleStream) (*model.SampleStream, error) {
+	ret := streams[0]
+	for _, stream := range streams[1:] {
+		if ret.Metric.Fingerprint() != stream.Metric.Fingerprint() {
+			return nil, fmt.Errorf("cannot merge mismatch fingerprints")
+		}
+		if len(stream.Values) > len(ret.Values) {
+			ret = stream
+		}
+	}
+	return ret, nil
+}
+
+// AggregateFunc is the function used to aggregate replicas' values in the aggregate strategy
+type AggregateFunc string
+
+const (
+	// AggregateMax uses the largest value of all replicas
+	AggregateMax AggregateFunc = "max"
+	// AggregateMin uses the smallest value of all replicas
+	AggregateMin AggregateFunc = "min"
+	// AggregateAvg uses the average value of all replicas
+	AggregateAvg AggregateFunc = "avg"
+)
+
+// NewAggregateStrategy returns a MergeStrategy which aggregates the values of all replicas
+// at the same timestamp with f. NaN values are ignored unless all values are NaN
+func NewAggregateStrategy(f AggregateFunc) (MergeStrategy, error) {
+	switch f {
+	case AggregateMax, AggregateMin, AggregateAvg:
+	default:
+		return nil, fmt.Errorf("unknown aggregate function %q", f)
+	}
+	return &aggregateStrategy{f}, nil
+}
+
+type aggregateStrategy struct {
+	f AggregateFunc
+}
+
+// aggregate reduces values (of which there must be at least 1)
+func (a *aggregateStrategy) aggregate(values []model.SampleValue) model.SampleValue {
+	var ret, sum float64
+	var count int
+	for _, v := range values {
+		f := float64(v)
+		if math.IsNaN(f) {
+			continue
+		}
+		if count == 0 {
+			ret = f
+		}
+		switch a.f {
+		case AggregateMax:
+			ret = math.Max(ret, f)
+		case AggregateMin:
+			ret = math.Min(ret, f)
+		}
+		sum += f
+		count++
+	}
+	if count == 0 {
+		return values[0]
+	}
+	if a.f == AggregateAvg {
+		return model.SampleValue(sum / float64(count))
+	}
+	return model.SampleValue(ret)
+}
+
+func (a *aggregateStrategy) MergeSamples(samples []*model.Sample) *model.Sample {
+	values := make([]model.SampleValue, len(samples))
+	for i, s := range samples {
+		values[i] = s.Value
+	}
+	return &model.Sample{
+		Metric:    samples[0].Metric,
+		Value:     a.aggregate(values),
+		Timestamp: samples[0].Timestamp,
+	}
+}
+
+func (a *aggregateStrategy) MergeSampleStreams(streams []*model.SampleStream) (*model.SampleStream, error) {
+	if len(streams) == 1 {
+		return streams[0], nil
+	}
+
+	values := make(map[model.Time][]model.SampleValue, len(streams[0].Values))
+	for _, stream := range streams {
+		if streams[0].Metric.Fingerprint() != stream.Metric.Fingerprint() {
+			return nil, fmt.Errorf("cannot merge mismatch fingerprints")
+		}
+		for _, v := range stream.Values {
+			values[v.Timestamp] = append(values[v.Timestamp], v.Value)
+		}
+	}
+
+	newValues := make([]model.SamplePair, 0, len(values))
+	for t, v := range values {
+		newValues = append(newValues, model.SamplePair{Timestamp: t, Value: a.aggregate(v)})
+	}
+	sort.Slice(newValues, func(i, j int) bool { return newValues[i].Timestamp < newValues[j].Timestamp })
+
+	return &model.SampleStream{
+		Metric: streams[0].Metric,
+		Values: newValues,
+	}, nil
+}
@@ -0,0 +1,179 @@
+package promhttputil
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+var dedupTestMetric = model.Metric(model.LabelSet{model.MetricNameLabel: model.LabelValue("hosta")})
+
+// testStream returns a stream of dedupTestMetric with a value of v at each of the given times
+func testStream(v model.SampleValue, times ...model.Time) *model.SampleStream {
+	values := make([]model.SamplePair, len(times))
+	for i, t := range times {
+		values[i] = model.SamplePair{Timestamp: t, Value: v}
+	}
+	return &model.SampleStream{Metric: dedupTestMetric, Values: values}
+}
+
+func TestMergeStrategies(t *testing.T) {
+	mustAggregate := func(f AggregateFunc) MergeStrategy {
+		s, err := NewAggregateStrategy(f)
+		if err != nil {
+			t.Fatalf("Error creating aggregate strategy: %v", err)
+		}
+		return s
+	}
+
+	tests := []struct {
+		name     string
+		strategy MergeStrategy
+		in       []*model.SampleStream
+		out      *model.SampleStream
+	}{
+		{
+			name:     "gap_fill",
+			strategy: NewGapFillStrategy(model.Time(2)),
+			in: []*model.SampleStream{
+				testStream(1, 10, 20, 50, 60),
+				testStream(2, 10, 20, 30, 40),
+				testStream(3, 10, 20, 30),
+			},
+			out: &model.SampleStream{
+				Metric: dedupTestMetric,
+				Values: []model.SamplePair{{10, 1}, {20, 1}, {30, 2}, {40, 2}, {50, 1}, {60, 1}},
+			},
+		},
+		{
+			// The samples are 60s apart so only the gap is filled, not the spaces between samples
+			name:     "gap_fill auto",
+			strategy: NewAutoGapFillStrategy(model.Time(2), false),
+			in: []*model.SampleStream{
+				testStream(1, 0, 60000, 120000, 180000, 240000, 480000, 540000, 600000, 660000),
+				testStream(2, 30000, 90000, 150000, 210000, 270000, 330000, 390000, 450000),
+			},
+			out: &model.SampleStream{
+				Metric: dedupTestMetric,
+				Values: []model.SamplePair{
+					{0, 1}, {60000, 1}, {120000, 1}, {180000, 1}, {240000, 1},
+					{330000, 2}, {390000, 2},
+					{480000, 1}, {540000, 1}, {600000, 1}, {660000, 1},
+				},
+			},
+		},
+		{
+			name:     "penalty no gaps",
+			strategy: NewPenaltyStrategy(),
+			in: []*model.SampleStream{
+				testStream(1, 0, 10000, 20000, 30000, 40000),
+				testStream(2, 1000, 11000, 21000, 31000, 41000),
+			},
+			out: testStream(1, 0, 10000, 20000, 30000, 40000),
+		},
+		{
+			// Once b is picked a is penalized so we don't interleave them again
+			name:     "penalty gap",
+			strategy: NewPenaltyStrategy(),
+			in: []*model.SampleStream{
+				testStream(1, 0, 10000, 20000, 50000, 60000),
+				testStream(2, 1000, 11000, 21000, 31000, 41000, 51000, 61000),
+			},
+			out: &model.SampleStream{
+				Metric: dedupTestMetric,
+				Values: []model.SamplePair{{0, 1}, {10000, 1}, {20000, 1}, {41000, 2}, {51000, 2}, {61000, 2}},
+			},
+		},
+		{
+			name:     "sticky",
+			strategy: NewStickyStrategy(),
+			in: []*model.SampleStream{
+				testStream(1, 10, 20, 50, 60),
+				testStream(2, 10, 20, 30, 40, 50),
+				testStream(3, 10, 20, 30),
+			},
+			out: testStream(2, 10, 20, 30, 40, 50),
+		},
+		{
+			name:     "aggregate max",
+			strategy: mustAggregate(AggregateMax),
+			in: []*model.SampleStream{
+				testStream(1, 10, 20),
+				testStream(3, 10, 30),
+				testStream(model.SampleValue(math.NaN()), 10, 20),
+			},
+			out: &model.SampleStream{
+				Metric: dedupTestMetric,
+				Values: []model.SamplePair{{10, 3}, {20, 1}, {30, 3}},
+			},
+		},
+		{
+			name:     "aggregate min",
+			strategy: mustAggregate(AggregateMin),
+			in: []*model.SampleStream{
+				testStream(1, 10, 20),
+				testStream(3, 10, 30),
+			},
+			out: &model.SampleStream{
+				Metric: dedupTestMetric,
+				Values: []model.SamplePair{{10, 1}, {20, 1}, {30, 3}},
+			},
+		},
+		{
+			name:     "aggregate avg",
+			strategy: mustAggregate(AggregateAvg),
+			in: []*model.SampleStream{
+				testStream(1, 10, 20),
+				testStream(3, 10, 30),
+				testStream(5, 10),
+			},
+			out: &model.SampleStream{
+				Metric: dedupTestMetric,
+				Values: []model.SamplePair{{10, 3}, {20, 1}, {30, 3}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			values := make([]model.Value, len(test.in))
+			for i, stream := range test.in {
+				values[i] = model.Matrix{stream}
+			}
+			result, err := MergeValuesWithStrategy(test.strategy, values...)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			expected := model.Matrix{test.out}
+			if !reflect.DeepEqual(result, expected) {
+				t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, result)
+			}
+		})
+	}
+}
+
+func TestAggregateStrategyVector(t *testing.T) {
+	s, err := NewAggregateStrategy(AggregateAvg)
+	if err != nil {
+		t.Fatalf("Error creating aggregate strategy: %v", err)
+	}
+
+	result, err := MergeValuesWithStrategy(s,
+		model.Vector{{Metric: dedupTestMetric, Value: 1, Timestamp: 10}},
+		model.Vector{{Metric: dedupTestMetric, Value: 2, Timestamp: 10}},
+		model.Vector{{Metric: dedupTestMetric, Value: 6, Timestamp: 10}},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := model.Vector{{Metric: dedupTestMetric, Value: 3, Timestamp: 10}}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, result)
+	}
+
+	if _, err := NewAggregateStrategy("sum"); err == nil {
+		t.Fatalf("expected error for unknown aggregate function")
+	}
+}
@@ -0,0 +1,230 @@
+package promhttputil
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+)
+
+// NewHistogramStrategy returns a GroupMergeStrategy which keeps histograms consistent when
+// merging. All the bucket series of a histogram (the `_bucket` series with the same labels
+// except `le`) are merged together, taking all buckets at a given time from the same replica.
+// The choice of replica over time is made by inner; all other series are merged with inner as-is.
+//
+// Aggregating strategies are already consistent (they combine all replicas at each time) so
+// they are returned unchanged.
+func NewHistogramStrategy(inner MergeStrategy) MergeStrategy {
+	if _, ok := inner.(*aggregateStrategy); ok {
+		return inner
+	}
+	return &histogramStrategy{inner}
+}
+
+type histogramStrategy struct {
+	MergeStrategy
+}
+
+// isBucket returns whether m is the bucket series of a histogram
+func isBucket(m model.Metric) bool {
+	_, ok := m[model.BucketLabel]
+	return ok && strings.HasSuffix(string(m[model.MetricNameLabel]), "_bucket")
+}
+
+// histogramMetric returns the metric of the histogram that the bucket series m belongs to
+func histogramMetric(m model.Metric) model.Metric {
+	h := make(model.Metric, len(m)-1)
+	for k, v := range m {
+		if k != model.BucketLabel {
+			h[k] = v
+		}
+	}
+	return h
+}
+
+// histogramGroup is the bucket series of a single histogram from all replicas
+type histogramGroup struct {
+	metric model.Metric
+	// les are all the buckets of the histogram (in the order we first saw them)
+	les     []model.LabelValue
+	metrics map[model.LabelValue]model.Metric
+}
+
+func (h *histogramGroup) addBucket(m model.Metric) {
+	le := m[model.BucketLabel]
+	if _, ok := h.metrics[le]; !ok {
+		h.les = append(h.les, le)
+		h.metrics[le] = m
+	}
+}
+
+// histogramGroups groups bucket series by the histogram they belong to, keeping the
+// histograms in the order we first saw them
+type histogramGroups struct {
+	order  []model.Fingerprint
+	groups map[model.Fingerprint]*histogramGroup
+}
+
+func (h *histogramGroups) add(m model.Metric) model.Fingerprint {
+	metric := histogramMetric(m)
+	finger := metric.Fingerprint()
+	g, ok := h.groups[finger]
+	if !ok {
+		g = &histogramGroup{metric: metric, metrics: make(map[model.LabelValue]model.Metric)}
+		h.groups[finger] = g
+		h.order = append(h.order, finger)
+	}
+	g.addBucket(m)
+	return finger
+}
+
+// MergeVectors merges the vectors, taking all buckets of a histogram from the first replica
+// that has all of them
+func (h *histogramStrategy) MergeVectors(vectors []model.Vector) model.Vector {
+	groups := &histogramGroups{groups: make(map[model.Fingerprint]*histogramGroup)}
+	others := make([]model.Vector, len(vectors))
+	// buckets is (replica -> histogram -> le -> sample)
+	buckets := make([]map[model.Fingerprint]map[model.LabelValue]*model.Sample, len(vectors))
+	for i, v := range vectors {
+		buckets[i] = make(map[model.Fingerprint]map[model.LabelValue]*model.Sample)
+		for _, item := range v {
+			if !isBucket(item.Metric) {
+				others[i] = append(others[i], item)
+				continue
+			}
+			finger := groups.add(item.Metric)
+			if buckets[i][finger] == nil {
+				buckets[i][finger] = make(map[model.LabelValue]*model.Sample)
+			}
+			buckets[i][finger][item.Metric[model.BucketLabel]] = item
+		}
+	}
+
+	ret := mergeVectors(h.MergeStrategy, others)
+	for _, finger := range groups.order {
+		g := groups.groups[finger]
+		found := false
+		for i := range vectors {
+			if len(buckets[i][finger]) == len(g.les) {
+				for _, le := range g.les {
+					ret = append(ret, buckets[i][finger][le])
+				}
+				found = true
+				break
+			}
+		}
+
+		// If no replica has all the buckets, the best we can do is merge them individually
+		if !found {
+			groupVectors := make([]model.Vector, len(vectors))
+			for i := range vectors {
+				for _, le := range g.les {
+					if s, ok := buckets[i][finger][le]; ok {
+						groupVectors[i] = append(groupVectors[i], s)
+					}
+				}
+			}
+			ret = append(ret, mergeVectors(h.MergeStrategy, groupVectors)...)
+		}
+	}
+	return ret
+}
+
+// MergeMatrices merges the matrices. For each histogram the times at which a replica has all
+// the buckets of the histogram are merged with the inner strategy to pick which replica to
+// use at each time, then all the buckets at that time are taken from that replica
+func (h *histogramStrategy) MergeMatrices(matrices []model.Matrix) (model.Matrix, error) {
+	groups := &histogramGroups{groups: make(map[model.Fingerprint]*histogramGroup)}
+	others := make([]model.Matrix, len(matrices))
+	// streams is (replica -> histogram -> bucket streams)
+	streams := make([]map[model.Fingerprint][]*model.SampleStream, len(matrices))
+	for i, m := range matrices {
+		streams[i] = make(map[model.Fingerprint][]*model.SampleStream)
+		for _, stream := range m {
+			if !isBucket(stream.Metric) {
+				others[i] = append(others[i], stream)
+				continue
+			}
+			finger := groups.add(stream.Metric)
+			streams[i][finger] = append(streams[i][finger], stream)
+		}
+	}
+
+	ret, err := mergeMatrices(h.MergeStrategy, others)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, finger := range groups.order {
+		merged, err := h.mergeHistogram(groups.groups[finger], finger, streams)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, merged...)
+	}
+	return ret, nil
+}
+
+// mergeHistogram merges the bucket streams of a single histogram from all replicas
+func (h *histogramStrategy) mergeHistogram(g *histogramGroup, finger model.Fingerprint, streams []map[model.Fingerprint][]*model.SampleStream) (model.Matrix, error) {
+	// rows is (replica -> time -> le -> value)
+	rows := make([]map[model.Time]map[model.LabelValue]model.SampleValue, len(streams))
+	// To pick the replica at each time we create a stream (per replica) of the times at which the
+	// replica has all buckets with the replica's index as the value
+	replicaStreams := make([]*model.SampleStream, 0, len(streams))
+	for i := range streams {
+		rows[i] = make(map[model.Time]map[model.LabelValue]model.SampleValue)
+		for _, stream := range streams[i][finger] {
+			le := stream.Metric[model.BucketLabel]
+			for _, v := range stream.Values {
+				row, ok := rows[i][v.Timestamp]
+				if !ok {
+					row = make(map[model.LabelValue]model.SampleValue, len(g.les))
+					rows[i][v.Timestamp] = row
+				}
+				row[le] = v.Value
+			}
+		}
+
+		times := make([]model.Time, 0, len(rows[i]))
+		for t, row := range rows[i] {
+			if len(row) == len(g.les) {
+				times = append(times, t)
+			}
+		}
+		if len(times) == 0 {
+			continue
+		}
+		sort.Slice(times, func(a, b int) bool { return times[a] < times[b] })
+
+		replicaStream := &model.SampleStream{Metric: g.metric, Values: make([]model.SamplePair, len(times))}
+		for j, t := range times {
+			replicaStream.Values[j] = model.SamplePair{Timestamp: t, Value: model.SampleValue(i)}
+		}
+		replicaStreams = append(replicaStreams, replicaStream)
+	}
+
+	// If no replica ever has all the buckets, the best we can do is merge them individually
+	if len(replicaStreams) == 0 {
+		groupMatrices := make([]model.Matrix, len(streams))
+		for i := range streams {
+			groupMatrices[i] = streams[i][finger]
+		}
+		return mergeMatrices(h.MergeStrategy, groupMatrices)
+	}
+
+	picked, err := h.MergeStrategy.MergeSampleStreams(replicaStreams)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(model.Matrix, len(g.les))
+	for i, le := range g.les {
+		values := make([]model.SamplePair, len(picked.Values))
+		for j, p := range picked.Values {
+			values[j] = model.SamplePair{Timestamp: p.Timestamp, Value: rows[int(p.Value)][p.Timestamp][le]}
+		}
+		ret[i] = &model.SampleStream{Metric: g.metrics[le], Values: values}
+	}
+	return ret, nil
+}
@@ -0,0 +1,91 @@
+package promhttputil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+func bucketMetric(le string) model.Metric {
+	return model.Metric{model.MetricNameLabel: "foo_bucket", model.BucketLabel: model.LabelValue(le)}
+}
+
+func TestHistogramStrategyMatrix(t *testing.T) {
+	a := model.Matrix{
+		{Metric: bucketMetric("1"), Values: []model.SamplePair{{10, 1}, {20, 2}, {30, 3}}},
+		{Metric: bucketMetric("+Inf"), Values: []model.SamplePair{{10, 2}, {30, 6}}},
+		{Metric: model.Metric{model.MetricNameLabel: "foo_count"}, Values: []model.SamplePair{{10, 2}, {20, 4}, {30, 6}}},
+	}
+	b := model.Matrix{
+		{Metric: bucketMetric("1"), Values: []model.SamplePair{{10, 10}, {20, 20}, {30, 30}}},
+		{Metric: bucketMetric("+Inf"), Values: []model.SamplePair{{10, 20}, {20, 40}, {30, 60}}},
+	}
+
+	// Merging each series on its own mixes the replicas within the histogram
+	result, err := MergeValuesWithStrategy(NewGapFillStrategy(2), a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v := result.(model.Matrix)[0].Values[0].Value; v != 1 {
+		t.Fatalf("expected le=1 from the first replica, got %v", v)
+	}
+	if v := result.(model.Matrix)[1].Values[0].Value; v != 20 {
+		t.Fatalf("expected le=+Inf from the second replica, got %v", v)
+	}
+
+	// Only the second replica has all the buckets at all times, so we use it for all of them
+	result, err = MergeValuesWithStrategy(NewHistogramStrategy(NewGapFillStrategy(2)), a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := model.Matrix{
+		a[2],
+		b[0],
+		b[1],
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, result)
+	}
+}
+
+func TestHistogramStrategyGapFill(t *testing.T) {
+	a := model.Matrix{
+		{Metric: bucketMetric("1"), Values: []model.SamplePair{{10, 1}, {20, 2}, {50, 5}, {60, 6}}},
+		{Metric: bucketMetric("+Inf"), Values: []model.SamplePair{{10, 2}, {20, 4}, {50, 10}, {60, 12}}},
+	}
+	b := model.Matrix{
+		{Metric: bucketMetric("1"), Values: []model.SamplePair{{30, 30}, {40, 40}}},
+		{Metric: bucketMetric("+Inf"), Values: []model.SamplePair{{30, 60}, {40, 80}}},
+	}
+
+	result, err := MergeValuesWithStrategy(NewHistogramStrategy(NewGapFillStrategy(2)), a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := model.Matrix{
+		{Metric: bucketMetric("1"), Values: []model.SamplePair{{10, 1}, {20, 2}, {30, 30}, {40, 40}, {50, 5}, {60, 6}}},
+		{Metric: bucketMetric("+Inf"), Values: []model.SamplePair{{10, 2}, {20, 4}, {30, 60}, {40, 80}, {50, 10}, {60, 12}}},
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, result)
+	}
+}
+
+func TestHistogramStrategyVector(t *testing.T) {
+	a := model.Vector{
+		{Metric: bucketMetric("1"), Value: 1, Timestamp: 10},
+	}
+	b := model.Vector{
+		{Metric: bucketMetric("1"), Value: 10, Timestamp: 10},
+		{Metric: bucketMetric("+Inf"), Value: 20, Timestamp: 10},
+	}
+
+	result, err := MergeValuesWithStrategy(NewHistogramStrategy(NewGapFillStrategy(2)), a, b)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(result, b) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", b, result)
+	}
+}
@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"sort"
+	"strings"
 
 	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
 	"github.com/prometheus/common/model"
@@ -71,99 +73,122 @@ func ValueAddLabelSet(a model.Value, l model.LabelSet) error {
 
 // MergeValues merges values `a` and `b` with the given antiAffinityBuffer
 func MergeValues(antiAffinityBuffer model.Time, a, b model.Value) (model.Value, error) {
-	if a == nil {
-		return b, nil
+	return MergeValuesWithStrategy(NewGapFillStrategy(antiAffinityBuffer), a, b)
+}
+
+// MergeValuesWithStrategy merges `values` (each from a different replica) using the
+// given strategy to dedupe series which are present in more than one of them
+func MergeValuesWithStrategy(strategy MergeStrategy, values ...model.Value) (model.Value, error) {
+	nonNil := make([]model.Value, 0, len(values))
+	for _, v := range values {
+		if v != nil {
+			nonNil = append(nonNil, v)
+		}
 	}
-	if b == nil {
-		return a, nil
+	if len(nonNil) == 0 {
+		return nil, nil
 	}
-	if a.Type() != b.Type() {
-		return nil, fmt.Errorf("mismatch type %v!=%v", a.Type(), b.Type())
+	if len(nonNil) == 1 {
+		return nonNil[0], nil
+	}
+	for _, v := range nonNil[1:] {
+		if nonNil[0].Type() != v.Type() {
+			return nil, fmt.Errorf("mismatch type %v!=%v", nonNil[0].Type(), v.Type())
+		}
 	}
 
-	switch aTyped := a.(type) {
+	switch aTyped := nonNil[0].(type) {
 	// TODO: more logic? for now we assume both are correct if they exist
 	// In the case where it is a single datapoint, we're going to assume that
 	// either is valid, we just need one
 	case *model.Scalar:
-		bTyped := b.(*model.Scalar)
-
-		if aTyped.Value != 0 && aTyped.Timestamp != 0 {
-			return aTyped, nil
+		for _, v := range nonNil[1:] {
+			if aTyped.Value != 0 && aTyped.Timestamp != 0 {
+				break
+			}
+			aTyped = v.(*model.Scalar)
 		}
-		return bTyped, nil
+		return aTyped, nil
 
 	// In the case where it is a single datapoint, we're going to assume that
 	// either is valid, we just need one
 	case *model.String:
-		bTyped := b.(*model.String)
-
-		if aTyped.Value != "" && aTyped.Timestamp != 0 {
-			return aTyped, nil
+		for _, v := range nonNil[1:] {
+			if aTyped.Value != "" && aTyped.Timestamp != 0 {
+				break
+			}
+			aTyped = v.(*model.String)
 		}
-		return bTyped, nil
+		return aTyped, nil
 
 	// List of *model.Sample -- only 1 value (guaranteed same timestamp)
 	case model.Vector:
-		bTyped := b.(model.Vector)
-
-		newValue := make(model.Vector, 0, len(aTyped)+len(bTyped))
-		fingerPrintMap := make(map[model.Fingerprint]int)
-
-		addItem := func(item *model.Sample) {
-			finger := item.Metric.Fingerprint()
-
-			// If we've seen this fingerPrint before, lets make sure that a value exists
-			if index, ok := fingerPrintMap[finger]; ok {
-				// Only replace if we have no value (which seems reasonable)
-				if newValue[index].Value == model.SampleValue(0) {
-					newValue[index].Value = item.Value
-				}
-			} else {
-				newValue = append(newValue, item)
-				fingerPrintMap[finger] = len(newValue) - 1
-			}
-		}
-
-		for _, item := range aTyped {
-			addItem(item)
+		vectors := make([]model.Vector, len(nonNil))
+		for i, v := range nonNil {
+			vectors[i] = v.(model.Vector)
 		}
-
-		for _, item := range bTyped {
-			addItem(item)
+		if g, ok := strategy.(GroupMergeStrategy); ok {
+			return g.MergeVectors(vectors), nil
 		}
-		return newValue, nil
+		return mergeVectors(strategy, vectors), nil
 
 	case model.Matrix:
-		bTyped := b.(model.Matrix)
-
-		newValue := make(model.Matrix, 0, len(aTyped)+len(bTyped))
-		fingerPrintMap := make(map[model.Fingerprint]int)
+		matrices := make([]model.Matrix, len(nonNil))
+		for i, v := range nonNil {
+			matrices[i] = v.(model.Matrix)
+		}
+		if g, ok := strategy.(GroupMergeStrategy); ok {
+			return g.MergeMatrices(matrices)
+		}
+		return mergeMatrices(strategy, matrices)
+	}
 
-		addStream := func(stream *model.SampleStream) {
-			finger := stream.Metric.Fingerprint()
+	return nil, fmt.Errorf("unknown type! %v", reflect.TypeOf(nonNil[0]))
+}
 
-			// If we've seen this fingerPrint before, lets make sure that a value exists
-			if index, ok := fingerPrintMap[finger]; ok {
-				// TODO: check this error? For now the only one is sig collision, which we check
-				newValue[index], _ = MergeSampleStream(antiAffinityBuffer, newValue[index], stream)
-			} else {
-				newValue = append(newValue, stream)
-				fingerPrintMap[finger] = len(newValue) - 1
+// mergeVectors merges the samples of each series in vectors with strategy
+func mergeVectors(strategy MergeStrategy, vectors []model.Vector) model.Vector {
+	var order []model.Fingerprint
+	samples := make(map[model.Fingerprint][]*model.Sample)
+	for _, v := range vectors {
+		for _, item := range v {
+			finger := item.Metric.Fingerprint()
+			if _, ok := samples[finger]; !ok {
+				order = append(order, finger)
 			}
+			samples[finger] = append(samples[finger], item)
 		}
+	}
 
-		for _, item := range aTyped {
-			addStream(item)
-		}
+	newValue := make(model.Vector, len(order))
+	for i, finger := range order {
+		newValue[i] = strategy.MergeSamples(samples[finger])
+	}
+	return newValue
+}
 
-		for _, item := range bTyped {
-			addStream(item)
+// mergeMatrices merges the streams of each series in matrices with strategy
+func mergeMatrices(strategy MergeStrategy, matrices []model.Matrix) (model.Matrix, error) {
+	var order []model.Fingerprint
+	streams := make(map[model.Fingerprint][]*model.SampleStream)
+	for _, v := range matrices {
+		for _, stream := range v {
+			finger := stream.Metric.Fingerprint()
+			if _, ok := streams[finger]; !ok {
+				order = append(order, finger)
+			}
+			streams[finger] = append(streams[finger], stream)
 		}
-		return newValue, nil
 	}
 
-	return nil, fmt.Errorf("unknown type! %v", reflect.TypeOf(a))
+	newValue := make(model.Matrix, len(order))
+	for i, finger := range order {
+		var err error
+		if newValue[i], err = strategy.MergeSampleStreams(streams[finger]); err != nil {
+			return nil, err
+		}
+	}
+	return newValue, nil
 }
 
 // MergeSampleStream merges SampleStreams `a` and `b` with the given antiAffinityBuffer
@@ -176,15 +201,37 @@ func MergeValues(antiAffinityBuffer model.Time, a, b model.Value) (model.Value,
 // we have. This means we can tolerate antiAffinityBuffer/2 on either side (which can be used by either
 // clock skew or from this scrape skew).
 func MergeSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream) (*model.SampleStream, error) {
+	merged, _, err := mergeSampleStream(antiAffinityBuffer, a, b, false)
+	return merged, err
+}
+
+// MergeCounterSampleStream merges SampleStreams `a` and `b` the same as MergeSampleStream
+// except that if the series is a counter (based on its name) the samples spliced in from
+// the other stream are offset so that they continue from the value of the stream they
+// are filling. Replicas of a counter often have different absolute values, so splicing
+// them in as-is would show up as counter resets (or jumps) to promql.
+func MergeCounterSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream) (*model.SampleStream, error) {
+	merged, _, err := mergeSampleStream(antiAffinityBuffer, a, b, IsCounter(a.Metric))
+	return merged, err
+}
+
+// IsCounter returns whether the series is (heuristically) a counter, meaning its name ends in `_total`
+func IsCounter(m model.Metric) bool {
+	return strings.HasSuffix(string(m[model.MetricNameLabel]), "_total")
+}
+
+// mergeSampleStream merges a and b (see MergeSampleStream) and also returns the number of
+// points filled in from the stream with fewer points
+func mergeSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream, counter bool) (*model.SampleStream, int, error) {
 	if a.Metric.Fingerprint() != b.Metric.Fingerprint() {
-		return nil, fmt.Errorf("cannot merge mismatch fingerprints")
+		return nil, 0, fmt.Errorf("cannot merge mismatch fingerprints")
 	}
 
 	// if either set of values are empty, return the one with data
 	if len(a.Values) == 0 {
-		return b, nil
+		return b, 0, nil
 	} else if len(b.Values) == 0 {
-		return a, nil
+		return a, 0, nil
 	}
 
 	// If B has more points then we want to use that as the base for merging. This is important as
@@ -204,15 +251,49 @@ func MergeSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream)
 
 	newValues := make([]model.SamplePair, 0, len(a.Values))
 
+	// For counters, the points from b are offset so they continue from the point of a
+	// they follow. We re-calculate the offset whenever we switch from a to b.
+	var bCounterOffset model.SampleValue
+	lastFromA := false
+	filled := 0
+	// bValueAt returns the value of b at time t (its last point at or before t)
+	bValueAt := func(t model.Time, def model.SampleValue) model.SampleValue {
+		i := sort.Search(len(b.Values), func(i int) bool { return b.Values[i].Timestamp > t })
+		if i == 0 {
+			return def
+		}
+		return b.Values[i-1].Value
+	}
+	appendB := func(bValue model.SamplePair) {
+		if counter {
+			if lastFromA {
+				last := newValues[len(newValues)-1]
+				bCounterOffset = last.Value - bValueAt(last.Timestamp, bValue.Value)
+			}
+			bValue.Value += bCounterOffset
+		}
+		newValues = append(newValues, bValue)
+		lastFromA = false
+		filled++
+	}
+	appendA := func(aValue model.SamplePair) {
+		newValues = append(newValues, aValue)
+		lastFromA = true
+	}
+
 	bOffset := 0
 	aStartBuffered := a.Values[0].Timestamp - antiAffinityBuffer
 
 	// start by loading b points before a
 	if b.Values[0].Timestamp < aStartBuffered {
+		// These points are before all of a, so they are offset to lead into a's first point
+		if counter {
+			bCounterOffset = a.Values[0].Value - bValueAt(a.Values[0].Timestamp, a.Values[0].Value)
+		}
 		for i, bValue := range b.Values {
 			bOffset = i
 			if bValue.Timestamp < aStartBuffered {
-				newValues = append(newValues, bValue)
+				appendB(bValue)
 			} else {
 				break
 			}
@@ -223,7 +304,7 @@ func MergeSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream)
 	for _, aValue := range a.Values {
 		// if we have no points, this one by definition is valid
 		if len(newValues) == 0 {
-			newValues = append(newValues, aValue)
+			appendA(aValue)
 			continue
 		}
 
@@ -238,23 +319,23 @@ func MergeSampleStream(antiAffinityBuffer model.Time, a, b *model.SampleStream)
 					break
 				}
 				if bValue.Timestamp > lastTime+antiAffinityBuffer && bValue.Timestamp < (aValue.Timestamp-antiAffinityBuffer) {
-					newValues = append(newValues, bValue)
+					appendB(bValue)
 				}
 			}
 		}
-		newValues = append(newValues, aValue)
+		appendA(aValue)
 	}
 
 	lastTime := newValues[len(newValues)-1].Timestamp
 	for ; bOffset < len(b.Values); bOffset++ {
 		bValue := b.Values[bOffset]
 		if bValue.Timestamp > lastTime+antiAffinityBuffer {
-			newValues = append(newValues, bValue)
+			appendB(bValue)
 		}
 	}
 
 	return &model.SampleStream{
 		Metric: a.Metric,
 		Values: newValues,
-	}, nil
+	}, filled, nil
 }
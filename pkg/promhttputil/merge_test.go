@@ -739,3 +739,50 @@ func TestMergeValues(t *testing.T) {
 	}
 
 }
+
+func TestMergeCounterSampleStream(t *testing.T) {
+	stream := func(name string, points ...model.SamplePair) *model.SampleStream {
+		return &model.SampleStream{
+			Metric: model.Metric(model.LabelSet{model.MetricNameLabel: model.LabelValue(name)}),
+			Values: points,
+		}
+	}
+
+	tests := []struct {
+		name string
+		a    *model.SampleStream
+		b    *model.SampleStream
+		r    *model.SampleStream
+	}{
+		{
+			name: "counter gap",
+			a:    stream("x_total", model.SamplePair{10, 100}, model.SamplePair{20, 110}, model.SamplePair{50, 140}, model.SamplePair{60, 150}, model.SamplePair{70, 160}),
+			b:    stream("x_total", model.SamplePair{10, 1000}, model.SamplePair{20, 1010}, model.SamplePair{30, 1020}, model.SamplePair{40, 1030}),
+			r:    stream("x_total", model.SamplePair{10, 100}, model.SamplePair{20, 110}, model.SamplePair{30, 120}, model.SamplePair{40, 130}, model.SamplePair{50, 140}, model.SamplePair{60, 150}, model.SamplePair{70, 160}),
+		},
+		{
+			name: "counter before and after",
+			a:    stream("x_total", model.SamplePair{30, 120}, model.SamplePair{40, 130}, model.SamplePair{50, 140}, model.SamplePair{60, 150}),
+			b:    stream("x_total", model.SamplePair{10, 1000}, model.SamplePair{20, 1010}, model.SamplePair{60, 1050}, model.SamplePair{70, 1060}),
+			r:    stream("x_total", model.SamplePair{10, 110}, model.SamplePair{20, 120}, model.SamplePair{30, 120}, model.SamplePair{40, 130}, model.SamplePair{50, 140}, model.SamplePair{60, 150}, model.SamplePair{70, 160}),
+		},
+		{
+			name: "not a counter",
+			a:    stream("x", model.SamplePair{10, 100}, model.SamplePair{20, 110}, model.SamplePair{50, 140}, model.SamplePair{60, 150}, model.SamplePair{70, 160}),
+			b:    stream("x", model.SamplePair{10, 1000}, model.SamplePair{20, 1010}, model.SamplePair{30, 1020}, model.SamplePair{40, 1030}),
+			r:    stream("x", model.SamplePair{10, 100}, model.SamplePair{20, 110}, model.SamplePair{30, 1020}, model.SamplePair{40, 1030}, model.SamplePair{50, 140}, model.SamplePair{60, 150}, model.SamplePair{70, 160}),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := MergeCounterSampleStream(model.Time(2), test.a, test.b)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(result, test.r) {
+				t.Fatalf("mismatch in %s \nexpected=%v\nactual=%v", test.name, test.r, result)
+			}
+		})
+	}
+}
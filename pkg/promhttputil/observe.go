@@ -0,0 +1,231 @@
+package promhttputil
+
+import (
+	"math"
+	"math/rand"
+
+	"github.com/prometheus/common/model"
+)
+
+// MergeObserver is notified about the merging of series that were returned by more than one replica
+type MergeObserver interface {
+	// ObserveFill is called for each merged series with the number of its samples that were
+	// filled in from replicas other than the primary (the replica which contributed the most samples)
+	ObserveFill(filled int)
+	// ObserveDivergence is called for each series that was consistency checked with the number
+	// of overlapping samples compared between replicas and how many of them diverged
+	ObserveDivergence(compared, diverged int)
+}
+
+// ConsistencyCheck configures the comparison of the replicas' samples when merging
+type ConsistencyCheck struct {
+	// SampleRate is the fraction (0-1) of merged series that are checked
+	SampleRate float64
+	// Tolerance is the relative difference between values beyond which they diverge
+	Tolerance float64
+	// Window is the max distance in time between samples of different replicas for them to overlap
+	Window model.Time
+	// AutoWindow derives the window of each series from the median spacing of its samples (with
+	// Window as the floor), matching the auto anti-affinity of NewAutoGapFillStrategy
+	AutoWindow bool
+}
+
+// window returns the window to compare the replicas of a series with
+func (c *ConsistencyCheck) window(replicas []*model.SampleStream) model.Time {
+	if c.AutoWindow {
+		if spacing := medianSpacing(replicas); spacing > c.Window {
+			return spacing
+		}
+	}
+	return c.Window
+}
+
+// NewObservedStrategy returns a GroupMergeStrategy which merges with inner and reports how
+// the series were merged to observer. If check is set a sample of the merged series also
+// have the overlapping samples of their replicas compared
+func NewObservedStrategy(inner MergeStrategy, observer MergeObserver, check *ConsistencyCheck) GroupMergeStrategy {
+	return &observedStrategy{inner, observer, check}
+}
+
+// fillCountingStrategy is a MergeStrategy which can count the samples of a merged stream
+// that were filled in from replicas other than the primary while merging
+type fillCountingStrategy interface {
+	mergeSampleStreamsCountingFills(streams []*model.SampleStream) (*model.SampleStream, int, error)
+}
+
+type observedStrategy struct {
+	MergeStrategy
+	observer MergeObserver
+	check    *ConsistencyCheck
+}
+
+func (o *observedStrategy) MergeVectors(vectors []model.Vector) model.Vector {
+	if g, ok := o.MergeStrategy.(GroupMergeStrategy); ok {
+		return g.MergeVectors(vectors)
+	}
+	return mergeVectors(o.MergeStrategy, vectors)
+}
+
+func (o *observedStrategy) MergeMatrices(matrices []model.Matrix) (model.Matrix, error) {
+	if g, ok := o.MergeStrategy.(GroupMergeStrategy); ok {
+		return o.mergeGroup(g, matrices)
+	}
+
+	// The same as mergeMatrices, observing the series returned by more than one replica
+	var order []model.Fingerprint
+	streams := make(map[model.Fingerprint][]*model.SampleStream)
+	for _, v := range matrices {
+		for _, stream := range v {
+			finger := stream.Metric.Fingerprint()
+			if _, ok := streams[finger]; !ok {
+				order = append(order, finger)
+			}
+			streams[finger] = append(streams[finger], stream)
+		}
+	}
+
+	newValue := make(model.Matrix, len(order))
+	for i, finger := range order {
+		replicas := streams[finger]
+		if len(replicas) < 2 {
+			var err error
+			if newValue[i], err = o.MergeStrategy.MergeSampleStreams(replicas); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		o.maybeCheckConsistency(replicas)
+		var filled int
+		var err error
+		if f, ok := o.MergeStrategy.(fillCountingStrategy); ok {
+			newValue[i], filled, err = f.mergeSampleStreamsCountingFills(replicas)
+		} else {
+			// Merging may reuse the input streams, so we note their lengths first
+			longest := longestStream(replicas)
+			if newValue[i], err = o.MergeStrategy.MergeSampleStreams(replicas); err == nil {
+				filled = len(newValue[i].Values) - longest
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		o.observer.ObserveFill(filled)
+	}
+	return newValue, nil
+}
+
+// mergeGroup merges matrices with g. As g merges all the series at once the samples filled in are
+// estimated as the samples of the merged series beyond those of its longest replica
+func (o *observedStrategy) mergeGroup(g GroupMergeStrategy, matrices []model.Matrix) (model.Matrix, error) {
+	// Merging may reuse the input streams, so we note everything we need from them first
+	type replicaInfo struct {
+		count, longest int
+	}
+	infos := make(map[model.Fingerprint]*replicaInfo)
+	var streams map[model.Fingerprint][]*model.SampleStream
+	if o.check != nil {
+		streams = make(map[model.Fingerprint][]*model.SampleStream)
+	}
+	for _, m := range matrices {
+		for _, stream := range m {
+			finger := stream.Metric.Fingerprint()
+			info, ok := infos[finger]
+			if !ok {
+				info = &replicaInfo{}
+				infos[finger] = info
+			}
+			info.count++
+			if len(stream.Values) > info.longest {
+				info.longest = len(stream.Values)
+			}
+			if streams != nil {
+				streams[finger] = append(streams[finger], stream)
+			}
+		}
+	}
+	for _, replicas := range streams {
+		if len(replicas) >= 2 {
+			o.maybeCheckConsistency(replicas)
+		}
+	}
+
+	merged, err := g.MergeMatrices(matrices)
+	if err != nil {
+		return nil, err
+	}
+	for _, stream := range merged {
+		if info, ok := infos[stream.Metric.Fingerprint()]; ok && info.count >= 2 {
+			o.observer.ObserveFill(len(stream.Values) - info.longest)
+		}
+	}
+	return merged, nil
+}
+
+// longestStream returns the number of samples of the longest of streams
+func longestStream(streams []*model.SampleStream) int {
+	longest := 0
+	for _, stream := range streams {
+		if len(stream.Values) > longest {
+			longest = len(stream.Values)
+		}
+	}
+	return longest
+}
+
+// maybeCheckConsistency compares the samples of the replicas of a series if the consistency
+// check is enabled and the series is sampled
+func (o *observedStrategy) maybeCheckConsistency(replicas []*model.SampleStream) {
+	if o.check == nil || rand.Float64() >= o.check.SampleRate {
+		return
+	}
+	window := o.check.window(replicas)
+	var compared, diverged int
+	for _, other := range replicas[1:] {
+		c, d := o.check.compare(window, replicas[0].Values, other.Values)
+		compared += c
+		diverged += d
+	}
+	o.observer.ObserveDivergence(compared, diverged)
+}
+
+// compare compares each sample of b with the closest sample of a (if it is within window)
+// and returns the number of samples compared and how many of them diverged
+func (c *ConsistencyCheck) compare(window model.Time, a, b []model.SamplePair) (compared, diverged int) {
+	i := 0
+	for _, bv := range b {
+		// Move to the last sample of a at or before bv
+		for i+1 < len(a) && a[i+1].Timestamp <= bv.Timestamp {
+			i++
+		}
+		closest := -1
+		for _, j := range []int{i, i + 1} {
+			if j >= len(a) || distance(a[j].Timestamp, bv.Timestamp) > window {
+				continue
+			}
+			if closest == -1 || distance(a[j].Timestamp, bv.Timestamp) < distance(a[closest].Timestamp, bv.Timestamp) {
+				closest = j
+			}
+		}
+		if closest == -1 {
+			continue
+		}
+
+		x, y := float64(a[closest].Value), float64(bv.Value)
+		if math.IsNaN(x) || math.IsNaN(y) {
+			continue
+		}
+		compared++
+		if math.Abs(x-y) > c.Tolerance*math.Max(math.Abs(x), math.Abs(y)) {
+			diverged++
+		}
+	}
+	return compared, diverged
+}
+
+func distance(a, b model.Time) model.Time {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
@@ -0,0 +1,95 @@
+package promhttputil
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/common/model"
+)
+
+type recordingObserver struct {
+	fills              []int
+	compared, diverged int
+}
+
+func (r *recordingObserver) ObserveFill(filled int) {
+	r.fills = append(r.fills, filled)
+}
+
+func (r *recordingObserver) ObserveDivergence(compared, diverged int) {
+	r.compared += compared
+	r.diverged += diverged
+}
+
+func TestObservedStrategy(t *testing.T) {
+	observer := &recordingObserver{}
+	check := &ConsistencyCheck{SampleRate: 1, Tolerance: 0.1, Window: 2}
+	strategy := NewObservedStrategy(NewGapFillStrategy(2), observer, check)
+
+	other := &model.SampleStream{
+		Metric: model.Metric{model.MetricNameLabel: "other"},
+		Values: []model.SamplePair{{10, 1}},
+	}
+	result, err := MergeValuesWithStrategy(strategy,
+		model.Matrix{testStream(1, 10, 20, 50, 60), other},
+		model.Matrix{testStream(2, 10, 21, 30, 40)},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := model.Matrix{
+		{Metric: dedupTestMetric, Values: []model.SamplePair{{10, 1}, {20, 1}, {30, 2}, {40, 2}, {50, 1}, {60, 1}}},
+		other,
+	}
+	if !reflect.DeepEqual(result, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, result)
+	}
+
+	// Only the series returned by both is observed, with 2 samples filled from the second
+	if !reflect.DeepEqual(observer.fills, []int{2}) {
+		t.Fatalf("mismatch in fills: %v", observer.fills)
+	}
+	// The samples at 10 and 21 overlap with the first replica's, and both diverge
+	if observer.compared != 2 || observer.diverged != 2 {
+		t.Fatalf("mismatch in consistency check compared=%d diverged=%d", observer.compared, observer.diverged)
+	}
+
+	observer = &recordingObserver{}
+	strategy = NewObservedStrategy(NewGapFillStrategy(2), observer, check)
+	if _, err := MergeValuesWithStrategy(strategy,
+		model.Matrix{testStream(100, 10, 20)},
+		model.Matrix{testStream(105, 11, 21)},
+	); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if observer.compared != 2 || observer.diverged != 0 {
+		t.Fatalf("mismatch in consistency check compared=%d diverged=%d", observer.compared, observer.diverged)
+	}
+}
+
+func TestObservedStrategyAutoWindow(t *testing.T) {
+	for _, autoWindow := range []bool{false, true} {
+		observer := &recordingObserver{}
+		check := &ConsistencyCheck{SampleRate: 1, Tolerance: 0.1, Window: 2, AutoWindow: autoWindow}
+		strategy := NewObservedStrategy(NewAutoGapFillStrategy(2, false), observer, check)
+		if _, err := MergeValuesWithStrategy(strategy,
+			model.Matrix{testStream(100, 10, 20, 30)},
+			model.Matrix{testStream(100, 15, 25, 35)},
+		); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		// The samples are 10 apart, so they only overlap within the derived window
+		expected := 0
+		if autoWindow {
+			expected = 3
+		}
+		if observer.compared != expected || observer.diverged != 0 {
+			t.Fatalf("mismatch in consistency check autoWindow=%v compared=%d diverged=%d", autoWindow, observer.compared, observer.diverged)
+		}
+		// The auto anti-affinity doesn't fill in any of the second replica's samples
+		if !reflect.DeepEqual(observer.fills, []int{0}) {
+			t.Fatalf("mismatch in fills: %v", observer.fills)
+		}
+	}
+}
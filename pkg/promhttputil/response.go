@@ -0,0 +1,31 @@
+package promhttputil
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ErrorResponse is the body the prometheus API returns when there is an error
+type ErrorResponse struct {
+	Status    Status    `json:"status"`
+	ErrorType ErrorType `json:"errorType,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// WriteError writes err to w in the same format the prometheus API would, this
+// way clients (e.g. grafana) will show the error message to the user
+func WriteError(w http.ResponseWriter, code int, errorType ErrorType, err error) {
+	b, marshalErr := json.Marshal(&ErrorResponse{
+		Status:    StatusError,
+		ErrorType: errorType,
+		Error:     err.Error(),
+	})
+	if marshalErr != nil {
+		http.Error(w, marshalErr.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(b)
+}
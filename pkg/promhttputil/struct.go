@@ -12,10 +12,14 @@ const (
 type ErrorType string
 
 const (
-	ErrorNone     ErrorType = ""
-	ErrorTimeout  ErrorType = "timeout"
-	ErrorCanceled ErrorType = "canceled"
-	ErrorExec     ErrorType = "execution"
-	ErrorBadData  ErrorType = "bad_data"
-	ErrorInternal ErrorType = "internal"
+	ErrorNone        ErrorType = ""
+	ErrorTimeout     ErrorType = "timeout"
+	ErrorCanceled    ErrorType = "canceled"
+	ErrorExec        ErrorType = "execution"
+	ErrorBadData     ErrorType = "bad_data"
+	ErrorInternal    ErrorType = "internal"
+	ErrorUnavailable ErrorType = "unavailable"
+
+	// ErrorTooManyRequests is promxy specific; it is returned when a client is being rate limited
+	ErrorTooManyRequests ErrorType = "too_many_requests"
 )
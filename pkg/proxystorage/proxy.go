@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"reflect"
+	"sort"
 	"sync/atomic"
 	"time"
 
@@ -57,8 +58,18 @@ func (p *proxyStorageState) Ready() {
 // Cancel this state
 func (p *proxyStorageState) Cancel(n *proxyStorageState) {
 	if p.sgs != nil {
+		names := make(map[string]struct{})
+		if n != nil {
+			for _, sg := range n.sgs {
+				names[sg.Cfg.Name] = struct{}{}
+			}
+		}
 		for _, sg := range p.sgs {
 			sg.Cancel()
+			// Servergroups that were removed (or renamed) shouldn't be in metrics anymore
+			if _, ok := names[sg.Cfg.Name]; !ok {
+				servergroup.DeleteMetrics(sg.Cfg.Name)
+			}
 		}
 	}
 	// We call close if the new one is nil, or if the appanders don't match
@@ -95,11 +106,24 @@ func (p *ProxyStorage) ApplyConfig(c *proxyconfig.Config) error {
 
 	failed := false
 
-	apis := make([]promclient.API, len(c.ServerGroups))
+	apis := make([]promclient.API, 0, len(c.ServerGroups))
 	newState := &proxyStorageState{
 		sgs: make([]*servergroup.ServerGroup, len(c.ServerGroups)),
 		cfg: &c.PromxyConfig,
 	}
+	// Servergroups in the same replica_group are replicas of one another, so they are
+	// merged together (as the hosts within a servergroup are) and only need one success
+	replicaGroups := make(map[string][]promclient.API)
+	replicaGroupCfgs := make(map[string]*servergroup.Config)
+	var replicaGroupOrder []string
+	// Servergroups in the same tier_group hold the same data over different time ranges, so
+	// each part of a query's time range is answered by one of them
+	type tierGroupMember struct {
+		sg  *servergroup.ServerGroup
+		cfg *servergroup.Config
+	}
+	tierGroups := make(map[string][]tierGroupMember)
+	var tierGroupOrder []string
 	for i, sgCfg := range c.ServerGroups {
 		tmp := servergroup.New()
 		if err := tmp.ApplyConfig(sgCfg); err != nil {
@@ -107,9 +131,50 @@ func (p *ProxyStorage) ApplyConfig(c *proxyconfig.Config) error {
 			logrus.Errorf("Error applying config to server group: %s", err)
 		}
 		newState.sgs[i] = tmp
-		apis[i] = tmp
+		if sgCfg.TierGroup != "" {
+			if _, ok := tierGroups[sgCfg.TierGroup]; !ok {
+				tierGroupOrder = append(tierGroupOrder, sgCfg.TierGroup)
+			}
+			tierGroups[sgCfg.TierGroup] = append(tierGroups[sgCfg.TierGroup], tierGroupMember{tmp, sgCfg})
+			continue
+		}
+		if sgCfg.ReplicaGroup == "" {
+			apis = append(apis, tmp)
+			continue
+		}
+		if _, ok := replicaGroups[sgCfg.ReplicaGroup]; !ok {
+			replicaGroupOrder = append(replicaGroupOrder, sgCfg.ReplicaGroup)
+			replicaGroupCfgs[sgCfg.ReplicaGroup] = sgCfg
+		}
+		replicaGroups[sgCfg.ReplicaGroup] = append(replicaGroups[sgCfg.ReplicaGroup], tmp)
+	}
+	for _, name := range replicaGroupOrder {
+		// The merge strategy (and anti-affinity) of the group is that of its first servergroup
+		multiAPI, err := promclient.NewMultiAPIWithStrategy(replicaGroups[name], replicaGroupCfgs[name].GetMergeStrategy(), nil, 1)
+		if err != nil {
+			failed = true
+			logrus.Errorf("Error creating replica group %s: %s", name, err)
+			continue
+		}
+		apis = append(apis, multiAPI)
+	}
+	for _, name := range tierGroupOrder {
+		members := tierGroups[name]
+		sort.SliceStable(members, func(i, j int) bool { return members[i].cfg.TierPriority < members[j].cfg.TierPriority })
+		tiers := make([]promclient.Tier, len(members))
+		for i, member := range members {
+			tiers[i] = promclient.Tier{API: member.sg, Range: member.cfg.TimeRange, Resolution: member.cfg.Resolution}
+		}
+		apis = append(apis, &promclient.TieredAPI{Tiers: tiers})
+	}
+	multiAPI, err := promclient.NewMultiAPI(apis, model.TimeFromUnix(0), nil, c.GetServerGroupMinSuccess(len(apis)))
+	if err != nil {
+		failed = true
+		logrus.Errorf("Error creating server group quorum: %s", err)
+	} else {
+		multiAPI.QuorumWarnings = c.ServerGroupMinSuccess > 0
+		newState.client = promclient.NewTimeTruncate(multiAPI)
 	}
-	newState.client = promclient.NewTimeTruncate(promclient.NewMultiAPI(apis, model.TimeFromUnix(0), nil, len(apis)))
 
 	if failed {
 		newState.Cancel(nil)
@@ -165,6 +230,32 @@ func (p *ProxyStorage) Querier(ctx context.Context, mint, maxt int64) (storage.Q
 	}, nil
 }
 
+// ExternalLabelSets returns the distinct (non-empty) Labels of the servergroups, which are
+// the external labels of the series we return
+func (p *ProxyStorage) ExternalLabelSets() []labels.Labels {
+	state := p.GetState()
+	seen := make(map[model.Fingerprint]struct{}, len(state.sgs))
+	ret := make([]labels.Labels, 0, len(state.sgs))
+	for _, sg := range state.sgs {
+		if len(sg.Cfg.Labels) == 0 {
+			continue
+		}
+		finger := sg.Cfg.Labels.Fingerprint()
+		if _, ok := seen[finger]; ok {
+			continue
+		}
+		seen[finger] = struct{}{}
+
+		lset := make(labels.Labels, 0, len(sg.Cfg.Labels))
+		for k, v := range sg.Cfg.Labels {
+			lset = append(lset, labels.Label{Name: string(k), Value: string(v)})
+		}
+		sort.Sort(lset)
+		ret = append(ret, lset)
+	}
+	return ret
+}
+
 // StartTime returns the oldest timestamp stored in the storage.
 func (p *ProxyStorage) StartTime() (int64, error) {
 	return 0, nil
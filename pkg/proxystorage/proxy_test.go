@@ -0,0 +1,87 @@
+package proxystorage
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	proxyconfig "github.com/jacksontj/promxy/pkg/config"
+	"github.com/jacksontj/promxy/pkg/lookup"
+	"github.com/jacksontj/promxy/pkg/servergroup"
+)
+
+func TestReplicaGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "up.csv")
+	if err := ioutil.WriteFile(path, []byte("job\napi\n"), 0644); err != nil {
+		t.Fatalf("Could not write file: %v", err)
+	}
+
+	// The servergroups serve the same series, but one of them is down
+	newServerGroup := func(name, path, replicaGroup string) *servergroup.Config {
+		cfg := servergroup.DefaultConfig
+		cfg.Name = name
+		cfg.Type = servergroup.TypeFile
+		cfg.Labels = model.LabelSet{"region": "eu"}
+		cfg.ReplicaGroup = replicaGroup
+		cfg.FileConfig = &servergroup.FileConfig{
+			Path:            path,
+			Format:          lookup.FormatCSV,
+			MetricName:      "up",
+			RefreshInterval: time.Minute,
+		}
+		return &cfg
+	}
+	missing := filepath.Join(dir, "missing.csv")
+
+	tests := []struct {
+		name         string
+		replicaGroup string
+		err          bool
+	}{
+		{name: "replica_group", replicaGroup: "main"},
+		// Without the replica_group both servergroups are required
+		{name: "no replica_group", err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ps, err := NewProxyStorage(nil)
+			if err != nil {
+				t.Fatalf("Error creating storage: %v", err)
+			}
+			cfg := &proxyconfig.Config{PromxyConfig: proxyconfig.PromxyConfig{ServerGroups: []*servergroup.Config{
+				newServerGroup("a", path, test.replicaGroup),
+				newServerGroup("b", path, test.replicaGroup),
+				newServerGroup("c", missing, test.replicaGroup),
+			}}}
+			if err := ps.ApplyConfig(cfg); err != nil {
+				t.Fatalf("Error applying config: %v", err)
+			}
+			defer ps.GetState().Cancel(nil)
+
+			matchers := []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "__name__", "up")}
+			v, _, err := ps.GetState().client.GetValue(context.Background(), time.Unix(0, 0), time.Unix(60, 0), matchers)
+			if (err != nil) != test.err {
+				t.Fatalf("mismatch in error expected=%v actual=%v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			// The members' series are merged
+			if matrix := v.(model.Matrix); len(matrix) != 1 || len(matrix[0].Values) != 2 {
+				t.Fatalf("Expected 1 merged series, got %v", matrix)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package querystats
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/jacksontj/promxy/pkg/limits"
+)
+
+// Handler wraps next so that query and query_range calls (to the API served under
+// apiPrefix) that request stats (e.g. `stats=all`) get promxy's own statistics
+// added to the response under `data.stats.promxy` next to the engine's timings
+func Handler(apiPrefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch limits.EndpointForPath(apiPrefix, r.URL.Path) {
+		case limits.EndpointQuery, limits.EndpointQueryRange:
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.FormValue("stats") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		stats := FromContext(r.Context())
+		if stats == nil {
+			stats = &Stats{}
+			r = r.WithContext(NewContext(r.Context(), stats))
+		}
+		// We need to rewrite the response body, so we don't want it compressed
+		r.Header.Del("Accept-Encoding")
+
+		bw := &bufferedWriter{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(bw, r)
+
+		body := bw.buf.Bytes()
+		if bw.status == http.StatusOK {
+			if b, err := injectStats(body, stats.Summary()); err != nil {
+				logrus.Debugf("Unable to add query stats to response: %v", err)
+			} else {
+				body = b
+			}
+		}
+
+		for k, v := range bw.header {
+			w.Header()[k] = v
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(bw.status)
+		w.Write(body)
+	})
+}
+
+// injectStats adds summary as `data.stats.promxy` to the API response in body
+func injectStats(body []byte, summary *Summary) ([]byte, error) {
+	var resp map[string]json.RawMessage
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+	var data map[string]json.RawMessage
+	if err := json.Unmarshal(resp["data"], &data); err != nil {
+		return nil, err
+	}
+	stats := make(map[string]json.RawMessage)
+	if raw, ok := data["stats"]; ok {
+		if err := json.Unmarshal(raw, &stats); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	if stats["promxy"], err = json.Marshal(summary); err != nil {
+		return nil, err
+	}
+	if data["stats"], err = json.Marshal(stats); err != nil {
+		return nil, err
+	}
+	if resp["data"], err = json.Marshal(data); err != nil {
+		return nil, err
+	}
+	return json.Marshal(resp)
+}
+
+// bufferedWriter is an http.ResponseWriter that holds the whole response in memory
+type bufferedWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+}
+
+func (w *bufferedWriter) Header() http.Header { return w.header }
+
+func (w *bufferedWriter) WriteHeader(status int) { w.status = status }
+
+func (w *bufferedWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
@@ -0,0 +1,71 @@
+package querystats
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+func TestHandler(t *testing.T) {
+	h := Handler("/api/v1", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if stats := FromContext(r.Context()); stats != nil {
+			_, call := stats.StartCall(context.TODO(), "sg1", "host1:9090", "query")
+			call.AddResponseBytes(100)
+			call.Finish(model.Vector{&model.Sample{}}, nil)
+			stats.AddMergeTime(time.Second)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[],"stats":{"timings":{"evalTotalTime":1}}}}`))
+	}))
+
+	tests := []struct {
+		url      string
+		hasStats bool
+	}{
+		{url: "/api/v1/query?query=up"},
+		{url: "/api/v1/query?query=up&stats=all", hasStats: true},
+		{url: "/api/v1/query_range?query=up&stats=all", hasStats: true},
+		{url: "/api/v1/series?match[]=up&stats=all"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", test.url, nil))
+
+			var resp struct {
+				Data struct {
+					Stats struct {
+						Timings map[string]float64 `json:"timings"`
+						Promxy  *Summary           `json:"promxy"`
+					} `json:"stats"`
+				} `json:"data"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Error unmarshaling response: %v", err)
+			}
+			if resp.Data.Stats.Timings["evalTotalTime"] != 1 {
+				t.Fatalf("engine stats missing: %s", w.Body.String())
+			}
+			summary := resp.Data.Stats.Promxy
+			if !test.hasStats {
+				if summary != nil {
+					t.Fatalf("unexpected promxy stats: %s", w.Body.String())
+				}
+				return
+			}
+			if summary == nil {
+				t.Fatalf("missing promxy stats: %s", w.Body.String())
+			}
+			sg := summary.ServerGroups["sg1"]
+			if summary.DownstreamCalls != 1 || summary.MergeTime != 1 || sg == nil || sg.ResponseBytes != 100 || sg.Samples != 1 {
+				t.Fatalf("mismatch in promxy stats: %s", w.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package querystats
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// Stats collects information about what promxy did to answer a single query. A
+// Stats is attached to the query's context (see NewContext) and all the layers
+// below record into it as the query is executed.
+type Stats struct {
+	l     sync.Mutex
+	calls []*DownstreamCall
+
+	// mergeTime is the total time (in ns) spent merging downstream results
+	mergeTime int64
+}
+
+// DownstreamCall is a single call promxy made to a downstream API
+type DownstreamCall struct {
+	ServerGroup   string  `json:"servergroup"`
+	Target        string  `json:"target"`
+	API           string  `json:"api"`
+	Duration      float64 `json:"duration"`
+	ResponseBytes int64   `json:"responseBytes"`
+	Series        int     `json:"series"`
+	Samples       int     `json:"samples"`
+	Error         string  `json:"error,omitempty"`
+
+	start time.Time
+	stats *Stats
+}
+
+// AddResponseBytes adds n to the number of bytes read for this call
+func (c *DownstreamCall) AddResponseBytes(n int64) {
+	atomic.AddInt64(&c.ResponseBytes, n)
+}
+
+// Finish records the result of a call that returned v
+func (c *DownstreamCall) Finish(v model.Value, err error) {
+	series, samples := CountValue(v)
+	c.FinishCount(series, samples, err)
+}
+
+// FinishCount records the result of a call that returned the given number of series and samples
+func (c *DownstreamCall) FinishCount(series, samples int, err error) {
+	c.stats.l.Lock()
+	defer c.stats.l.Unlock()
+	c.Duration = time.Since(c.start).Seconds()
+	c.Series, c.Samples = series, samples
+	if err != nil {
+		c.Error = err.Error()
+	}
+}
+
+// StartCall records the start of a downstream call, the returned context must be
+// used for the call so that response sizes can be attributed to it.
+func (s *Stats) StartCall(ctx context.Context, serverGroup, target, api string) (context.Context, *DownstreamCall) {
+	c := &DownstreamCall{
+		ServerGroup: serverGroup,
+		Target:      target,
+		API:         api,
+		start:       time.Now(),
+		stats:       s,
+	}
+	s.l.Lock()
+	s.calls = append(s.calls, c)
+	s.l.Unlock()
+	return context.WithValue(ctx, callKey{}, c), c
+}
+
+// Calls returns a copy of all the downstream calls recorded so far
+func (s *Stats) Calls() []DownstreamCall {
+	s.l.Lock()
+	defer s.l.Unlock()
+	calls := make([]DownstreamCall, len(s.calls))
+	for i, c := range s.calls {
+		calls[i] = DownstreamCall{
+			ServerGroup:   c.ServerGroup,
+			Target:        c.Target,
+			API:           c.API,
+			Duration:      c.Duration,
+			ResponseBytes: atomic.LoadInt64(&c.ResponseBytes),
+			Series:        c.Series,
+			Samples:       c.Samples,
+			Error:         c.Error,
+		}
+	}
+	return calls
+}
+
+// ByServerGroup returns all the downstream calls recorded so far grouped by servergroup
+func (s *Stats) ByServerGroup() map[string][]DownstreamCall {
+	ret := make(map[string][]DownstreamCall)
+	for _, c := range s.Calls() {
+		ret[c.ServerGroup] = append(ret[c.ServerGroup], c)
+	}
+	return ret
+}
+
+// Samples returns the total number of samples received from downstreams
+func (s *Stats) Samples() int {
+	var n int
+	for _, c := range s.Calls() {
+		n += c.Samples
+	}
+	return n
+}
+
+// AddMergeTime adds d to the time spent merging downstream results
+func (s *Stats) AddMergeTime(d time.Duration) {
+	atomic.AddInt64(&s.mergeTime, int64(d))
+}
+
+// MergeTime returns the total time spent merging downstream results
+func (s *Stats) MergeTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.mergeTime))
+}
+
+// ServerGroupSummary is the total of all downstream calls to a single servergroup
+type ServerGroupSummary struct {
+	Calls         int     `json:"calls"`
+	Errors        int     `json:"errors"`
+	Duration      float64 `json:"duration"`
+	ResponseBytes int64   `json:"responseBytes"`
+	Series        int     `json:"series"`
+	Samples       int     `json:"samples"`
+}
+
+// Summary is the total of all downstream calls made for a query
+type Summary struct {
+	DownstreamCalls int                            `json:"downstreamCalls"`
+	ResponseBytes   int64                          `json:"responseBytes"`
+	Series          int                            `json:"series"`
+	Samples         int                            `json:"samples"`
+	MergeTime       float64                        `json:"mergeTime"`
+	ServerGroups    map[string]*ServerGroupSummary `json:"servergroups"`
+}
+
+// Summary returns the totals of all the downstream calls recorded so far
+func (s *Stats) Summary() *Summary {
+	summary := &Summary{
+		MergeTime:    s.MergeTime().Seconds(),
+		ServerGroups: make(map[string]*ServerGroupSummary),
+	}
+	for _, c := range s.Calls() {
+		sg, ok := summary.ServerGroups[c.ServerGroup]
+		if !ok {
+			sg = &ServerGroupSummary{}
+			summary.ServerGroups[c.ServerGroup] = sg
+		}
+		sg.Calls++
+		if c.Error != "" {
+			sg.Errors++
+		}
+		sg.Duration += c.Duration
+		sg.ResponseBytes += c.ResponseBytes
+		sg.Series += c.Series
+		sg.Samples += c.Samples
+
+		summary.DownstreamCalls++
+		summary.ResponseBytes += c.ResponseBytes
+		summary.Series += c.Series
+		summary.Samples += c.Samples
+	}
+	return summary
+}
+
+// CountValue returns the number of series and samples in v
+func CountValue(v model.Value) (series, samples int) {
+	switch vTyped := v.(type) {
+	case *model.Scalar, *model.String:
+		return 0, 1
+	case model.Vector:
+		return len(vTyped), len(vTyped)
+	case model.Matrix:
+		for _, stream := range vTyped {
+			samples += len(stream.Values)
+		}
+		return len(vTyped), samples
+	}
+	return 0, 0
+}
+
+type statsKey struct{}
+type callKey struct{}
+
+// NewContext returns a copy of ctx that carries s
+func NewContext(ctx context.Context, s *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, s)
+}
+
+// FromContext returns the Stats in ctx, or nil if there isn't one
+func FromContext(ctx context.Context) *Stats {
+	s, _ := ctx.Value(statsKey{}).(*Stats)
+	return s
+}
+
+// callFromContext returns the DownstreamCall in ctx, or nil if there isn't one
+func callFromContext(ctx context.Context) *DownstreamCall {
+	c, _ := ctx.Value(callKey{}).(*DownstreamCall)
+	return c
+}
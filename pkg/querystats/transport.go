@@ -0,0 +1,39 @@
+package querystats
+
+import (
+	"io"
+	"net/http"
+)
+
+// NewRoundTripper returns a RoundTripper which attributes the size of response
+// bodies to the DownstreamCall in the request's context (if any)
+func NewRoundTripper(rt http.RoundTripper) http.RoundTripper {
+	return &roundTripper{rt}
+}
+
+type roundTripper struct {
+	rt http.RoundTripper
+}
+
+func (r *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.rt.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	if c := callFromContext(req.Context()); c != nil {
+		resp.Body = &countingReadCloser{ReadCloser: resp.Body, c: c}
+	}
+	return resp, nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	c *DownstreamCall
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.c.AddResponseBytes(int64(n))
+	return n, err
+}
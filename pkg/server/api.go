@@ -83,6 +83,12 @@ func createAndStartHTTPS(srv *http.Server, tlsConfigFile string) (*http.Server,
 	return srv, nil
 }
 
+// TLSConfig returns the TLS config of tlsConfigFile (the --web.config.file), so that other
+// servers (such as the StoreAPI) can be served with the same TLS as the HTTP server
+func TLSConfig(tlsConfigFile string) (*tls.Config, error) {
+	return parseConfigFile(tlsConfigFile)
+}
+
 func parseConfigFile(tlsConfigFile string) (*tls.Config, error) {
 	content, err := ioutil.ReadFile(tlsConfigFile)
 	if err != nil {
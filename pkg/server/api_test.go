@@ -178,3 +178,17 @@ func setupAuthenticatedClient(t *testing.T) *http.Client {
 		},
 	}
 }
+
+func TestTLSConfig(t *testing.T) {
+	tlsConfig, err := TLSConfig("testdata/tls-server-config.yml")
+	if err != nil {
+		t.Fatalf("Error loading TLS config: %v", err)
+	}
+	if tlsConfig.GetCertificate == nil || tlsConfig.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("TLS config doesn't match the file: %+v", tlsConfig)
+	}
+
+	if _, err := TLSConfig("testdata/invalid-tls-server-config.yml"); err == nil {
+		t.Fatalf("Expected an error loading an invalid TLS config")
+	}
+}
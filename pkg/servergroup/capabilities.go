@@ -0,0 +1,87 @@
+package servergroup
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jacksontj/promxy/pkg/capabilities"
+)
+
+// capabilitiesProbeTimeout is how long we wait for the buildinfo of a target
+const capabilitiesProbeTimeout = 5 * time.Second
+
+// targetCapabilities are the PromQL features supported by a target. They are probed in the
+// background, and until the probe succeeds the target is assumed to support everything
+type targetCapabilities struct {
+	client *http.Client
+	u      url.URL
+
+	l       sync.Mutex
+	set     capabilities.Set
+	probed  bool
+	probing bool
+}
+
+// Set returns the features supported by the target (nil, meaning everything, until they are known)
+func (t *targetCapabilities) Set() capabilities.Set {
+	t.l.Lock()
+	defer t.l.Unlock()
+	return t.set
+}
+
+// probe starts probing the capabilities of the target (unless they are known or being probed)
+func (t *targetCapabilities) probe(ctx context.Context) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	if t.probed || t.probing {
+		return
+	}
+	t.probing = true
+
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, capabilitiesProbeTimeout)
+		defer cancel()
+		set, err := capabilities.Probe(ctx, t.client, t.u)
+
+		t.l.Lock()
+		defer t.l.Unlock()
+		t.probing = false
+		if err != nil {
+			// We probe again on the next sync
+			logrus.Debugf("Error probing capabilities of %s, assuming it supports everything: %v", t.u.Host, err)
+			return
+		}
+		t.set, t.probed = set, true
+	}()
+}
+
+// targetCapabilities returns the capabilities of the target at u (reusing the existing ones, if any)
+// and starts probing them if they aren't known yet. The capabilities are added to caps
+func (s *ServerGroup) targetCapabilities(u url.URL, caps map[string]*targetCapabilities) *targetCapabilities {
+	t, ok := s.capabilities[u.Host]
+	if !ok {
+		t = &targetCapabilities{client: s.client, u: u}
+	}
+	t.probe(s.ctx)
+	caps[u.Host] = t
+	return t
+}
+
+// supportedByAll returns whether all of targets support the features used by an expression. As the
+// capabilities are checked for each query, the results of probes are used as soon as they arrive
+func supportedByAll(targets []*targetCapabilities) func(parser.Expr) bool {
+	return func(expr parser.Expr) bool {
+		for _, t := range targets {
+			if !t.Set().Supports(expr) {
+				return false
+			}
+		}
+		return true
+	}
+}
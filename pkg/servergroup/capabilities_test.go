@@ -0,0 +1,50 @@
+package servergroup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/promql/parser"
+)
+
+func TestTargetCapabilities(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Write([]byte(`{"status":"success","data":{"version":"2.19.0"}}`))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := &ServerGroup{ctx: ctx, client: srv.Client()}
+	caps := make(map[string]*targetCapabilities)
+	supported := supportedByAll([]*targetCapabilities{s.targetCapabilities(*u, caps)})
+	expr, _ := parser.ParseExpr(`group(foo)`)
+
+	// Until the probe returns the host is assumed to support everything
+	if !supported(expr) {
+		t.Fatalf("Expected the query to be supported before the probe returned")
+	}
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for supported(expr) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Probe result wasn't used")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The capabilities are reused (without probing again) on the next sync
+	s.capabilities = caps
+	next := make(map[string]*targetCapabilities)
+	if s.targetCapabilities(*u, next) != caps[u.Host] {
+		t.Fatalf("Expected the capabilities of the target to be reused")
+	}
+}
@@ -2,6 +2,7 @@ package servergroup
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	config_util "github.com/prometheus/common/config"
@@ -9,15 +10,21 @@ import (
 
 	"github.com/prometheus/prometheus/discovery"
 	"github.com/prometheus/prometheus/pkg/relabel"
+
+	"github.com/jacksontj/promxy/pkg/lookup"
+	"github.com/jacksontj/promxy/pkg/promhttputil"
 )
 
 var (
 	// DefaultConfig is the Default base promxy configuration
 	DefaultConfig = Config{
-		AntiAffinity:   time.Second * 10,
-		Scheme:         "http",
-		RemoteReadPath: "api/v1/read",
-		Timeout:        0,
+		AntiAffinity:      AntiAffinity{Duration: time.Second * 10},
+		MinSuccess:        1,
+		Pushdown:          true,
+		ProbeCapabilities: true,
+		Scheme:            "http",
+		RemoteReadPath:    "api/v1/read",
+		Timeout:           0,
 		HTTPConfig: HTTPClientConfig{
 			DialTimeout: time.Millisecond * 200, // Default dial timeout of 200ms
 		},
@@ -27,11 +34,41 @@ var (
 const (
 	// PathPrefixLabel is the name of the label that holds the path prefix for a scrape target.
 	PathPrefixLabel = "__path_prefix__"
+	// NameLabel is the name of the virtual label that selects servergroups by name at query time.
+	// It is stripped from the queries sent downstream and never added to the results.
+	NameLabel = "__servergroup__"
+)
+
+// Type is the type of API that the hosts of a servergroup serve
+type Type string
+
+const (
+	// TypePrometheus is the prometheus HTTP API (the default)
+	TypePrometheus Type = "prometheus"
+	// TypeThanosStore is the Thanos StoreAPI (gRPC). This only returns raw data, so
+	// PromQL is evaluated in promxy
+	TypeThanosStore Type = "thanos_store"
+	// TypeRemoteRead is the prometheus remote_read API only (for stores that don't implement
+	// the HTTP API). This only returns raw data, so PromQL is evaluated in promxy
+	TypeRemoteRead Type = "remote_read"
+	// TypeFile is static lookup data loaded from a local file (see FileConfig) instead of hosts
+	TypeFile Type = "file"
 )
 
+// LocalEval returns whether PromQL for servergroups of this type is evaluated in promxy
+// (as the API only returns raw data)
+func (t Type) LocalEval() bool {
+	return t == TypeThanosStore || t == TypeRemoteRead
+}
+
 // Config is the configuration for a ServerGroup that promxy will talk to.
 // This is where the vast majority of options exist.
 type Config struct {
+	// Name is the name of this servergroup, used to identify it in metrics and logs.
+	// If unset the servergroup is named by its index in the server_groups list
+	Name string `yaml:"name"`
+	// Type is the type of API that the hosts in this servergroup serve (defaults to TypePrometheus)
+	Type Type `yaml:"type"`
 	// RemoteRead directs promxy to load RAW data (meaning matrix selectors such as `foo[1h]`)
 	// through the RemoteRead API on prom.
 	// Pros:
@@ -53,6 +90,19 @@ type Config struct {
 	RemoteRead bool `yaml:"remote_read"`
 	// RemoteReadPath sets the remote read path for the hosts in this servergroup
 	RemoteReadPath string `yaml:"remote_read_path"`
+	// RemoteReadLabelsLookback is the time range (before now) of the series that label names and values
+	// are derived from for TypeRemoteRead servergroups (defaults to 1h). As remote read has no label API
+	// every LabelNames and LabelValues call reads all the series of this range
+	RemoteReadLabelsLookback time.Duration `yaml:"remote_read_labels_lookback"`
+	// Pushdown controls whether PromQL (e.g. `rate()` or `sum()` from the NodeReplacer) is sent to the
+	// hosts in this servergroup (defaults to true). If false only raw data is fetched (via GetValue)
+	// and PromQL is evaluated in promxy, for downstreams whose PromQL differs subtly from promxy's
+	// (e.g. older prometheus versions or VictoriaMetrics' MetricsQL)
+	Pushdown bool `yaml:"pushdown"`
+	// ProbeCapabilities controls whether the version of each host is probed (from its buildinfo API) to
+	// evaluate PromQL it doesn't support in promxy (defaults to true). Disable it for downstreams whose
+	// version says nothing about the PromQL they support
+	ProbeCapabilities bool `yaml:"probe_capabilities"`
 	// HTTP client config for promxy to use when connecting to the various server_groups
 	// this is the same config as prometheus
 	HTTPConfig HTTPClientConfig `yaml:"http_client"`
@@ -61,6 +111,10 @@ type Config struct {
 	// Labels is a set of labels that will be added to all metrics retrieved
 	// from this server group
 	Labels model.LabelSet `json:"labels"`
+	// ReplicaLabels are labels which only differ between the replicas of an HA pair (e.g. an
+	// external label `replica`). They are removed from all results (and from matchers sent
+	// downstream) so that the series from the replicas are merged together
+	ReplicaLabels []model.LabelName `yaml:"replica_labels"`
 	// RelabelConfigs are similar in function and identical in configuration as prometheus'
 	// relabel config for scrape jobs. The difference here being that the source labels
 	// you can pull from are from the downstream servergroup target and the labels you are
@@ -106,8 +160,18 @@ type Config struct {
 	// in practice this is actually quite frequent as there are a variety of situations that
 	// cause variable scrape completion time (slow exporter, serial exporter, network latency, etc.)
 	// any one of these can cause the resulting data in prometheus to have the same time but in reality
-	// come from different points in time. Best practice for this value is to set it to your scrape interval
-	AntiAffinity time.Duration `yaml:"anti_affinity,omitempty"`
+	// come from different points in time. Best practice for this value is to set it to your scrape interval.
+	// If the servergroup has series with different scrape intervals this can be set to `auto` (or `auto:<floor>`)
+	// to use the spacing of each series' samples, with the default (or floor) as the minimum
+	AntiAffinity AntiAffinity `yaml:"anti_affinity,omitempty"`
+
+	// Dedup defines how series returned by more than one host in the servergroup are
+	// deduplicated. If unset the series are gap filled (using AntiAffinity)
+	Dedup *DedupConfig `yaml:"dedup"`
+
+	// ConsistencyCheck, if set, compares the overlapping samples of the hosts for a sample
+	// of the merged series and reports how many of them diverge in metrics
+	ConsistencyCheck *ConsistencyCheckConfig `yaml:"consistency_check"`
 
 	// Timeout, if non-zero, specifies the amount of
 	// time to wait for a server's response headers after fully
@@ -115,6 +179,29 @@ type Config struct {
 	// time does not include the time to read the response body.
 	Timeout time.Duration `yaml:"timeout,omitempty"`
 
+	// ReplicaGroup marks servergroups that hold the same data (e.g. old and new deployments
+	// during a migration). Servergroups with the same ReplicaGroup are merged together using
+	// the dedup settings (and anti-affinity) of the first of them, and only one of them needs
+	// to respond successfully
+	ReplicaGroup string `yaml:"replica_group"`
+
+	// TierGroup stitches together servergroups holding the same data over different (overlapping)
+	// time ranges, such as a prometheus with 15d of data and a long-term store. Each part of a
+	// query's time range is answered by exactly one servergroup of the TierGroup: the one with the
+	// lowest TierPriority whose time range (RelativeTimeRangeConfig/AbsoluteTimeRangeConfig) covers it
+	TierGroup string `yaml:"tier_group"`
+	// TierPriority is the preference of this servergroup in its TierGroup (lower is preferred)
+	TierPriority int `yaml:"tier_priority"`
+	// Resolution is the resolution of the (downsampled) data in this servergroup. Range queries
+	// prefer the servergroups of the TierGroup with the coarsest Resolution that is no larger than
+	// their step (falling back to finer ones where it has no data) and don't use coarser ones
+	Resolution time.Duration `yaml:"resolution"`
+
+	// MinSuccess is the number of hosts in the servergroup that must respond successfully
+	// for a query to succeed (defaults to 1). If it is more than 1 and some hosts fail but
+	// MinSuccess is met the query succeeds with a warning
+	MinSuccess int `yaml:"min_success"`
+
 	// IgnoreError will hide all errors from this given servergroup effectively making
 	// the responses from this servergroup "not required" for the result.
 	// Note: this allows you to make the tradeoff between availability of queries and consistency of results
@@ -129,6 +216,14 @@ type Config struct {
 	// An example use-case would be if a specific servergroup was was "deprecated" and wasn't getting
 	// any new data after a specific given point in time
 	AbsoluteTimeRangeConfig *AbsoluteTimeRangeConfig `yaml:"absolute_time_range"`
+
+	// AutoTimeRangeConfig enables discovering the time range of each host's data (instead of
+	// maintaining RelativeTimeRangeConfig/AbsoluteTimeRangeConfig by hand) so that hosts are only
+	// queried for time ranges they have data for
+	AutoTimeRangeConfig *AutoTimeRangeConfig `yaml:"auto_time_range"`
+
+	// FileConfig is the file that a TypeFile servergroup serves its series from
+	FileConfig *FileConfig `yaml:"file"`
 }
 
 // GetScheme returns the scheme for this servergroup
@@ -141,6 +236,28 @@ func (c *Config) GetAntiAffinity() model.Time {
 	return model.TimeFromUnix(int64((c.AntiAffinity).Seconds()))
 }
 
+// LocalEval returns whether PromQL for this servergroup is evaluated in promxy (instead of being
+// pushed down to the hosts)
+func (c *Config) LocalEval() bool {
+	return c.Type.LocalEval() || !c.Pushdown
+}
+
+// GetMinSuccess returns the number of hosts that must respond successfully
+func (c *Config) GetMinSuccess() int {
+	if c.MinSuccess < 1 {
+		return 1
+	}
+	return c.MinSuccess
+}
+
+// GetMergeStrategy returns the MergeStrategy to dedupe series from hosts in this servergroup
+func (c *Config) GetMergeStrategy() promhttputil.MergeStrategy {
+	if c.Dedup == nil {
+		return c.AntiAffinity.gapFillStrategy(false)
+	}
+	return c.Dedup.MergeStrategy(c.AntiAffinity)
+}
+
 // UnmarshalYAML implements the yaml.Unmarshaler interface.
 func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	*c = DefaultConfig
@@ -149,9 +266,147 @@ func (c *Config) UnmarshalYAML(unmarshal func(interface{}) error) error {
 		return err
 	}
 
+	if c.MinSuccess < 1 {
+		return fmt.Errorf("min_success must be at least 1, got %d", c.MinSuccess)
+	}
+
+	switch c.Type {
+	case "", TypePrometheus, TypeThanosStore, TypeRemoteRead, TypeFile:
+	default:
+		return fmt.Errorf("unknown servergroup type %q", c.Type)
+	}
+
+	if c.Type == TypeFile {
+		if c.FileConfig == nil {
+			return fmt.Errorf("file servergroups require a file config")
+		}
+		if len(c.ServiceDiscoveryConfigs) > 0 {
+			return fmt.Errorf("file servergroups don't have hosts to discover")
+		}
+	} else if c.FileConfig != nil {
+		return fmt.Errorf("file config is only supported for file servergroups")
+	}
+
+	if c.TierGroup != "" && c.ReplicaGroup != "" {
+		return fmt.Errorf("a servergroup can't be in both a tier_group and a replica_group")
+	}
+
+	if c.Resolution < 0 {
+		return fmt.Errorf("resolution must not be negative, got %v", c.Resolution)
+	}
+	if c.Resolution > 0 && c.TierGroup == "" {
+		return fmt.Errorf("resolution requires a tier_group")
+	}
+
+	if c.RemoteReadLabelsLookback < 0 {
+		return fmt.Errorf("remote_read_labels_lookback must not be negative, got %v", c.RemoteReadLabelsLookback)
+	}
+
+	if c.AutoTimeRangeConfig != nil && c.Type != "" && c.Type != TypePrometheus {
+		return fmt.Errorf("auto_time_range is only supported for prometheus servergroups")
+	}
+
+	return nil
+}
+
+// TimeRange returns the time range (as of now) that this servergroup has data for, from its
+// RelativeTimeRangeConfig and AbsoluteTimeRangeConfig (a zero time is unbounded)
+func (c *Config) TimeRange() (time.Time, time.Time) {
+	var start, end time.Time
+	if c.AbsoluteTimeRangeConfig != nil {
+		start, end = c.AbsoluteTimeRangeConfig.Start, c.AbsoluteTimeRangeConfig.End
+	}
+	if c.RelativeTimeRangeConfig != nil {
+		now := time.Now()
+		if c.RelativeTimeRangeConfig.Start != nil {
+			if relStart := now.Add(*c.RelativeTimeRangeConfig.Start); start.IsZero() || relStart.After(start) {
+				start = relStart
+			}
+		}
+		if c.RelativeTimeRangeConfig.End != nil {
+			if relEnd := now.Add(*c.RelativeTimeRangeConfig.End); end.IsZero() || relEnd.Before(end) {
+				end = relEnd
+			}
+		}
+	}
+	return start, end
+}
+
+// GetConsistencyCheck returns the promhttputil.ConsistencyCheck for this servergroup (if any).
+// Samples within AntiAffinity (as derived for each series in auto mode) of one another are compared
+func (c *Config) GetConsistencyCheck() *promhttputil.ConsistencyCheck {
+	if c.ConsistencyCheck == nil {
+		return nil
+	}
+	return &promhttputil.ConsistencyCheck{
+		SampleRate: c.ConsistencyCheck.SampleRate,
+		Tolerance:  c.ConsistencyCheck.Tolerance,
+		Window:     c.GetAntiAffinity(),
+		AutoWindow: c.AntiAffinity.Auto,
+	}
+}
+
+// AntiAffinity is the anti_affinity of a servergroup, which is either a duration or `auto`. In auto
+// mode the anti-affinity of each series is the median spacing of its samples, with Duration as the floor.
+// `auto:<duration>` sets the floor, otherwise it is the default
+type AntiAffinity struct {
+	time.Duration
+	Auto bool
+}
+
+// antiAffinityAuto is the value (and prefix, when a floor is given) of auto anti-affinity
+const antiAffinityAuto = "auto"
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (a *AntiAffinity) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var d time.Duration
+	if err := unmarshal(&d); err == nil {
+		*a = AntiAffinity{Duration: d}
+		return nil
+	}
+
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	if s == antiAffinityAuto {
+		*a = AntiAffinity{Duration: DefaultConfig.AntiAffinity.Duration, Auto: true}
+		return nil
+	}
+
+	if !strings.HasPrefix(s, antiAffinityAuto+":") {
+		return fmt.Errorf("invalid anti_affinity %q: must be a duration, %q or %q", s, antiAffinityAuto, antiAffinityAuto+":<duration>")
+	}
+	d, err := time.ParseDuration(strings.TrimPrefix(s, antiAffinityAuto+":"))
+	if err != nil {
+		return fmt.Errorf("invalid anti_affinity %q: %v", s, err)
+	}
+	*a = AntiAffinity{Duration: d, Auto: true}
 	return nil
 }
 
+// MarshalYAML implements the yaml.Marshaler interface.
+func (a AntiAffinity) MarshalYAML() (interface{}, error) {
+	if a.Auto {
+		return antiAffinityAuto + ":" + a.Duration.String(), nil
+	}
+	return a.Duration.String(), nil
+}
+
+// gapFillStrategy returns the gap fill promhttputil.MergeStrategy with this anti-affinity
+func (a AntiAffinity) gapFillStrategy(counterAware bool) promhttputil.MergeStrategy {
+	antiAffinity := model.TimeFromUnix(int64(a.Seconds()))
+	switch {
+	case a.Auto:
+		return promhttputil.NewAutoGapFillStrategy(antiAffinity, counterAware)
+	case counterAware:
+		return promhttputil.NewCounterGapFillStrategy(antiAffinity)
+	default:
+		return promhttputil.NewGapFillStrategy(antiAffinity)
+	}
+}
+
 // HTTPClientConfig extends prometheus' HTTPClientConfig
 type HTTPClientConfig struct {
 	DialTimeout time.Duration                `yaml:"dial_timeout"`
@@ -206,3 +461,185 @@ func (tr *AbsoluteTimeRangeConfig) validate() error {
 	}
 	return nil
 }
+
+// DefaultAutoTimeRangeRefreshInterval is the default AutoTimeRangeConfig.RefreshInterval
+const DefaultAutoTimeRangeRefreshInterval = 5 * time.Minute
+
+// AutoTimeRangeConfig configures the discovery of each host's time range. The time range is read
+// from the prometheus_tsdb_lowest_timestamp and prometheus_tsdb_head_max_time metrics on the host's
+// own /metrics endpoint; hosts that are still receiving data have no end
+type AutoTimeRangeConfig struct {
+	// RefreshInterval is how often the time range is refreshed
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (tr *AutoTimeRangeConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*tr = AutoTimeRangeConfig{RefreshInterval: DefaultAutoTimeRangeRefreshInterval}
+	type plain AutoTimeRangeConfig
+	if err := unmarshal((*plain)(tr)); err != nil {
+		return err
+	}
+
+	if tr.RefreshInterval <= 0 {
+		return fmt.Errorf("AutoTimeRangeConfig: refresh_interval must be positive")
+	}
+	return nil
+}
+
+// DefaultFileRefreshInterval is the default FileConfig.RefreshInterval
+const DefaultFileRefreshInterval = 30 * time.Second
+
+// FileConfig configures the file of a TypeFile servergroup, which serves the (info-style)
+// series in the file with a constant value for all time
+type FileConfig struct {
+	// Path is the path of the file
+	Path string `yaml:"path"`
+	// Format is the format of the file (yaml, csv or openmetrics, see lookup.Format).
+	// If unset it is determined by the extension of Path
+	Format lookup.Format `yaml:"format"`
+	// MetricName is the metric name of the series in the file that don't have one
+	// (e.g. a CSV without a __name__ column)
+	MetricName string `yaml:"metric_name"`
+	// RefreshInterval is how often the file is checked for changes
+	RefreshInterval time.Duration `yaml:"refresh_interval"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (f *FileConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	*f = FileConfig{RefreshInterval: DefaultFileRefreshInterval}
+	type plain FileConfig
+	if err := unmarshal((*plain)(f)); err != nil {
+		return err
+	}
+
+	if f.Path == "" {
+		return fmt.Errorf("FileConfig: path is required")
+	}
+	if f.Format == "" {
+		format, err := lookup.FormatFromPath(f.Path)
+		if err != nil {
+			return fmt.Errorf("FileConfig: %v, set the format", err)
+		}
+		f.Format = format
+	} else if err := f.Format.Validate(); err != nil {
+		return fmt.Errorf("FileConfig: %v", err)
+	}
+	if f.MetricName != "" && !model.IsValidMetricName(model.LabelValue(f.MetricName)) {
+		return fmt.Errorf("FileConfig: invalid metric_name %q", f.MetricName)
+	}
+	if f.RefreshInterval <= 0 {
+		return fmt.Errorf("FileConfig: refresh_interval must be positive")
+	}
+	return nil
+}
+
+// DedupStrategy is the name of a strategy to dedupe series from multiple hosts
+type DedupStrategy string
+
+const (
+	// DedupGapFill uses the series with the most points and fills in any gaps from the others
+	DedupGapFill DedupStrategy = "gap_fill"
+	// DedupPenalty picks points from one host until it has a gap, penalizing the others (as thanos does)
+	DedupPenalty DedupStrategy = "penalty"
+	// DedupSticky uses the series from a single host (the one with the most points) for the whole range
+	DedupSticky DedupStrategy = "sticky"
+	// DedupAggregate aggregates the values from all hosts at the same timestamp
+	DedupAggregate DedupStrategy = "aggregate"
+)
+
+// DedupConfig configures how series from multiple hosts are deduplicated
+type DedupConfig struct {
+	Strategy DedupStrategy `yaml:"strategy"`
+	// Aggregate is the aggregation (max, min, avg) used by the aggregate strategy
+	Aggregate promhttputil.AggregateFunc `yaml:"aggregate"`
+	// CounterResetAware makes the gap_fill strategy offset the points it fills into
+	// counters (series whose name ends in `_total`) so that they don't appear to reset
+	CounterResetAware bool `yaml:"counter_reset_aware"`
+	// HistogramConsistent merges all the buckets of a histogram together so that the buckets
+	// at any given time all come from the same host
+	HistogramConsistent bool `yaml:"histogram_consistent"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (d *DedupConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain DedupConfig
+	if err := unmarshal((*plain)(d)); err != nil {
+		return err
+	}
+
+	return d.validate()
+}
+
+func (d *DedupConfig) validate() error {
+	if d.Strategy == "" {
+		d.Strategy = DedupGapFill
+	}
+
+	if d.CounterResetAware && d.Strategy != DedupGapFill {
+		return fmt.Errorf("DedupConfig: counter_reset_aware is only valid for the %s strategy", DedupGapFill)
+	}
+
+	switch d.Strategy {
+	case DedupGapFill, DedupPenalty, DedupSticky:
+		if d.Aggregate != "" {
+			return fmt.Errorf("DedupConfig: aggregate is only valid for the %s strategy", DedupAggregate)
+		}
+	case DedupAggregate:
+		if _, err := promhttputil.NewAggregateStrategy(d.Aggregate); err != nil {
+			return fmt.Errorf("DedupConfig: %v", err)
+		}
+	default:
+		return fmt.Errorf("DedupConfig: unknown strategy %q", d.Strategy)
+	}
+	return nil
+}
+
+// MergeStrategy returns the promhttputil.MergeStrategy for this config
+func (d *DedupConfig) MergeStrategy(antiAffinity AntiAffinity) promhttputil.MergeStrategy {
+	var strategy promhttputil.MergeStrategy
+	switch d.Strategy {
+	case DedupPenalty:
+		strategy = promhttputil.NewPenaltyStrategy()
+	case DedupSticky:
+		strategy = promhttputil.NewStickyStrategy()
+	case DedupAggregate:
+		// The function is checked in validate()
+		strategy, _ = promhttputil.NewAggregateStrategy(d.Aggregate)
+	default:
+		strategy = antiAffinity.gapFillStrategy(d.CounterResetAware)
+	}
+
+	if d.HistogramConsistent {
+		strategy = promhttputil.NewHistogramStrategy(strategy)
+	}
+	return strategy
+}
+
+// ConsistencyCheckConfig configures the sampled comparison of hosts' samples when merging
+type ConsistencyCheckConfig struct {
+	// SampleRate is the fraction (0-1] of merged series to check
+	SampleRate float64 `yaml:"sample_rate"`
+	// Tolerance is the relative difference (e.g. 0.01 for 1%) beyond which values diverge
+	Tolerance float64 `yaml:"tolerance"`
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *ConsistencyCheckConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	type plain ConsistencyCheckConfig
+	if err := unmarshal((*plain)(c)); err != nil {
+		return err
+	}
+
+	return c.validate()
+}
+
+func (c *ConsistencyCheckConfig) validate() error {
+	if c.SampleRate <= 0 || c.SampleRate > 1 {
+		return fmt.Errorf("ConsistencyCheckConfig: sample_rate must be in (0, 1], got %v", c.SampleRate)
+	}
+	if c.Tolerance < 0 {
+		return fmt.Errorf("ConsistencyCheckConfig: tolerance must not be negative, got %v", c.Tolerance)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package servergroup
+
+import (
+	"bytes"
+	"io/ioutil"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/sirupsen/logrus"
+
+	"github.com/jacksontj/promxy/pkg/lookup"
+	"github.com/jacksontj/promxy/pkg/promclient"
+)
+
+// watchFile loads the file of a TypeFile servergroup and reloads it whenever its content
+// changes (checking every RefreshInterval) until the servergroup is cancelled. If the file
+// can't be loaded the last good version of it is kept
+func (s *ServerGroup) watchFile(cfg *FileConfig) {
+	ticker := time.NewTicker(cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	var last []byte
+	loaded := false
+	for {
+		b, err := ioutil.ReadFile(cfg.Path)
+		if err == nil && (!loaded || !bytes.Equal(b, last)) {
+			var series []lookup.Series
+			if series, err = lookup.Parse(b, cfg.Format, cfg.MetricName); err == nil {
+				logrus.Infof("Loaded %d series from %s for servergroup %s", len(series), cfg.Path, s.Cfg.Name)
+				s.setFileState(cfg, lookup.NewAPI(series))
+				last, loaded = b, true
+			}
+		}
+		if err != nil {
+			logrus.Errorf("Error loading file %s for servergroup %s: %v", cfg.Path, s.Cfg.Name, err)
+			// Without any data all calls fail (like a servergroup without enough targets)
+			if !loaded {
+				s.setFileState(cfg, &promclient.ErrorAPI{Err: err})
+			}
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// setFileState makes apiClient (serving the file of cfg) the current state
+func (s *ServerGroup) setFileState(cfg *FileConfig, apiClient promclient.API) {
+	apiClient = s.timeRangeFilter(apiClient)
+	apiClient = &promclient.AddLabelClient{API: apiClient, Labels: s.Cfg.Labels}
+	apiClient = &promclient.SourceLabelClient{API: apiClient, Source: model.LabelValue(s.Cfg.Name + "/" + cfg.Path)}
+	s.setState(&ServerGroupState{Targets: []string{cfg.Path}, apiClient: apiClient})
+}
@@ -0,0 +1,77 @@
+package servergroup
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jacksontj/promxy/pkg/lookup"
+)
+
+func TestFileServerGroup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Could not create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "owners.csv")
+	write := func(content string) {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Could not write file: %v", err)
+		}
+	}
+	write("service,team\napi,core\n")
+
+	cfg := DefaultConfig
+	cfg.Name = "owners"
+	cfg.Type = TypeFile
+	cfg.Labels = model.LabelSet{"region": "eu"}
+	cfg.FileConfig = &FileConfig{
+		Path:            path,
+		Format:          lookup.FormatCSV,
+		MetricName:      "service_owner_info",
+		RefreshInterval: 10 * time.Millisecond,
+	}
+
+	sg := New()
+	defer sg.Cancel()
+	if err := sg.ApplyConfig(&cfg); err != nil {
+		t.Fatalf("Error applying config: %v", err)
+	}
+	<-sg.Ready
+
+	series := func() []model.LabelSet {
+		v, _, err := sg.Series(context.Background(), []string{"service_owner_info"}, time.Unix(0, 0), time.Now())
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return v
+	}
+	expected := []model.LabelSet{{"__name__": "service_owner_info", "service": "api", "team": "core", "region": "eu"}}
+	if v := series(); !reflect.DeepEqual(v, expected) {
+		t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+	}
+
+	// Changes to the file are picked up
+	write("service,team\napi,core\nweb,frontend\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for len(series()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("File wasn't reloaded: %v", series())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// An invalid file doesn't replace the last good one
+	write("service,team\napi\n")
+	time.Sleep(50 * time.Millisecond)
+	if v := series(); len(v) != 2 {
+		t.Fatalf("Expected the last good file to be served, got %v", v)
+	}
+}
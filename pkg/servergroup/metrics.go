@@ -0,0 +1,277 @@
+package servergroup
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+
+	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/jacksontj/promxy/pkg/querystats"
+)
+
+var (
+	serverGroupRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "promxy_servergroup_request_duration_seconds",
+		Help:    "Histogram of calls to servergroup instances",
+		Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"servergroup", "call", "status"})
+	serverGroupInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "promxy_servergroup_requests_in_flight",
+		Help: "Number of calls to servergroup instances currently in flight",
+	}, []string{"servergroup"})
+	serverGroupErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_request_errors_total",
+		Help: "Count of failed calls to servergroup instances by type of error",
+	}, []string{"servergroup", "call", "type"})
+	serverGroupResponseBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_response_bytes_total",
+		Help: "Count of bytes received from servergroup instances",
+	}, []string{"servergroup"})
+	serverGroupSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_series_total",
+		Help: "Count of series returned by servergroup instances",
+	}, []string{"servergroup", "call"})
+	serverGroupSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_samples_total",
+		Help: "Count of samples returned by servergroup instances",
+	}, []string{"servergroup", "call"})
+	serverGroupTargets = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "promxy_servergroup_targets",
+		Help: "Number of targets discovered for a servergroup",
+	}, []string{"servergroup"})
+	serverGroupMergedSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_merged_series_total",
+		Help: "Count of series returned by more than one servergroup instance which were merged",
+	}, []string{"servergroup"})
+	serverGroupGapFilledSeries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_gap_filled_series_total",
+		Help: "Count of merged series which had samples filled in from a secondary servergroup instance",
+	}, []string{"servergroup"})
+	serverGroupGapFilledSamples = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_gap_filled_samples_total",
+		Help: "Count of samples filled in from secondary servergroup instances when merging",
+	}, []string{"servergroup"})
+	serverGroupConsistencyCompared = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_consistency_compared_samples_total",
+		Help: "Count of overlapping samples compared between servergroup instances by the consistency check",
+	}, []string{"servergroup"})
+	serverGroupConsistencyDiverged = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "promxy_servergroup_consistency_diverged_samples_total",
+		Help: "Count of compared samples whose values diverged beyond the consistency check's tolerance",
+	}, []string{"servergroup"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		serverGroupRequestDuration,
+		serverGroupInFlight,
+		serverGroupErrors,
+		serverGroupResponseBytes,
+		serverGroupSeries,
+		serverGroupSamples,
+		serverGroupTargets,
+		serverGroupMergedSeries,
+		serverGroupGapFilledSeries,
+		serverGroupGapFilledSamples,
+		serverGroupConsistencyCompared,
+		serverGroupConsistencyDiverged,
+	)
+}
+
+// serverGroupMetrics are all the metrics with a servergroup label
+var serverGroupMetrics = []interface {
+	prometheus.Collector
+	Delete(prometheus.Labels) bool
+}{
+	serverGroupSummary,
+	serverGroupRequestDuration,
+	serverGroupInFlight,
+	serverGroupErrors,
+	serverGroupResponseBytes,
+	serverGroupSeries,
+	serverGroupSamples,
+	serverGroupTargets,
+	serverGroupMergedSeries,
+	serverGroupGapFilledSeries,
+	serverGroupGapFilledSamples,
+	serverGroupConsistencyCompared,
+	serverGroupConsistencyDiverged,
+}
+
+// DeleteMetrics removes all the series of the servergroup from the metrics, for
+// servergroups that have been removed (or renamed)
+func DeleteMetrics(serverGroup string) {
+	for _, vec := range serverGroupMetrics {
+		ch := make(chan prometheus.Metric)
+		go func(c prometheus.Collector) {
+			c.Collect(ch)
+			close(ch)
+		}(vec)
+
+		// The series can't be deleted while we are collecting them
+		var toDelete []prometheus.Labels
+		for m := range ch {
+			var pb dto.Metric
+			if err := m.Write(&pb); err != nil {
+				continue
+			}
+			lbls := make(prometheus.Labels, len(pb.Label))
+			for _, lp := range pb.Label {
+				lbls[lp.GetName()] = lp.GetValue()
+			}
+			if lbls["servergroup"] == serverGroup {
+				toDelete = append(toDelete, lbls)
+			}
+		}
+		for _, lbls := range toDelete {
+			vec.Delete(lbls)
+		}
+	}
+}
+
+// errorType returns a low-cardinality description of err for metrics
+func errorType(err error) string {
+	if apiErr, ok := err.(*v1.Error); ok {
+		return string(apiErr.Type)
+	}
+	switch err {
+	case context.Canceled:
+		return "canceled"
+	case context.DeadlineExceeded:
+		return "timeout"
+	}
+	if netErr, ok := err.(net.Error); ok {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+		return "connection"
+	}
+	return "other"
+}
+
+// metricsAPI records the per-servergroup metrics for all calls to a single target
+type metricsAPI struct {
+	promclient.API
+	serverGroup string
+}
+
+// record is to be called at the start of a call, the returned func must be called with
+// the result of the call
+func (m *metricsAPI) record(call string) func(series, samples int, err error) {
+	inFlight := serverGroupInFlight.WithLabelValues(m.serverGroup)
+	inFlight.Inc()
+	return func(series, samples int, err error) {
+		inFlight.Dec()
+		if err != nil {
+			serverGroupErrors.WithLabelValues(m.serverGroup, call, errorType(err)).Inc()
+			return
+		}
+		serverGroupSeries.WithLabelValues(m.serverGroup, call).Add(float64(series))
+		serverGroupSamples.WithLabelValues(m.serverGroup, call).Add(float64(samples))
+	}
+}
+
+// LabelNames returns all the unique label names present in the block in sorted order.
+func (m *metricsAPI) LabelNames(ctx context.Context) ([]string, v1.Warnings, error) {
+	done := m.record("label_names")
+	v, w, err := m.API.LabelNames(ctx)
+	done(0, 0, err)
+	return v, w, err
+}
+
+// LabelValues performs a query for the values of the given label.
+func (m *metricsAPI) LabelValues(ctx context.Context, label string) (model.LabelValues, v1.Warnings, error) {
+	done := m.record("label_values")
+	v, w, err := m.API.LabelValues(ctx, label)
+	done(0, 0, err)
+	return v, w, err
+}
+
+// Query performs a query for the given time.
+func (m *metricsAPI) Query(ctx context.Context, query string, ts time.Time) (model.Value, v1.Warnings, error) {
+	done := m.record("query")
+	v, w, err := m.API.Query(ctx, query, ts)
+	series, samples := querystats.CountValue(v)
+	done(series, samples, err)
+	return v, w, err
+}
+
+// QueryRange performs a query for the given range.
+func (m *metricsAPI) QueryRange(ctx context.Context, query string, r v1.Range) (model.Value, v1.Warnings, error) {
+	done := m.record("query_range")
+	v, w, err := m.API.QueryRange(ctx, query, r)
+	series, samples := querystats.CountValue(v)
+	done(series, samples, err)
+	return v, w, err
+}
+
+// Series finds series by label matchers.
+func (m *metricsAPI) Series(ctx context.Context, matches []string, startTime, endTime time.Time) ([]model.LabelSet, v1.Warnings, error) {
+	done := m.record("series")
+	v, w, err := m.API.Series(ctx, matches, startTime, endTime)
+	done(len(v), 0, err)
+	return v, w, err
+}
+
+// GetValue loads the raw data for a given set of matchers in the time range
+func (m *metricsAPI) GetValue(ctx context.Context, start, end time.Time, matchers []*labels.Matcher) (model.Value, v1.Warnings, error) {
+	done := m.record("get_value")
+	v, w, err := m.API.GetValue(ctx, start, end, matchers)
+	series, samples := querystats.CountValue(v)
+	done(series, samples, err)
+	return v, w, err
+}
+
+// mergeObserver records the merge metrics of a servergroup
+type mergeObserver struct {
+	serverGroup string
+}
+
+// ObserveFill records a merged series
+func (m *mergeObserver) ObserveFill(filled int) {
+	serverGroupMergedSeries.WithLabelValues(m.serverGroup).Inc()
+	if filled > 0 {
+		serverGroupGapFilledSeries.WithLabelValues(m.serverGroup).Inc()
+		serverGroupGapFilledSamples.WithLabelValues(m.serverGroup).Add(float64(filled))
+	}
+}
+
+// ObserveDivergence records the result of a consistency check
+func (m *mergeObserver) ObserveDivergence(compared, diverged int) {
+	serverGroupConsistencyCompared.WithLabelValues(m.serverGroup).Add(float64(compared))
+	serverGroupConsistencyDiverged.WithLabelValues(m.serverGroup).Add(float64(diverged))
+}
+
+// bytesRoundTripper counts the bytes of all response bodies
+type bytesRoundTripper struct {
+	rt      http.RoundTripper
+	counter prometheus.Counter
+}
+
+func (b *bytesRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := b.rt.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = &countingReadCloser{ReadCloser: resp.Body, counter: b.counter}
+	return resp, nil
+}
+
+type countingReadCloser struct {
+	io.ReadCloser
+	counter prometheus.Counter
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.counter.Add(float64(n))
+	return n, err
+}
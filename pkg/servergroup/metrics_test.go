@@ -0,0 +1,38 @@
+package servergroup
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// countSeries returns the number of series in c
+func countSeries(c prometheus.Collector) int {
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	var n int
+	for range ch {
+		n++
+	}
+	return n
+}
+
+func TestDeleteMetrics(t *testing.T) {
+	serverGroupTargets.WithLabelValues("removed").Set(1)
+	serverGroupTargets.WithLabelValues("kept").Set(1)
+	serverGroupErrors.WithLabelValues("removed", "query", "timeout").Inc()
+	serverGroupErrors.WithLabelValues("removed", "series", "other").Inc()
+	targets, errs := countSeries(serverGroupTargets), countSeries(serverGroupErrors)
+
+	DeleteMetrics("removed")
+
+	if n := countSeries(serverGroupTargets); n != targets-1 {
+		t.Fatalf("Expected %d target series, got %d", targets-1, n)
+	}
+	if n := countSeries(serverGroupErrors); n != errs-2 {
+		t.Fatalf("Expected %d error series, got %d", errs-2, n)
+	}
+}
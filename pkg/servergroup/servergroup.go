@@ -7,6 +7,7 @@ import (
 	"net/url"
 	"path"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -22,17 +23,21 @@ import (
 	"github.com/prometheus/prometheus/pkg/relabel"
 	"github.com/prometheus/prometheus/storage/remote"
 	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
 
+	"github.com/jacksontj/promxy/pkg/localeval"
 	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/jacksontj/promxy/pkg/promhttputil"
+	"github.com/jacksontj/promxy/pkg/querystats"
+	"github.com/jacksontj/promxy/pkg/storepb"
 	//	sd_config "github.com/prometheus/prometheus/discovery/config"
 )
 
 var (
-	// TODO: have a marker for "which" servergroup
 	serverGroupSummary = prometheus.NewSummaryVec(prometheus.SummaryOpts{
 		Name: "server_group_request_duration_seconds",
 		Help: "Summary of calls to servergroup instances",
-	}, []string{"host", "call", "status"})
+	}, []string{"servergroup", "call", "status"})
 )
 
 func init() {
@@ -77,8 +82,8 @@ type ServerGroup struct {
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 
-	loaded bool
-	Ready  chan struct{}
+	readyOnce sync.Once
+	Ready     chan struct{}
 
 	// TODO: lock/atomics on cfg and client
 	Cfg           *Config
@@ -87,6 +92,16 @@ type ServerGroup struct {
 
 	OriginalURLs []string
 
+	// storeConns are the gRPC connections to the targets (for TypeThanosStore), which are
+	// reused across syncs. This is only used by Sync
+	storeConns map[string]*grpc.ClientConn
+	// timeRanges are the auto-discovered time ranges of the targets, which are kept
+	// across syncs. This is only used by Sync
+	timeRanges map[string]*targetTimeRange
+	// capabilities are the probed PromQL capabilities of the targets, which are kept
+	// across syncs. This is only used by Sync
+	capabilities map[string]*targetCapabilities
+
 	state atomic.Value
 }
 
@@ -101,9 +116,18 @@ func (s *ServerGroup) Sync() {
 
 SYNC_LOOP:
 	for targetGroupMap := range syncCh {
+		// File servergroups have no hosts, their state is set by watchFile
+		if s.Cfg.Type == TypeFile {
+			continue
+		}
 		logrus.Debug("Updating targets from discovery manager")
 		targets := make([]string, 0)
 		apiClients := make([]promclient.API, 0)
+		conns := make(map[string]*grpc.ClientConn)
+		timeRanges := make(map[string]*targetTimeRange)
+		caps := make(map[string]*targetCapabilities)
+		// targetCaps are the capabilities of the prometheus hosts
+		var targetCaps []*targetCapabilities
 
 		for _, targetGroupList := range targetGroupMap {
 			for _, targetGroup := range targetGroupList {
@@ -146,52 +170,42 @@ SYNC_LOOP:
 						Path:   lset.Get(PathPrefixLabel),
 					}
 
-					targets = append(targets, u.Host)
-
-					client, err := api.NewClient(api.Config{Address: u.String(), RoundTripper: s.client.Transport})
-					if err != nil {
-						panic(err) // TODO: shouldn't be possible? If this happens I guess we log and skip?
-					}
-
-					if len(s.Cfg.QueryParams) > 0 {
-						client = promclient.NewClientArgsWrap(client, s.Cfg.QueryParams)
-					}
-
 					var apiClient promclient.API
-					apiClient = &promclient.PromAPIV1{v1.NewAPI(client)}
-
-					if s.Cfg.RemoteRead {
-						u.Path = path.Join(u.Path, s.Cfg.RemoteReadPath)
-						cfg := &remote.ClientConfig{
-							URL:              &config_util.URL{u},
-							HTTPClientConfig: s.Cfg.HTTPConfig.HTTPConfig,
-							Timeout:          model.Duration(time.Minute * 2),
-						}
-						remoteStorageClient, err := remote.NewReadClient("foo", cfg)
+					switch s.Cfg.Type {
+					case TypeThanosStore:
+						conn, err := s.dialStore(u.Host, conns)
 						if err != nil {
-							panic(err)
+							logrus.Errorf("Error connecting to store %s: %v", u.Host, err)
+							continue
 						}
-
-						apiClient = &promclient.PromAPIRemoteRead{apiClient, remoteStorageClient}
+						apiClient = &promclient.ThanosStoreAPI{Client: storepb.NewStoreClient(conn)}
+					case TypeRemoteRead:
+						apiClient = &promclient.RemoteReadAPI{ReadClient: s.remoteReadClient(u), LabelsLookback: s.Cfg.RemoteReadLabelsLookback}
+					default:
+						if !s.Cfg.LocalEval() && s.Cfg.ProbeCapabilities {
+							targetCaps = append(targetCaps, s.targetCapabilities(*u, caps))
+						}
+						apiClient = s.prometheusAPI(u)
 					}
 
+					targets = append(targets, u.Host)
+
+					// Record the calls actually made to this target in the stats of the query (if any)
+					apiClient = &promclient.StatsAPI{API: apiClient, ServerGroup: s.Cfg.Name, Target: u.Host}
+					apiClient = &metricsAPI{API: apiClient, serverGroup: s.Cfg.Name}
+
 					// Optionally add time range layers
-					if s.Cfg.AbsoluteTimeRangeConfig != nil {
-						apiClient = &promclient.AbsoluteTimeFilter{
-							API:      apiClient,
-							Start:    s.Cfg.AbsoluteTimeRangeConfig.Start,
-							End:      s.Cfg.AbsoluteTimeRangeConfig.End,
-							Truncate: s.Cfg.AbsoluteTimeRangeConfig.Truncate,
+					apiClient = s.timeRangeFilter(apiClient)
+
+					if s.Cfg.AutoTimeRangeConfig != nil {
+						apiClient = &promclient.DynamicTimeFilter{
+							API:   apiClient,
+							Range: s.targetTimeRange(*u, timeRanges).Range,
 						}
 					}
 
-					if s.Cfg.RelativeTimeRangeConfig != nil {
-						apiClient = &promclient.RelativeTimeFilter{
-							API:      apiClient,
-							Start:    s.Cfg.RelativeTimeRangeConfig.Start,
-							End:      s.Cfg.RelativeTimeRangeConfig.End,
-							Truncate: s.Cfg.RelativeTimeRangeConfig.Truncate,
-						}
+					if len(s.Cfg.ReplicaLabels) > 0 {
+						apiClient = &promclient.ReplicaLabelClient{API: apiClient, Labels: s.Cfg.ReplicaLabels, Strategy: s.Cfg.GetMergeStrategy()}
 					}
 
 					// We remove all private labels after we set the target entry
@@ -205,6 +219,9 @@ SYNC_LOOP:
 					// Add labels
 					apiClient = &promclient.AddLabelClient{apiClient, modelLabelSet.Merge(s.Cfg.Labels)}
 
+					// Mark where series came from (if requested)
+					apiClient = &promclient.SourceLabelClient{API: apiClient, Source: model.LabelValue(s.Cfg.Name + "/" + u.Host)}
+
 					// If debug logging is enabled, wrap the client with a debugAPI client
 					// Since these are called in the reverse order of what we add, we want
 					// to make sure that this is the last wrap of the client
@@ -218,26 +235,115 @@ SYNC_LOOP:
 		}
 
 		apiClientMetricFunc := func(i int, api, status string, took float64) {
-			serverGroupSummary.WithLabelValues(targets[i], api, status).Observe(took)
+			serverGroupSummary.WithLabelValues(s.Cfg.Name, api, status).Observe(took)
+			serverGroupRequestDuration.WithLabelValues(s.Cfg.Name, api, status).Observe(took)
 		}
 
 		logrus.Debugf("Updating targets from discovery manager: %v", targets)
-		newState := &ServerGroupState{
-			Targets:   targets,
-			apiClient: promclient.NewMultiAPI(apiClients, s.Cfg.GetAntiAffinity(), apiClientMetricFunc, 1),
+		serverGroupTargets.WithLabelValues(s.Cfg.Name).Set(float64(len(targets)))
+		newState := &ServerGroupState{Targets: targets}
+		mergeStrategy := promhttputil.NewObservedStrategy(s.Cfg.GetMergeStrategy(), &mergeObserver{s.Cfg.Name}, s.Cfg.GetConsistencyCheck())
+		multiAPI, err := promclient.NewMultiAPIWithStrategy(apiClients, mergeStrategy, apiClientMetricFunc, s.Cfg.GetMinSuccess())
+		if err != nil {
+			// Discovery returned fewer targets than we require, so all calls must fail
+			logrus.Errorf("Error creating client for servergroup %s: %s", s.Cfg.Name, err)
+			newState.apiClient = &promclient.ErrorAPI{Err: err}
+		} else {
+			// Partial failures are only worth a warning if a quorum was asked for
+			multiAPI.QuorumWarnings = s.Cfg.GetMinSuccess() > 1
+			newState.apiClient = multiAPI
 		}
 
-		if s.Cfg.IgnoreError {
-			newState.apiClient = &promclient.IgnoreErrorAPI{newState.apiClient}
+		// If the API only returns raw data (or we don't trust its PromQL) we evaluate PromQL
+		// over the merged data ourselves
+		if s.Cfg.LocalEval() {
+			newState.apiClient = &localeval.API{API: newState.apiClient}
+		} else if len(targetCaps) > 0 {
+			// Only push down the queries that all hosts support, otherwise we fetch the raw data
+			newState.apiClient = &localeval.FallbackAPI{API: newState.apiClient, Supported: supportedByAll(targetCaps)}
 		}
 
-		s.state.Store(newState)
+		s.setState(newState)
+		s.replaceStoreConns(conns)
+		s.timeRanges = timeRanges
+		s.capabilities = caps
+	}
+	s.replaceStoreConns(nil)
+}
+
+// timeRangeFilter wraps apiClient with the time range layers configured for the servergroup (if any)
+func (s *ServerGroup) timeRangeFilter(apiClient promclient.API) promclient.API {
+	if s.Cfg.AbsoluteTimeRangeConfig != nil {
+		apiClient = &promclient.AbsoluteTimeFilter{
+			API:      apiClient,
+			Start:    s.Cfg.AbsoluteTimeRangeConfig.Start,
+			End:      s.Cfg.AbsoluteTimeRangeConfig.End,
+			Truncate: s.Cfg.AbsoluteTimeRangeConfig.Truncate,
+		}
+	}
 
-		if !s.loaded {
-			s.loaded = true
-			close(s.Ready)
+	if s.Cfg.RelativeTimeRangeConfig != nil {
+		apiClient = &promclient.RelativeTimeFilter{
+			API:      apiClient,
+			Start:    s.Cfg.RelativeTimeRangeConfig.Start,
+			End:      s.Cfg.RelativeTimeRangeConfig.End,
+			Truncate: s.Cfg.RelativeTimeRangeConfig.Truncate,
 		}
 	}
+	return apiClient
+}
+
+// setState adds the servergroup-wide layers to the apiClient of state and makes it the current state
+func (s *ServerGroup) setState(state *ServerGroupState) {
+	// Allow the servergroup to be selected by name at query time
+	state.apiClient = &promclient.VirtualLabelClient{
+		API:    state.apiClient,
+		Labels: model.LabelSet{NameLabel: model.LabelValue(s.Cfg.Name)},
+	}
+
+	if s.Cfg.IgnoreError {
+		state.apiClient = &promclient.IgnoreErrorAPI{state.apiClient}
+	}
+
+	s.state.Store(state)
+
+	s.readyOnce.Do(func() { close(s.Ready) })
+}
+
+// prometheusAPI returns the API for the prometheus host at u
+func (s *ServerGroup) prometheusAPI(u *url.URL) promclient.API {
+	client, err := api.NewClient(api.Config{Address: u.String(), RoundTripper: s.client.Transport})
+	if err != nil {
+		panic(err) // TODO: shouldn't be possible? If this happens I guess we log and skip?
+	}
+
+	if len(s.Cfg.QueryParams) > 0 {
+		client = promclient.NewClientArgsWrap(client, s.Cfg.QueryParams)
+	}
+
+	var apiClient promclient.API
+	apiClient = &promclient.PromAPIV1{v1.NewAPI(client)}
+
+	if s.Cfg.RemoteRead {
+		apiClient = &promclient.PromAPIRemoteRead{apiClient, s.remoteReadClient(u)}
+	}
+	return apiClient
+}
+
+// remoteReadClient returns the remote read client for the host at u
+func (s *ServerGroup) remoteReadClient(u *url.URL) remote.ReadClient {
+	readURL := *u
+	readURL.Path = path.Join(readURL.Path, s.Cfg.RemoteReadPath)
+	cfg := &remote.ClientConfig{
+		URL:              &config_util.URL{&readURL},
+		HTTPClientConfig: s.Cfg.HTTPConfig.HTTPConfig,
+		Timeout:          model.Duration(time.Minute * 2),
+	}
+	remoteStorageClient, err := remote.NewReadClient("foo", cfg)
+	if err != nil {
+		panic(err)
+	}
+	return remoteStorageClient
 }
 
 // ApplyConfig applies new configuration to the ServerGroup
@@ -277,11 +383,19 @@ func (s *ServerGroup) ApplyConfig(cfg *Config) error {
 		rt = config_util.NewBasicAuthRoundTripper(cfg.HTTPConfig.HTTPConfig.BasicAuth.Username, cfg.HTTPConfig.HTTPConfig.BasicAuth.Password, cfg.HTTPConfig.HTTPConfig.BasicAuth.PasswordFile, rt)
 	}
 
+	// Attribute response sizes to the downstream calls of queries
+	rt = querystats.NewRoundTripper(rt)
+	rt = &bytesRoundTripper{rt: rt, counter: serverGroupResponseBytes.WithLabelValues(cfg.Name)}
+
 	s.client = &http.Client{Transport: rt}
 
 	if err := s.targetManager.ApplyConfig(map[string]discovery.Configs{"foo": cfg.ServiceDiscoveryConfigs}); err != nil {
 		return err
 	}
+
+	if cfg.Type == TypeFile {
+		go s.watchFile(cfg.FileConfig)
+	}
 	return nil
 }
 
@@ -0,0 +1,108 @@
+package servergroup
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	v1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery"
+)
+
+// recordingPrometheus is a fake prometheus host recording the queries it receives
+type recordingPrometheus struct {
+	*httptest.Server
+
+	l       sync.Mutex
+	queries []string
+}
+
+func newRecordingPrometheus() *recordingPrometheus {
+	p := &recordingPrometheus{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/query" && r.URL.Path != "/api/v1/query_range" {
+			http.NotFound(w, r)
+			return
+		}
+		query := r.FormValue("query")
+		p.l.Lock()
+		p.queries = append(p.queries, query)
+		p.l.Unlock()
+
+		// The raw data of GetValue is fetched with a range selector
+		if strings.HasSuffix(query, "s]") || r.URL.Path == "/api/v1/query_range" {
+			w.Write([]byte(`{"status":"success","data":{"resultType":"matrix","result":[]}}`))
+			return
+		}
+		w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[]}}`))
+	}))
+	return p
+}
+
+func (p *recordingPrometheus) Queries() []string {
+	p.l.Lock()
+	defer p.l.Unlock()
+	return append([]string(nil), p.queries...)
+}
+
+func TestServerGroupPushdown(t *testing.T) {
+	pushdown, local := newRecordingPrometheus(), newRecordingPrometheus()
+	defer pushdown.Close()
+	defer local.Close()
+
+	newServerGroup := func(name string, p *recordingPrometheus, pushdown bool) *ServerGroup {
+		u, _ := url.Parse(p.URL)
+		cfg := DefaultConfig
+		cfg.Name = name
+		cfg.Pushdown = pushdown
+		cfg.ServiceDiscoveryConfigs = discovery.Configs{discovery.StaticConfig{
+			{Targets: []model.LabelSet{{model.AddressLabel: model.LabelValue(u.Host)}}},
+		}}
+		sg := New()
+		if err := sg.ApplyConfig(&cfg); err != nil {
+			t.Fatalf("Error applying config: %v", err)
+		}
+		return sg
+	}
+	groups := []*ServerGroup{newServerGroup("pushdown", pushdown, true), newServerGroup("local", local, false)}
+	for _, sg := range groups {
+		defer sg.Cancel()
+		select {
+		case <-sg.Ready:
+		case <-time.After(30 * time.Second):
+			t.Fatalf("servergroup %s has no targets", sg.Cfg.Name)
+		}
+	}
+
+	ctx := context.Background()
+	now := time.Now()
+	for _, sg := range groups {
+		if _, _, err := sg.Query(ctx, `sum(foo)`, now); err != nil {
+			t.Fatalf("Unexpected error from %s: %v", sg.Cfg.Name, err)
+		}
+		if _, _, err := sg.QueryRange(ctx, `sum(foo)`, v1.Range{Start: now.Add(-time.Hour), End: now, Step: time.Minute}); err != nil {
+			t.Fatalf("Unexpected error from %s: %v", sg.Cfg.Name, err)
+		}
+	}
+
+	// The servergroup with pushdown gets the PromQL as-is
+	if q := pushdown.Queries(); len(q) != 2 || q[0] != `sum(foo)` || q[1] != `sum(foo)` {
+		t.Fatalf("Expected the queries to be pushed down, got %v", q)
+	}
+	// The other only gets the raw data fetches of GetValue, and the PromQL is evaluated in promxy
+	q := local.Queries()
+	if len(q) == 0 {
+		t.Fatalf("Expected the raw data to be fetched")
+	}
+	for _, query := range q {
+		if !strings.HasPrefix(query, `{__name__="foo"}[`) {
+			t.Fatalf("Expected only raw data fetches, got %v", q)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package servergroup
+
+import (
+	"context"
+
+	config_util "github.com/prometheus/common/config"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/jacksontj/promxy/pkg/storepb"
+)
+
+// dialStore returns a connection to the StoreAPI at target, reusing the existing
+// connection (if any). The connection is added to conns
+func (s *ServerGroup) dialStore(target string, conns map[string]*grpc.ClientConn) (*grpc.ClientConn, error) {
+	if conn, ok := s.storeConns[target]; ok {
+		conns[target] = conn
+		return conn, nil
+	}
+
+	opts := []grpc.DialOption{grpc.WithDefaultCallOptions(grpc.ForceCodec(storepb.Codec{}))}
+	if s.Cfg.Scheme == "https" {
+		tlsConfig, err := config_util.NewTLSConfig(&s.Cfg.HTTPConfig.HTTPConfig.TLSConfig)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)))
+	} else {
+		opts = append(opts, grpc.WithInsecure())
+	}
+
+	// The dial timeout only applies to blocking dials. If the target can't be reached in time it
+	// is skipped until the next sync (instead of failing each call to it)
+	ctx := s.ctx
+	if s.Cfg.HTTPConfig.DialTimeout > 0 {
+		opts = append(opts, grpc.WithBlock())
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Cfg.HTTPConfig.DialTimeout)
+		defer cancel()
+	}
+
+	conn, err := grpc.DialContext(ctx, target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	conns[target] = conn
+	return conn, nil
+}
+
+// replaceStoreConns closes the connections that aren't in conns (as their targets
+// are gone) and keeps conns for the next sync
+func (s *ServerGroup) replaceStoreConns(conns map[string]*grpc.ClientConn) {
+	for target, conn := range s.storeConns {
+		if _, ok := conns[target]; !ok {
+			if err := conn.Close(); err != nil {
+				logrus.Debugf("Error closing connection to store %s: %v", target, err)
+			}
+		}
+	}
+	s.storeConns = conns
+}
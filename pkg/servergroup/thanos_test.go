@@ -0,0 +1,53 @@
+package servergroup
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+func TestDialStore(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	// A listener that is closed right away gives us an address nothing is listening on
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	closed.Close()
+
+	cfg := DefaultConfig
+	cfg.HTTPConfig.DialTimeout = 200 * time.Millisecond
+	s := &ServerGroup{ctx: context.Background(), Cfg: &cfg}
+	conns := make(map[string]*grpc.ClientConn)
+
+	conn, err := s.dialStore(lis.Addr().String(), conns)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	defer conn.Close()
+	if conns[lis.Addr().String()] != conn {
+		t.Fatalf("Expected the connection to be kept")
+	}
+
+	// Unreachable stores fail once the dial timeout is up
+	start := time.Now()
+	if _, err := s.dialStore(closed.Addr().String(), conns); err == nil {
+		t.Fatalf("Expected an error dialing an unreachable store")
+	}
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("Dial didn't time out after the dial timeout, took %v", took)
+	}
+	if _, ok := conns[closed.Addr().String()]; ok {
+		t.Fatalf("Expected the unreachable store to not be kept")
+	}
+}
@@ -0,0 +1,139 @@
+package servergroup
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/prometheus/prometheus/pkg/timestamp"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// lowestTimestampMetric is the (ms) timestamp of the oldest data in a prometheus' TSDB
+	lowestTimestampMetric = "prometheus_tsdb_lowest_timestamp"
+	// headMaxTimeMetric is the (ms) timestamp of the newest data in a prometheus' TSDB
+	headMaxTimeMetric = "prometheus_tsdb_head_max_time"
+
+	// timeRangeLiveThreshold is how recent the newest data of a host must be for it to still be
+	// receiving data, in which case the end of its time range is unbounded
+	timeRangeLiveThreshold = 5 * time.Minute
+	// timeRangeProbeTimeout is how long we wait for the metrics of a host
+	timeRangeProbeTimeout = 10 * time.Second
+)
+
+// targetTimeRange is the (auto-discovered) time range of a target's data. The time range
+// is refreshed (in the background) when it is used after refreshInterval
+type targetTimeRange struct {
+	client          *http.Client
+	u               url.URL
+	refreshInterval time.Duration
+
+	l          sync.Mutex
+	start, end time.Time
+	updated    time.Time
+	refreshing bool
+}
+
+// Range returns the time range of the target's data (a zero time is unbounded, e.g. until
+// the time range is known)
+func (t *targetTimeRange) Range() (time.Time, time.Time) {
+	t.l.Lock()
+	defer t.l.Unlock()
+	if !t.refreshing && time.Since(t.updated) >= t.refreshInterval {
+		t.refreshing = true
+		go t.refresh()
+	}
+	return t.start, t.end
+}
+
+func (t *targetTimeRange) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), timeRangeProbeTimeout)
+	defer cancel()
+	start, end, err := probeTimeRange(ctx, t.client, t.u)
+
+	t.l.Lock()
+	defer t.l.Unlock()
+	t.refreshing = false
+	t.updated = time.Now()
+	if err != nil {
+		// We keep the last known time range, as hosts rarely lose data
+		logrus.Debugf("Error discovering time range of %s: %v", t.u.Host, err)
+		return
+	}
+	t.start, t.end = start, end
+}
+
+// targetTimeRange returns the time range of the target at u (reusing the existing one, if any).
+// The time range is added to timeRanges
+func (s *ServerGroup) targetTimeRange(u url.URL, timeRanges map[string]*targetTimeRange) *targetTimeRange {
+	t, ok := s.timeRanges[u.Host]
+	if !ok {
+		t = &targetTimeRange{
+			client:          s.client,
+			u:               u,
+			refreshInterval: s.Cfg.AutoTimeRangeConfig.RefreshInterval,
+		}
+	}
+	timeRanges[u.Host] = t
+	return t
+}
+
+// probeTimeRange returns the time range of the data of the prometheus host at u from the TSDB metrics
+// on its own /metrics endpoint. The end is unbounded if the host is still receiving data
+func probeTimeRange(ctx context.Context, client *http.Client, u url.URL) (time.Time, time.Time, error) {
+	u.Path = path.Join(u.Path, "metrics")
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, time.Time{}, fmt.Errorf("unexpected status code %d from %s", resp.StatusCode, u.String())
+	}
+
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	lowest, ok := gaugeValue(families[lowestTimestampMetric])
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s is missing %s", u.String(), lowestTimestampMetric)
+	}
+	headMax, ok := gaugeValue(families[headMaxTimeMetric])
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s is missing %s", u.String(), headMaxTimeMetric)
+	}
+
+	// An empty TSDB reports the lowest timestamp as MaxInt64 (and the head max time as MinInt64)
+	if lowest >= math.MaxInt64 || headMax <= math.MinInt64 {
+		return time.Time{}, time.Time{}, fmt.Errorf("%s has no data", u.String())
+	}
+
+	start := timestamp.Time(int64(lowest))
+	end := timestamp.Time(int64(headMax))
+	if time.Since(end) < timeRangeLiveThreshold {
+		end = time.Time{}
+	}
+	return start, end, nil
+}
+
+// gaugeValue returns the value of the (single) gauge of family
+func gaugeValue(family *dto.MetricFamily) (float64, bool) {
+	if family == nil || len(family.Metric) != 1 || family.Metric[0].Gauge == nil {
+		return 0, false
+	}
+	return family.Metric[0].Gauge.GetValue(), true
+}
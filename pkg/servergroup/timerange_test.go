@@ -0,0 +1,52 @@
+package servergroup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/prometheus/prometheus/pkg/timestamp"
+)
+
+func TestProbeTimeRange(t *testing.T) {
+	lowest := time.Now().Add(-15 * 24 * time.Hour).Truncate(time.Millisecond)
+	var headMax time.Time
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/prefix/metrics" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %d\n", lowestTimestampMetric, lowestTimestampMetric, timestamp.FromTime(lowest))
+		fmt.Fprintf(w, "# TYPE %s gauge\n%s %d\n", headMaxTimeMetric, headMaxTimeMetric, timestamp.FromTime(headMax))
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL + "/prefix")
+
+	// Hosts that are still receiving data have no end
+	headMax = time.Now().Add(-15 * time.Second).Truncate(time.Millisecond)
+	start, end, err := probeTimeRange(context.Background(), srv.Client(), *u)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !start.Equal(lowest) || !end.IsZero() {
+		t.Fatalf("Unexpected time range %v-%v", start, end)
+	}
+
+	headMax = time.Now().Add(-24 * time.Hour).Truncate(time.Millisecond)
+	start, end, err = probeTimeRange(context.Background(), srv.Client(), *u)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !start.Equal(lowest) || !end.Equal(headMax) {
+		t.Fatalf("Unexpected time range %v-%v", start, end)
+	}
+
+	u.Path = "/other"
+	if _, _, err := probeTimeRange(context.Background(), srv.Client(), *u); err == nil {
+		t.Fatalf("Expected error for host without metrics")
+	}
+}
@@ -0,0 +1,46 @@
+// Package sourcelabel implements the optional debug label that marks which
+// servergroup and target each series (or part of a series) came from
+package sourcelabel
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/jacksontj/promxy/pkg/limits"
+)
+
+// Label is the label added to series with their source ("servergroup/target")
+const Label model.LabelName = "__promxy_source__"
+
+// Param is the request parameter that enables the source label (e.g. `promxy_source=true`)
+const Param = "promxy_source"
+
+type contextKey struct{}
+
+// NewContext returns a context in which the source label is enabled
+func NewContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, contextKey{}, true)
+}
+
+// Enabled returns whether the source label is enabled in ctx
+func Enabled(ctx context.Context) bool {
+	enabled, _ := ctx.Value(contextKey{}).(bool)
+	return enabled
+}
+
+// Handler wraps next so that query, query_range and series calls (to the API served under
+// apiPrefix) with the Param set to a true value have the source label enabled
+func Handler(apiPrefix string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch limits.EndpointForPath(apiPrefix, r.URL.Path) {
+		case limits.EndpointQuery, limits.EndpointQueryRange, limits.EndpointSeries:
+			if enabled, _ := strconv.ParseBool(r.FormValue(Param)); enabled {
+				r = r.WithContext(NewContext(r.Context()))
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,204 @@
+// Package storeapi serves promxy's storage over the Thanos StoreAPI (gRPC), so that a
+// Thanos Querier (or anything else that speaks StoreAPI) can use promxy as a store
+package storeapi
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/storage"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+
+	"github.com/jacksontj/promxy/pkg/storepb"
+)
+
+// maxSamplesPerChunk is the number of samples in each chunk we send (the same as prometheus' TSDB)
+const maxSamplesPerChunk = 120
+
+// NewServer returns a Server for queryable which advertises the external label
+// sets returned by labelSets
+func NewServer(queryable storage.Queryable, labelSets func() []labels.Labels) *Server {
+	return &Server{queryable: queryable, labelSets: labelSets}
+}
+
+// Server implements storepb.StoreServer on top of a storage.Queryable
+type Server struct {
+	queryable storage.Queryable
+	labelSets func() []labels.Labels
+}
+
+// Info returns the external labels of the store. The labels (which are deprecated in
+// the StoreAPI in favor of LabelSets) are the labels shared by all label sets
+func (s *Server) Info(context.Context, *storepb.InfoRequest) (*storepb.InfoResponse, error) {
+	labelSets := s.labelSets()
+	resp := &storepb.InfoResponse{
+		MinTime:   math.MinInt64,
+		MaxTime:   math.MaxInt64,
+		StoreType: storepb.StoreType_QUERY,
+		LabelSets: make([]*storepb.LabelSet, len(labelSets)),
+	}
+	for i, lset := range labelSets {
+		resp.LabelSets[i] = &storepb.LabelSet{Labels: storepb.LabelsFromPromLabels(lset)}
+	}
+	resp.Labels = storepb.LabelsFromPromLabels(commonLabels(labelSets))
+	return resp, nil
+}
+
+// commonLabels returns the labels that are in all of labelSets
+func commonLabels(labelSets []labels.Labels) labels.Labels {
+	if len(labelSets) == 0 {
+		return nil
+	}
+	var ret labels.Labels
+	for _, l := range labelSets[0] {
+		common := true
+		for _, lset := range labelSets[1:] {
+			if lset.Get(l.Name) != l.Value {
+				common = false
+				break
+			}
+		}
+		if common {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+// Series streams all the series that match the request's matchers (sorted by their labels)
+func (s *Server) Series(req *storepb.SeriesRequest, srv storepb.Store_SeriesServer) error {
+	matchers, err := storepb.PromMatchersFromMatchers(req.Matchers)
+	if err != nil {
+		return err
+	}
+
+	q, err := s.queryable.Querier(srv.Context(), req.MinTime, req.MaxTime)
+	if err != nil {
+		return err
+	}
+	defer q.Close()
+
+	hints := &storage.SelectHints{Start: req.MinTime, End: req.MaxTime}
+	// We don't need any data, so get the (cheaper) series metadata instead
+	if req.SkipChunks {
+		hints.Func = "series"
+	}
+	set := q.Select(true, hints, matchers...)
+
+	var series []storage.Series
+	for set.Next() {
+		series = append(series, set.At())
+	}
+	if err := set.Err(); err != nil {
+		return err
+	}
+	sort.Slice(series, func(i, j int) bool {
+		return labels.Compare(series[i].Labels(), series[j].Labels()) < 0
+	})
+
+	for _, item := range series {
+		resp := &storepb.Series{Labels: storepb.LabelsFromPromLabels(item.Labels())}
+		if !req.SkipChunks {
+			if resp.Chunks, err = encodeChunks(item.Iterator(), req.MinTime, req.MaxTime); err != nil {
+				return err
+			}
+		}
+		if err := srv.Send(&storepb.SeriesResponse{Series: resp}); err != nil {
+			return err
+		}
+	}
+
+	for _, w := range set.Warnings() {
+		if err := srv.Send(&storepb.SeriesResponse{Warning: w.Error()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// encodeChunks encodes the samples of it within [minT, maxT] as XOR chunks
+func encodeChunks(it chunkenc.Iterator, minT, maxT int64) ([]*storepb.AggrChunk, error) {
+	var chunks []*storepb.AggrChunk
+	var c *chunkenc.XORChunk
+	var app chunkenc.Appender
+	var chunkMinT, chunkMaxT int64
+
+	flush := func() {
+		if c != nil {
+			chunks = append(chunks, &storepb.AggrChunk{
+				MinTime: chunkMinT,
+				MaxTime: chunkMaxT,
+				Raw:     &storepb.Chunk{Type: storepb.Chunk_XOR, Data: c.Bytes()},
+			})
+			c = nil
+		}
+	}
+
+	for it.Next() {
+		t, v := it.At()
+		if t < minT || t > maxT {
+			continue
+		}
+		if c == nil {
+			c = chunkenc.NewXORChunk()
+			var err error
+			if app, err = c.Appender(); err != nil {
+				return nil, err
+			}
+			chunkMinT = t
+		}
+		app.Append(t, v)
+		chunkMaxT = t
+		if c.NumSamples() >= maxSamplesPerChunk {
+			flush()
+		}
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return chunks, nil
+}
+
+// LabelNames returns all the label names
+func (s *Server) LabelNames(ctx context.Context, req *storepb.LabelNamesRequest) (*storepb.LabelNamesResponse, error) {
+	q, err := s.queryable.Querier(ctx, req.Start, req.End)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	names, warnings, err := q.LabelNames()
+	if err != nil {
+		return nil, err
+	}
+	return &storepb.LabelNamesResponse{Names: names, Warnings: warningStrings(warnings)}, nil
+}
+
+// LabelValues returns all the values of the request's label
+func (s *Server) LabelValues(ctx context.Context, req *storepb.LabelValuesRequest) (*storepb.LabelValuesResponse, error) {
+	q, err := s.queryable.Querier(ctx, req.Start, req.End)
+	if err != nil {
+		return nil, err
+	}
+	defer q.Close()
+
+	values, warnings, err := q.LabelValues(req.Label)
+	if err != nil {
+		return nil, err
+	}
+	return &storepb.LabelValuesResponse{Values: values, Warnings: warningStrings(warnings)}, nil
+}
+
+func warningStrings(warnings storage.Warnings) []string {
+	if len(warnings) == 0 {
+		return nil
+	}
+	ret := make([]string, len(warnings))
+	for i, w := range warnings {
+		ret[i] = w.Error()
+	}
+	return ret
+}
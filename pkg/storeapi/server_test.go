@@ -0,0 +1,129 @@
+package storeapi
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+	"github.com/prometheus/prometheus/util/teststorage"
+	"google.golang.org/grpc"
+
+	"github.com/jacksontj/promxy/pkg/promclient"
+	"github.com/jacksontj/promxy/pkg/storepb"
+)
+
+func TestServer(t *testing.T) {
+	store := teststorage.New(t)
+	defer store.Close()
+
+	// 200 samples of a (so it spans 2 chunks) and 1 of b
+	app := store.Appender(context.Background())
+	var expectedValues []model.SamplePair
+	for i := int64(0); i < 200; i++ {
+		if _, err := app.Add(labels.FromStrings("__name__", "up", "job", "a"), i*1000, float64(i)); err != nil {
+			t.Fatalf("Error adding sample: %v", err)
+		}
+		expectedValues = append(expectedValues, model.SamplePair{Timestamp: model.Time(i * 1000), Value: model.SampleValue(i)})
+	}
+	if _, err := app.Add(labels.FromStrings("__name__", "up", "job", "b"), 0, 1); err != nil {
+		t.Fatalf("Error adding sample: %v", err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("Error committing samples: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+	srv := grpc.NewServer(grpc.CustomCodec(storepb.Codec{}))
+	storepb.RegisterStoreServer(srv, NewServer(store, func() []labels.Labels {
+		return []labels.Labels{
+			labels.FromStrings("region", "eu", "sg", "a"),
+			labels.FromStrings("region", "eu", "sg", "b"),
+		}
+	}))
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	conn, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure(), grpc.WithDefaultCallOptions(grpc.ForceCodec(storepb.Codec{})))
+	if err != nil {
+		t.Fatalf("Error dialing: %v", err)
+	}
+	defer conn.Close()
+	client := storepb.NewStoreClient(conn)
+	api := &promclient.ThanosStoreAPI{Client: client}
+	ctx := context.Background()
+
+	t.Run("Info", func(t *testing.T) {
+		info, err := client.Info(ctx, &storepb.InfoRequest{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := []*storepb.Label{{Name: "region", Value: "eu"}}; !reflect.DeepEqual(info.Labels, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, info.Labels)
+		}
+		if len(info.LabelSets) != 2 {
+			t.Fatalf("Expected 2 label sets, got %v", info.LabelSets)
+		}
+	})
+
+	t.Run("Series", func(t *testing.T) {
+		v, _, err := api.GetValue(ctx, time.Unix(0, 0), time.Unix(300, 0), []*labels.Matcher{labels.MustNewMatcher(labels.MatchEqual, "job", "a")})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		expected := model.Matrix{{Metric: model.Metric{"__name__": "up", "job": "a"}, Values: expectedValues}}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, v)
+		}
+
+		lsets, _, err := api.Series(ctx, []string{"up"}, time.Unix(0, 0), time.Unix(300, 0))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := []model.LabelSet{{"__name__": "up", "job": "a"}, {"__name__": "up", "job": "b"}}; !reflect.DeepEqual(lsets, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, lsets)
+		}
+	})
+
+	t.Run("Labels", func(t *testing.T) {
+		names, _, err := api.LabelNames(ctx)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := []string{"__name__", "job"}; !reflect.DeepEqual(names, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, names)
+		}
+
+		values, _, err := api.LabelValues(ctx, "job")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if expected := (model.LabelValues{"a", "b"}); !reflect.DeepEqual(values, expected) {
+			t.Fatalf("mismatch\nexpected=%v\nactual=%v", expected, values)
+		}
+	})
+}
+
+func TestEncodeChunks(t *testing.T) {
+	samples := make([]model.SamplePair, 300)
+	for i := range samples {
+		samples[i] = model.SamplePair{Timestamp: model.Time(i), Value: model.SampleValue(i)}
+	}
+	it := promclient.NewSeriesIterator(&model.SampleStream{Values: samples})
+	chunks, err := encodeChunks(it, 10, 259)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(chunks) != 3 {
+		t.Fatalf("Expected 3 chunks, got %d", len(chunks))
+	}
+	if chunks[0].MinTime != 10 || chunks[0].MaxTime != 129 || chunks[2].MinTime != 250 || chunks[2].MaxTime != 259 {
+		t.Fatalf("Unexpected chunk times: %v", chunks)
+	}
+}
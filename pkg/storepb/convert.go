@@ -0,0 +1,73 @@
+package storepb
+
+import (
+	"fmt"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/pkg/labels"
+)
+
+// MatchersFromPromMatchers converts prometheus matchers to LabelMatchers
+func MatchersFromPromMatchers(ms []*labels.Matcher) ([]*LabelMatcher, error) {
+	ret := make([]*LabelMatcher, len(ms))
+	for i, m := range ms {
+		var t LabelMatcher_Type
+		switch m.Type {
+		case labels.MatchEqual:
+			t = LabelMatcher_EQ
+		case labels.MatchNotEqual:
+			t = LabelMatcher_NEQ
+		case labels.MatchRegexp:
+			t = LabelMatcher_RE
+		case labels.MatchNotRegexp:
+			t = LabelMatcher_NRE
+		default:
+			return nil, fmt.Errorf("unknown matcher type %v", m.Type)
+		}
+		ret[i] = &LabelMatcher{Type: t, Name: m.Name, Value: m.Value}
+	}
+	return ret, nil
+}
+
+// PromMatchersFromMatchers converts LabelMatchers to prometheus matchers
+func PromMatchersFromMatchers(ms []*LabelMatcher) ([]*labels.Matcher, error) {
+	ret := make([]*labels.Matcher, len(ms))
+	for i, m := range ms {
+		var t labels.MatchType
+		switch m.Type {
+		case LabelMatcher_EQ:
+			t = labels.MatchEqual
+		case LabelMatcher_NEQ:
+			t = labels.MatchNotEqual
+		case LabelMatcher_RE:
+			t = labels.MatchRegexp
+		case LabelMatcher_NRE:
+			t = labels.MatchNotRegexp
+		default:
+			return nil, fmt.Errorf("unknown matcher type %v", m.Type)
+		}
+		var err error
+		if ret[i], err = labels.NewMatcher(t, m.Name, m.Value); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// LabelSetFromLabels converts Labels to a model.LabelSet
+func LabelSetFromLabels(lbls []*Label) model.LabelSet {
+	ret := make(model.LabelSet, len(lbls))
+	for _, l := range lbls {
+		ret[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+	return ret
+}
+
+// LabelsFromPromLabels converts prometheus labels to Labels
+func LabelsFromPromLabels(lbls labels.Labels) []*Label {
+	ret := make([]*Label, len(lbls))
+	for i, l := range lbls {
+		ret[i] = &Label{Name: l.Name, Value: l.Value}
+	}
+	return ret
+}
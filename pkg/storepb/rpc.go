@@ -0,0 +1,185 @@
+package storepb
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// StoreClient is the client API for the Store service
+type StoreClient interface {
+	// Info returns meta information about a store e.g labels that makes that store unique and time range
+	Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error)
+	// Series streams each Series (labels and chunks) matching the request
+	Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (Store_SeriesClient, error)
+	// LabelNames returns all label names
+	LabelNames(ctx context.Context, in *LabelNamesRequest, opts ...grpc.CallOption) (*LabelNamesResponse, error)
+	// LabelValues returns all label values for the given label name
+	LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error)
+}
+
+type storeClient struct {
+	cc grpc.ClientConnInterface
+}
+
+// NewStoreClient returns a StoreClient on cc. The connection must use the Codec
+// (e.g. with `grpc.WithDefaultCallOptions(grpc.ForceCodec(storepb.Codec{}))`)
+func NewStoreClient(cc grpc.ClientConnInterface) StoreClient {
+	return &storeClient{cc}
+}
+
+func (c *storeClient) Info(ctx context.Context, in *InfoRequest, opts ...grpc.CallOption) (*InfoResponse, error) {
+	out := new(InfoResponse)
+	if err := c.cc.Invoke(ctx, "/thanos.Store/Info", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storeClient) Series(ctx context.Context, in *SeriesRequest, opts ...grpc.CallOption) (Store_SeriesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &storeServiceDesc.Streams[0], "/thanos.Store/Series", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &storeSeriesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// Store_SeriesClient is the stream of responses to a Series call
+type Store_SeriesClient interface {
+	Recv() (*SeriesResponse, error)
+	grpc.ClientStream
+}
+
+type storeSeriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *storeSeriesClient) Recv() (*SeriesResponse, error) {
+	m := new(SeriesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *storeClient) LabelNames(ctx context.Context, in *LabelNamesRequest, opts ...grpc.CallOption) (*LabelNamesResponse, error) {
+	out := new(LabelNamesResponse)
+	if err := c.cc.Invoke(ctx, "/thanos.Store/LabelNames", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *storeClient) LabelValues(ctx context.Context, in *LabelValuesRequest, opts ...grpc.CallOption) (*LabelValuesResponse, error) {
+	out := new(LabelValuesResponse)
+	if err := c.cc.Invoke(ctx, "/thanos.Store/LabelValues", in, out, opts...); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// StoreServer is the server API for the Store service
+type StoreServer interface {
+	// Info returns meta information about a store e.g labels that makes that store unique and time range
+	Info(context.Context, *InfoRequest) (*InfoResponse, error)
+	// Series streams each Series (labels and chunks) matching the request
+	Series(*SeriesRequest, Store_SeriesServer) error
+	// LabelNames returns all label names
+	LabelNames(context.Context, *LabelNamesRequest) (*LabelNamesResponse, error)
+	// LabelValues returns all label values for the given label name
+	LabelValues(context.Context, *LabelValuesRequest) (*LabelValuesResponse, error)
+}
+
+// RegisterStoreServer registers srv on s. The server must use the Codec
+// (e.g. with `grpc.CustomCodec(storepb.Codec{})`)
+func RegisterStoreServer(s *grpc.Server, srv StoreServer) {
+	s.RegisterService(&storeServiceDesc, srv)
+}
+
+func storeInfoHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(InfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreServer).Info(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/thanos.Store/Info"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreServer).Info(ctx, req.(*InfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func storeSeriesHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SeriesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(StoreServer).Series(m, &storeSeriesServer{stream})
+}
+
+// Store_SeriesServer is the stream of responses to a Series call
+type Store_SeriesServer interface {
+	Send(*SeriesResponse) error
+	grpc.ServerStream
+}
+
+type storeSeriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *storeSeriesServer) Send(m *SeriesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func storeLabelNamesHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LabelNamesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreServer).LabelNames(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/thanos.Store/LabelNames"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreServer).LabelNames(ctx, req.(*LabelNamesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func storeLabelValuesHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(LabelValuesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(StoreServer).LabelValues(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/thanos.Store/LabelValues"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(StoreServer).LabelValues(ctx, req.(*LabelValuesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var storeServiceDesc = grpc.ServiceDesc{
+	ServiceName: "thanos.Store",
+	HandlerType: (*StoreServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Info", Handler: storeInfoHandler},
+		{MethodName: "LabelNames", Handler: storeLabelNamesHandler},
+		{MethodName: "LabelValues", Handler: storeLabelValuesHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "Series", Handler: storeSeriesHandler, ServerStreams: true},
+	},
+	Metadata: "store/storepb/rpc.proto",
+}
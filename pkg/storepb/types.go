@@ -0,0 +1,229 @@
+// Package storepb contains the messages and gRPC service of the Thanos StoreAPI
+// (https://github.com/thanos-io/thanos/tree/master/pkg/store/storepb). The messages
+// are the subset of the upstream protos that promxy uses, written by hand with the
+// same field numbers so they are wire compatible with Thanos
+package storepb
+
+import (
+	proto "github.com/gogo/protobuf/proto"
+)
+
+// Codec is the gRPC codec for the StoreAPI messages, which (unlike generated
+// code) are marshaled by reflection
+type Codec struct{}
+
+// Marshal returns the wire format of v
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return proto.Marshal(v.(proto.Message))
+}
+
+// Unmarshal parses the wire format data into v
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return proto.Unmarshal(data, v.(proto.Message))
+}
+
+// Name returns the name of the codec (for the content-subtype)
+func (Codec) Name() string { return "proto" }
+
+// String returns the name of the codec
+func (c Codec) String() string { return c.Name() }
+
+// StoreType is the type of a StoreAPI server
+type StoreType int32
+
+// StoreTypes
+const (
+	StoreType_UNKNOWN StoreType = 0
+	StoreType_QUERY   StoreType = 1
+	StoreType_RULE    StoreType = 2
+	StoreType_SIDECAR StoreType = 3
+	StoreType_STORE   StoreType = 4
+	StoreType_RECEIVE StoreType = 5
+	StoreType_DEBUG   StoreType = 6
+)
+
+// PartialResponseStrategy defines what to do when some of the stores fail
+type PartialResponseStrategy int32
+
+// PartialResponseStrategies
+const (
+	PartialResponseStrategy_WARN  PartialResponseStrategy = 0
+	PartialResponseStrategy_ABORT PartialResponseStrategy = 1
+)
+
+// Label is a name/value pair
+type Label struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *Label) Reset()         { *m = Label{} }
+func (m *Label) String() string { return proto.CompactTextString(m) }
+func (*Label) ProtoMessage()    {}
+
+// LabelSet is a set of labels
+type LabelSet struct {
+	Labels []*Label `protobuf:"bytes,1,rep,name=labels,proto3"`
+}
+
+func (m *LabelSet) Reset()         { *m = LabelSet{} }
+func (m *LabelSet) String() string { return proto.CompactTextString(m) }
+func (*LabelSet) ProtoMessage()    {}
+
+// LabelMatcher_Type is the type of a LabelMatcher
+type LabelMatcher_Type int32
+
+// LabelMatcher_Types
+const (
+	LabelMatcher_EQ  LabelMatcher_Type = 0
+	LabelMatcher_NEQ LabelMatcher_Type = 1
+	LabelMatcher_RE  LabelMatcher_Type = 2
+	LabelMatcher_NRE LabelMatcher_Type = 3
+)
+
+// LabelMatcher selects series by a label
+type LabelMatcher struct {
+	Type  LabelMatcher_Type `protobuf:"varint,1,opt,name=type,proto3"`
+	Name  string            `protobuf:"bytes,2,opt,name=name,proto3"`
+	Value string            `protobuf:"bytes,3,opt,name=value,proto3"`
+}
+
+func (m *LabelMatcher) Reset()         { *m = LabelMatcher{} }
+func (m *LabelMatcher) String() string { return proto.CompactTextString(m) }
+func (*LabelMatcher) ProtoMessage()    {}
+
+// Chunk_Encoding is the encoding of a Chunk
+type Chunk_Encoding int32
+
+// Chunk_Encodings
+const (
+	Chunk_XOR Chunk_Encoding = 0
+)
+
+// Chunk is an encoded chunk of samples
+type Chunk struct {
+	Type Chunk_Encoding `protobuf:"varint,1,opt,name=type,proto3"`
+	Data []byte         `protobuf:"bytes,2,opt,name=data,proto3"`
+}
+
+func (m *Chunk) Reset()         { *m = Chunk{} }
+func (m *Chunk) String() string { return proto.CompactTextString(m) }
+func (*Chunk) ProtoMessage()    {}
+
+// AggrChunk is a chunk of raw samples and/or its downsampled aggregates
+type AggrChunk struct {
+	MinTime int64  `protobuf:"varint,1,opt,name=min_time,json=minTime,proto3"`
+	MaxTime int64  `protobuf:"varint,2,opt,name=max_time,json=maxTime,proto3"`
+	Raw     *Chunk `protobuf:"bytes,3,opt,name=raw,proto3"`
+	Count   *Chunk `protobuf:"bytes,4,opt,name=count,proto3"`
+	Sum     *Chunk `protobuf:"bytes,5,opt,name=sum,proto3"`
+	Min     *Chunk `protobuf:"bytes,6,opt,name=min,proto3"`
+	Max     *Chunk `protobuf:"bytes,7,opt,name=max,proto3"`
+	Counter *Chunk `protobuf:"bytes,8,opt,name=counter,proto3"`
+}
+
+func (m *AggrChunk) Reset()         { *m = AggrChunk{} }
+func (m *AggrChunk) String() string { return proto.CompactTextString(m) }
+func (*AggrChunk) ProtoMessage()    {}
+
+// Series is a series and its chunks
+type Series struct {
+	Labels []*Label     `protobuf:"bytes,1,rep,name=labels,proto3"`
+	Chunks []*AggrChunk `protobuf:"bytes,2,rep,name=chunks,proto3"`
+}
+
+func (m *Series) Reset()         { *m = Series{} }
+func (m *Series) String() string { return proto.CompactTextString(m) }
+func (*Series) ProtoMessage()    {}
+
+// InfoRequest requests the Info of a store
+type InfoRequest struct{}
+
+func (m *InfoRequest) Reset()         { *m = InfoRequest{} }
+func (m *InfoRequest) String() string { return proto.CompactTextString(m) }
+func (*InfoRequest) ProtoMessage()    {}
+
+// InfoResponse describes the data a store has
+type InfoResponse struct {
+	// Labels is deprecated in favor of LabelSets
+	Labels    []*Label    `protobuf:"bytes,1,rep,name=labels,proto3"`
+	MinTime   int64       `protobuf:"varint,2,opt,name=min_time,json=minTime,proto3"`
+	MaxTime   int64       `protobuf:"varint,3,opt,name=max_time,json=maxTime,proto3"`
+	StoreType StoreType   `protobuf:"varint,4,opt,name=storeType,proto3"`
+	LabelSets []*LabelSet `protobuf:"bytes,5,rep,name=label_sets,json=labelSets,proto3"`
+}
+
+func (m *InfoResponse) Reset()         { *m = InfoResponse{} }
+func (m *InfoResponse) String() string { return proto.CompactTextString(m) }
+func (*InfoResponse) ProtoMessage()    {}
+
+// SeriesRequest requests the series matching Matchers in a time range
+type SeriesRequest struct {
+	MinTime                 int64                   `protobuf:"varint,1,opt,name=min_time,json=minTime,proto3"`
+	MaxTime                 int64                   `protobuf:"varint,2,opt,name=max_time,json=maxTime,proto3"`
+	Matchers                []*LabelMatcher         `protobuf:"bytes,3,rep,name=matchers,proto3"`
+	MaxResolutionWindow     int64                   `protobuf:"varint,4,opt,name=max_resolution_window,json=maxResolutionWindow,proto3"`
+	PartialResponseDisabled bool                    `protobuf:"varint,6,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3"`
+	PartialResponseStrategy PartialResponseStrategy `protobuf:"varint,7,opt,name=partial_response_strategy,json=partialResponseStrategy,proto3"`
+	// SkipChunks requests only the labels of the series
+	SkipChunks bool `protobuf:"varint,8,opt,name=skip_chunks,json=skipChunks,proto3"`
+}
+
+func (m *SeriesRequest) Reset()         { *m = SeriesRequest{} }
+func (m *SeriesRequest) String() string { return proto.CompactTextString(m) }
+func (*SeriesRequest) ProtoMessage()    {}
+
+// SeriesResponse is one of a Series or a Warning (a oneof upstream, which is the same on the wire)
+type SeriesResponse struct {
+	Series  *Series `protobuf:"bytes,1,opt,name=series,proto3"`
+	Warning string  `protobuf:"bytes,2,opt,name=warning,proto3"`
+}
+
+func (m *SeriesResponse) Reset()         { *m = SeriesResponse{} }
+func (m *SeriesResponse) String() string { return proto.CompactTextString(m) }
+func (*SeriesResponse) ProtoMessage()    {}
+
+// LabelNamesRequest requests the label names in a time range
+type LabelNamesRequest struct {
+	PartialResponseDisabled bool                    `protobuf:"varint,1,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3"`
+	PartialResponseStrategy PartialResponseStrategy `protobuf:"varint,2,opt,name=partial_response_strategy,json=partialResponseStrategy,proto3"`
+	Start                   int64                   `protobuf:"varint,4,opt,name=start,proto3"`
+	End                     int64                   `protobuf:"varint,5,opt,name=end,proto3"`
+}
+
+func (m *LabelNamesRequest) Reset()         { *m = LabelNamesRequest{} }
+func (m *LabelNamesRequest) String() string { return proto.CompactTextString(m) }
+func (*LabelNamesRequest) ProtoMessage()    {}
+
+// LabelNamesResponse is the label names in a time range
+type LabelNamesResponse struct {
+	Names    []string `protobuf:"bytes,1,rep,name=names,proto3"`
+	Warnings []string `protobuf:"bytes,2,rep,name=warnings,proto3"`
+}
+
+func (m *LabelNamesResponse) Reset()         { *m = LabelNamesResponse{} }
+func (m *LabelNamesResponse) String() string { return proto.CompactTextString(m) }
+func (*LabelNamesResponse) ProtoMessage()    {}
+
+// LabelValuesRequest requests the values of a label in a time range
+type LabelValuesRequest struct {
+	Label                   string                  `protobuf:"bytes,1,opt,name=label,proto3"`
+	PartialResponseDisabled bool                    `protobuf:"varint,2,opt,name=partial_response_disabled,json=partialResponseDisabled,proto3"`
+	PartialResponseStrategy PartialResponseStrategy `protobuf:"varint,3,opt,name=partial_response_strategy,json=partialResponseStrategy,proto3"`
+	Start                   int64                   `protobuf:"varint,5,opt,name=start,proto3"`
+	End                     int64                   `protobuf:"varint,6,opt,name=end,proto3"`
+}
+
+func (m *LabelValuesRequest) Reset()         { *m = LabelValuesRequest{} }
+func (m *LabelValuesRequest) String() string { return proto.CompactTextString(m) }
+func (*LabelValuesRequest) ProtoMessage()    {}
+
+// LabelValuesResponse is the values of a label in a time range
+type LabelValuesResponse struct {
+	Values   []string `protobuf:"bytes,1,rep,name=values,proto3"`
+	Warnings []string `protobuf:"bytes,2,rep,name=warnings,proto3"`
+}
+
+func (m *LabelValuesResponse) Reset()         { *m = LabelValuesResponse{} }
+func (m *LabelValuesResponse) String() string { return proto.CompactTextString(m) }
+func (*LabelValuesResponse) ProtoMessage()    {}